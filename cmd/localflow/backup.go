@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runBackup snapshots the database of a running server, either on the
+// server or downloaded to a local file.
+func runBackup(args []string) error {
+	cmd := newCLICommand("backup")
+	var (
+		path = cmd.fs.String("path", "", "write the snapshot to this path on the server, inside its backup.dir (default: a new timestamped file there)")
+		out  = cmd.fs.String("out", "", "download the snapshot to this local file instead, - for stdout")
+	)
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if *path != "" && *out != "" {
+		return fmt.Errorf("-path and -out are mutually exclusive")
+	}
+	ctx := context.Background()
+
+	if *out == "" {
+		b, err := cmd.client().Backup(ctx, *path)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, b)
+		}
+		fmt.Printf("%s (%d bytes in %s)\n", b.Path, b.Size, b.Took)
+		return nil
+	}
+
+	if *out == "-" {
+		return cmd.client().DownloadBackup(ctx, os.Stdout)
+	}
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists", *out)
+	}
+	// Download next to the target so a failed transfer leaves no file
+	// that looks like a good snapshot.
+	f, err := os.CreateTemp(filepath.Dir(*out), filepath.Base(*out)+".partial-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := cmd.client().DownloadBackup(ctx, f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), *out); err != nil {
+		return err
+	}
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, map[string]string{"path": *out})
+	}
+	fmt.Println(*out)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runCalendar manages exclusion calendars, e.g. company holidays, that
+// schedules skip.
+func runCalendar(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: localflow calendar set|get|list|delete")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := newCLICommand("calendar " + args[0])
+	switch args[0] {
+	case "set":
+		var (
+			description = cmd.fs.String("description", "", "calendar description")
+			dates       = cmd.fs.String("dates", "", "comma-separated YYYY-MM-DD dates")
+			file        = cmd.fs.String("file", "", "read dates from a file, one per line (# starts a comment)")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		name, err := cmd.arg("calendar name")
+		if err != nil {
+			return err
+		}
+		var list []string
+		for _, d := range strings.Split(*dates, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				list = append(list, d)
+			}
+		}
+		if *file != "" {
+			fromFile, err := readDates(*file)
+			if err != nil {
+				return err
+			}
+			list = append(list, fromFile...)
+		}
+		if err := cmd.client().PutCalendar(ctx, name, *description, list); err != nil {
+			return err
+		}
+		return printID(cmd, name)
+
+	case "get":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		name, err := cmd.arg("calendar name")
+		if err != nil {
+			return err
+		}
+		cal, err := cmd.client().GetCalendar(ctx, name)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, cal)
+		}
+		for _, d := range cal.Dates {
+			fmt.Println(d)
+		}
+		return nil
+
+	case "list":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		list, err := cmd.client().ListCalendars(ctx)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, list)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tDESCRIPTION\tUPDATED")
+		for _, c := range list {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", c.Name, c.Description, c.UpdatedAt)
+		}
+		return tw.Flush()
+
+	case "delete":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		name, err := cmd.arg("calendar name")
+		if err != nil {
+			return err
+		}
+		if err := cmd.client().DeleteCalendar(ctx, name); err != nil {
+			return err
+		}
+		return printID(cmd, name)
+	}
+	return fmt.Errorf("unknown calendar command %q", args[0])
+}
+
+// readDates reads one date per line, ignoring blank lines and # comments.
+func readDates(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var dates []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			dates = append(dates, line)
+		}
+	}
+	return dates, sc.Err()
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"localflow/internal/client"
+	"localflow/internal/domain"
+)
+
+// cliCommand holds the flags shared by every client subcommand.
+type cliCommand struct {
+	fs     *flag.FlagSet
+	server *string
+	apiKey *string
+	output *string
+}
+
+func newCLICommand(name string) *cliCommand {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	server := os.Getenv("LOCALFLOW_SERVER")
+	if server == "" {
+		server = "http://127.0.0.1:8080"
+	}
+	return &cliCommand{
+		fs:     fs,
+		server: fs.String("server", server, "localflow server URL (env LOCALFLOW_SERVER)"),
+		apiKey: fs.String("api-key", os.Getenv("LOCALFLOW_API_KEY"), "API key (env LOCALFLOW_API_KEY)"),
+		output: fs.String("o", "table", "output format: table or json"),
+	}
+}
+
+func (c *cliCommand) parse(args []string) error {
+	if err := c.fs.Parse(args); err != nil {
+		return err
+	}
+	if *c.output != "table" && *c.output != "json" {
+		return fmt.Errorf("invalid output format %q", *c.output)
+	}
+	return nil
+}
+
+func (c *cliCommand) client() *client.Client {
+	cl := client.New(*c.server)
+	cl.APIKey = *c.apiKey
+	return cl
+}
+
+// arg returns the single positional argument (usually an ID).
+func (c *cliCommand) arg(what string) (string, error) {
+	if c.fs.NArg() != 1 {
+		return "", fmt.Errorf("expected exactly one %s argument", what)
+	}
+	return c.fs.Arg(0), nil
+}
+
+func (c *cliCommand) json() bool { return *c.output == "json" }
+
+func runTask(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: localflow task submit|get|list|cancel|retry|logs|watch")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := newCLICommand("task " + args[0])
+	switch args[0] {
+	case "submit":
+		var (
+			taskType    = cmd.fs.String("type", "", "task type (required)")
+			payload     = cmd.fs.String("payload", "{}", "JSON payload, or @file to read it from a file")
+			priority    = cmd.fs.Int("priority", 0, "priority (higher runs first)")
+			maxAttempts = cmd.fs.Int("max-attempts", 0, "maximum attempts")
+			idemKey     = cmd.fs.String("idempotency-key", "", "idempotency key")
+			ttl         = cmd.fs.Duration("ttl", 0, "expire the task if it hasn't started within this duration")
+			expiresAt   = cmd.fs.String("expires-at", "", "expire the task if it hasn't started by this RFC3339 time")
+			uniqueKey   = cmd.fs.String("unique-key", "", "allow one task of this type with this key, see -unique")
+			uniqueMode  = cmd.fs.String("unique", "", "what a unique task conflicts with: queued, active (default) or window")
+			uniqueWin   = cmd.fs.Duration("unique-window", 0, "for -unique window, how long after a task is created it conflicts")
+			onConflict  = cmd.fs.String("on-conflict", "", "on a unique conflict: existing (default), replace or reject")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		if *taskType == "" {
+			return fmt.Errorf("-type is required")
+		}
+		body, err := readPayload(*payload)
+		if err != nil {
+			return err
+		}
+		req := client.SubmitTask{Type: *taskType, Payload: body, Priority: *priority, MaxAttempts: *maxAttempts}
+		if *idemKey != "" {
+			req.IdempotencyKey = idemKey
+		}
+		if *ttl != 0 {
+			if *ttl < time.Second {
+				return fmt.Errorf("-ttl must be at least 1s")
+			}
+			req.TTL = int((*ttl + time.Second - 1) / time.Second)
+		}
+		if *expiresAt != "" {
+			t, err := time.Parse(time.RFC3339, *expiresAt)
+			if err != nil {
+				return fmt.Errorf("-expires-at: %w", err)
+			}
+			req.ExpiresAt = &t
+		}
+		if *uniqueKey != "" {
+			req.Unique = &client.Unique{Key: *uniqueKey, Mode: *uniqueMode, Window: int(*uniqueWin / time.Second), OnConflict: *onConflict}
+		} else if *uniqueMode != "" || *uniqueWin != 0 || *onConflict != "" {
+			return fmt.Errorf("-unique, -unique-window and -on-conflict need -unique-key")
+		}
+		sub, err := cmd.client().SubmitTask(ctx, req)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, sub)
+		}
+		if sub.Existing {
+			fmt.Fprintln(os.Stderr, "existing task")
+		}
+		fmt.Println(sub.ID)
+		return nil
+
+	case "get", "cancel", "retry":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("task ID")
+		if err != nil {
+			return err
+		}
+		c := cmd.client()
+		var t client.Task
+		switch args[0] {
+		case "get":
+			t, err = c.GetTask(ctx, id)
+		case "cancel":
+			t, err = c.CancelTask(ctx, id)
+		case "retry":
+			t, err = c.RetryTask(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, t)
+		}
+		return printTasks(cmd, []client.Task{t})
+
+	case "list":
+		var (
+			state    = cmd.fs.String("state", "", "filter by state")
+			taskType = cmd.fs.String("type", "", "filter by task type")
+			schedule = cmd.fs.String("schedule", "", "filter by the schedule that enqueued the task")
+			limit    = cmd.fs.Int("limit", 50, "maximum number of tasks")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		tasks, err := cmd.client().ListTasks(ctx, *state, *taskType, *schedule, *limit)
+		if err != nil {
+			return err
+		}
+		return printTasks(cmd, tasks)
+
+	case "logs":
+		follow := cmd.fs.Bool("f", false, "follow the log until the task finishes")
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("task ID")
+		if err != nil {
+			return err
+		}
+		// Following may outlive the default request deadline.
+		logCtx := ctx
+		if *follow {
+			logCtx = context.Background()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		return cmd.client().TaskLogs(logCtx, id, *follow, func(l client.LogLine) error {
+			if cmd.json() {
+				return enc.Encode(l)
+			}
+			_, err := fmt.Printf("[#%d %s] %s\n", l.Attempt, l.Stream, l.Line)
+			return err
+		})
+
+	case "watch":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("task ID")
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		// Watching lasts until the task finishes.
+		return cmd.client().WatchTask(context.Background(), id, func(ev client.TaskEvent) error {
+			if cmd.json() {
+				return enc.Encode(ev)
+			}
+			line := fmt.Sprintf("%s %s attempts=%d", ev.Time, ev.State, ev.Attempts)
+			if ev.Progress != nil {
+				line += fmt.Sprintf(" %.0f%%", *ev.Progress)
+			}
+			if ev.StatusMessage != "" {
+				line += " " + ev.StatusMessage
+			}
+			_, err := fmt.Println(line)
+			return err
+		})
+	}
+	return fmt.Errorf("unknown task command %q", args[0])
+}
+
+func runSchedule(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: localflow schedule create|list|get|enable|disable|delete|trigger|runs|misfires|preview|upcoming")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := newCLICommand("schedule " + args[0])
+	switch args[0] {
+	case "create":
+		var (
+			name        = cmd.fs.String("name", "", "schedule name (required)")
+			cronExpr    = cmd.fs.String("cron", "", "cron expression (required)")
+			timezone    = cmd.fs.String("tz", "", "IANA time zone the cron expression is evaluated in (default server local)")
+			taskType    = cmd.fs.String("type", "", "task type (required)")
+			payload     = cmd.fs.String("payload", "{}", "JSON payload, or @file to read it from a file")
+			priority    = cmd.fs.Int("priority", 0, "task priority")
+			maxAttempts = cmd.fs.Int("max-attempts", 0, "maximum attempts per task")
+			disabled    = cmd.fs.Bool("disabled", false, "create the schedule disabled")
+			misfire     = cmd.fs.String("misfire", "", "misfire policy: run_once (default), skip or run_all")
+			grace       = cmd.fs.Duration("misfire-grace", 0, "how late a fire may be and still run on time (default 1m)")
+			maxCatchup  = cmd.fs.Int("max-catchup", 0, "missed fires to enqueue with -misfire run_all (default 100)")
+			concurrency = cmd.fs.String("concurrency", "", "when a previous run is still active: allow (default), forbid or replace")
+			start       = cmd.fs.String("start", "", "first time the schedule may fire (RFC 3339 or YYYY-MM-DD)")
+			end         = cmd.fs.String("end", "", "last time the schedule may fire (RFC 3339 or YYYY-MM-DD)")
+			exclude     = cmd.fs.String("exclude", "", "comma-separated calendars whose dates the schedule skips")
+			jitter      = cmd.fs.Duration("jitter", 0, "delay every run by a fixed offset within this window, e.g. 5m")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" || *cronExpr == "" || *taskType == "" {
+			return fmt.Errorf("-name, -cron and -type are required")
+		}
+		body, err := readPayload(*payload)
+		if err != nil {
+			return err
+		}
+		startAt, err := parseFlagTime("start", *start)
+		if err != nil {
+			return err
+		}
+		endAt, err := parseFlagTime("end", *end)
+		if err != nil {
+			return err
+		}
+		var calendars []string
+		if *exclude != "" {
+			calendars = strings.Split(*exclude, ",")
+		}
+		jitterSecs := int(jitter.Seconds())
+		id, err := cmd.client().CreateSchedule(ctx, client.CreateSchedule{
+			Name:              *name,
+			CronExpr:          *cronExpr,
+			Timezone:          *timezone,
+			TaskType:          *taskType,
+			Payload:           body,
+			Priority:          *priority,
+			MaxAttempts:       *maxAttempts,
+			Enabled:           !*disabled,
+			MisfirePolicy:     *misfire,
+			MisfireGrace:      int(grace.Seconds()),
+			MaxCatchup:        *maxCatchup,
+			ConcurrencyPolicy: *concurrency,
+			StartAt:           startAt,
+			EndAt:             endAt,
+			ExcludeCalendars:  calendars,
+			Jitter:            &jitterSecs,
+		})
+		if err != nil {
+			return err
+		}
+		return printID(cmd, id)
+
+	case "list":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		schedules, err := cmd.client().ListSchedules(ctx)
+		if err != nil {
+			return err
+		}
+		return printSchedules(cmd, schedules)
+
+	case "get", "enable", "disable":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		c := cmd.client()
+		var s domain.Schedule
+		if args[0] == "get" {
+			s, err = c.GetSchedule(ctx, id)
+		} else {
+			s, err = c.UpdateSchedule(ctx, id, client.CreateSchedule{Enabled: args[0] == "enable"})
+		}
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, s)
+		}
+		return printSchedules(cmd, []domain.Schedule{s})
+
+	case "delete":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		if err := cmd.client().DeleteSchedule(ctx, id); err != nil {
+			return err
+		}
+		return printID(cmd, id)
+
+	case "trigger":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		taskID, err := cmd.client().TriggerSchedule(ctx, id)
+		if err != nil {
+			return err
+		}
+		return printID(cmd, taskID)
+
+	case "runs":
+		limit := cmd.fs.Int("limit", 20, "maximum number of runs")
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		runs, err := cmd.client().ScheduleRuns(ctx, id, *limit)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, runs)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TASK\tSTATE\tATTEMPTS\tCREATED\tUPDATED\tERROR")
+		for _, r := range runs {
+			fmt.Fprintf(tw, "%s\t%s\t%d/%d\t%s\t%s\t%s\n", r.ID, r.State, r.Attempts, r.MaxAttempts, r.CreatedAt, r.UpdatedAt, r.Error)
+		}
+		return tw.Flush()
+
+	case "misfires":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		misfires, err := cmd.client().ScheduleMisfires(ctx, id)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, misfires)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "FIRE TIME\tREASON\tRECORDED")
+		for _, m := range misfires {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", m.FireTime, m.Reason, m.CreatedAt)
+		}
+		return tw.Flush()
+
+	case "preview":
+		var (
+			cronExpr = cmd.fs.String("cron", "", "cron expression (required)")
+			timezone = cmd.fs.String("tz", "", "IANA time zone the cron expression is evaluated in (default server local)")
+			exclude  = cmd.fs.String("exclude", "", "comma-separated calendars whose dates the schedule skips")
+			n        = cmd.fs.Int("n", 10, "number of fire times")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		if *cronExpr == "" {
+			return fmt.Errorf("-cron is required")
+		}
+		var calendars []string
+		if *exclude != "" {
+			calendars = strings.Split(*exclude, ",")
+		}
+		up, err := cmd.client().PreviewSchedule(ctx, *cronExpr, *timezone, calendars, *n)
+		if err != nil {
+			return err
+		}
+		return printUpcoming(cmd, up)
+
+	case "upcoming":
+		n := cmd.fs.Int("n", 10, "number of fire times")
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		id, err := cmd.arg("schedule ID")
+		if err != nil {
+			return err
+		}
+		up, err := cmd.client().ScheduleUpcoming(ctx, id, *n)
+		if err != nil {
+			return err
+		}
+		return printUpcoming(cmd, up)
+	}
+	return fmt.Errorf("unknown schedule command %q", args[0])
+}
+
+func printUpcoming(cmd *cliCommand, up client.Upcoming) error {
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, up)
+	}
+	fmt.Printf("%s (%s)\n", up.Description, up.Timezone)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOCAL\tUTC\tIN")
+	for _, f := range up.Next {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.Local, f.Time, f.In)
+	}
+	return tw.Flush()
+}
+
+func runStats(args []string) error {
+	cmd := newCLICommand("stats")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	st, err := cmd.client().Stats(ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, st)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK STATE\tCOUNT")
+	states := make([]string, 0, len(st.Tasks))
+	for state := range st.Tasks {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+	for _, state := range states {
+		fmt.Fprintf(tw, "%s\t%d\n", state, st.Tasks[state])
+	}
+	fmt.Fprintf(tw, "\nschedules\t%d (%d enabled)\n", st.Schedules["total"], st.Schedules["enabled"])
+	return tw.Flush()
+}
+
+// readPayload accepts inline JSON or "@path" and validates it.
+func readPayload(v string) (json.RawMessage, error) {
+	data := []byte(v)
+	if strings.HasPrefix(v, "@") {
+		b, err := os.ReadFile(v[1:])
+		if err != nil {
+			return nil, err
+		}
+		data = b
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("payload is not valid JSON")
+	}
+	return data, nil
+}
+
+// parseFlagTime parses an optional RFC 3339 time or a date, which means
+// midnight in the local zone.
+func parseFlagTime(flag, v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02", v, time.Local)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-%s: want RFC 3339 time or YYYY-MM-DD, got %q", flag, v)
+	}
+	return &t, nil
+}
+
+func printID(cmd *cliCommand, id string) error {
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, map[string]string{"id": id})
+	}
+	fmt.Println(id)
+	return nil
+}
+
+func printTasks(cmd *cliCommand, tasks []client.Task) error {
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, tasks)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTYPE\tSTATE\tATTEMPTS\tPRIORITY\tPROGRESS\tNEXT RUN\tCREATED")
+	for _, t := range tasks {
+		progress := "-"
+		if t.Progress != nil {
+			progress = fmt.Sprintf("%.0f%%", *t.Progress)
+		}
+		if t.StatusMessage != "" {
+			progress += " " + t.StatusMessage
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%d\t%s\t%s\t%s\n", t.ID, t.Type, t.State, t.Attempts, t.MaxAttempts, t.Priority, progress, t.NextRunAt, t.CreatedAt)
+	}
+	return tw.Flush()
+}
+
+func printSchedules(cmd *cliCommand, schedules []domain.Schedule) error {
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, schedules)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCRON\tTZ\tTYPE\tENABLED\tLAST RUN\tNEXT RUN")
+	for _, s := range schedules {
+		lastRun := "-"
+		if s.LastRun != nil {
+			lastRun = s.LastRun.Format(time.RFC3339)
+		}
+		nextRun := "-"
+		if !s.NextRun.IsZero() {
+			nextRun = s.NextRun.Format(time.RFC3339)
+		}
+		tz := s.Timezone
+		if tz == "" {
+			tz = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%t\t%s\t%s\n", s.ID, s.Name, s.CronExpr, tz, s.TaskType, s.Enabled, lastRun, nextRun)
+	}
+	return tw.Flush()
+}
+
+func writeJSONOut(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"localflow/internal/config"
+	httphandler "localflow/internal/handlers/http"
+	"localflow/internal/handlers/shell"
+	"localflow/internal/queue"
+	"localflow/internal/retention"
+	"localflow/internal/scheduler"
+	"localflow/internal/secrets"
+	"localflow/internal/worker"
+)
+
+// newHandlers returns the registry of built-in task handlers. store may be
+// nil when no master key is configured.
+func newHandlers(cfg config.Config, store *secrets.Store) (map[string]worker.Handler, error) {
+	sandbox, err := shellSandbox(cfg.Shell)
+	if err != nil {
+		return nil, err
+	}
+	resolver := secretResolver(cfg.Secrets, store)
+	return map[string]worker.Handler{
+		"shell": shell.Shell{Sandbox: sandbox, Secrets: resolver},
+		"http":  httphandler.HTTP{Secrets: resolver},
+	}, nil
+}
+
+// secretResolver looks secrets up in the environment, the encrypted store
+// and then the secrets directory.
+func secretResolver(sc config.SecretsConfig, store *secrets.Store) secrets.Resolver {
+	chain := secrets.Chain{secrets.Env{}}
+	if store != nil {
+		chain = append(chain, store)
+	}
+	if sc.Dir != "" {
+		chain = append(chain, secrets.Dir(sc.Dir))
+	}
+	return chain
+}
+
+// secretStore opens the encrypted secrets store, or returns nil if no master
+// key is configured.
+func secretStore(sc config.SecretsConfig, repo secrets.Repo) (*secrets.Store, error) {
+	key, err := secrets.LoadMasterKey(sc.MasterKeyFile)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	return secrets.NewStore(repo, key)
+}
+
+func shellSandbox(sc config.ShellConfig) (*shell.Sandbox, error) {
+	if !sc.Sandbox {
+		return nil, nil
+	}
+	sb := &shell.Sandbox{
+		Dir:        sc.Dir,
+		InheritEnv: sc.InheritEnv,
+		Env:        sc.Env,
+		AllowEnv:   sc.AllowEnv,
+		User:       sc.User,
+		MaxOutput:  sc.MaxOutput,
+		Limits: shell.Limits{
+			CPUTime:   sc.Limits.CPUTime,
+			Memory:    sc.Limits.Memory,
+			OpenFiles: sc.Limits.OpenFiles,
+			Processes: sc.Limits.Processes,
+		},
+		Cgroup: shell.Cgroup{
+			Enabled: sc.Cgroup.Enabled,
+			Parent:  sc.Cgroup.Parent,
+			CPU:     sc.Cgroup.CPU,
+			Memory:  sc.Cgroup.Memory,
+		},
+	}
+	for _, pattern := range sc.AllowEnv {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("shell allow_env %q: %w", pattern, err)
+		}
+	}
+	for _, r := range sc.Allow {
+		rule := shell.Rule{Command: r.Command}
+		for _, pattern := range r.Args {
+			re, err := shell.CompileArg(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("shell allow %s: %w", r.Command, err)
+			}
+			rule.Args = append(rule.Args, re)
+		}
+		sb.Allow = append(sb.Allow, rule)
+	}
+	return sb, nil
+}
+
+// validateConfig runs config validation plus checks that need the handler
+// registry.
+func validateConfig(cfg config.Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if _, err := secrets.LoadMasterKey(cfg.Secrets.MasterKeyFile); err != nil {
+		return err
+	}
+	handlers, err := newHandlers(cfg, nil)
+	if err != nil {
+		return err
+	}
+	var unknown []string
+	for name := range cfg.Handlers {
+		if _, ok := handlers[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("handlers: unknown task types %v", unknown)
+	}
+	if cfg.ScheduleDir != "" {
+		if _, err := scheduler.LoadDefinitions(cfg.ScheduleDir); err != nil {
+			return fmt.Errorf("schedule_dir: %w", err)
+		}
+	}
+	return nil
+}
+
+func taskDefaults(cfg config.Config) queue.TaskDefaults {
+	d := queue.TaskDefaults{
+		Priority:          cfg.Defaults.Priority,
+		MaxAttempts:       cfg.Defaults.MaxAttempts,
+		VisibilityTimeout: int(cfg.Defaults.VisibilityTimeout.Seconds()),
+		MaxAttemptsByType: map[string]int{},
+	}
+	for name, h := range cfg.Handlers {
+		if h.Retry.MaxAttempts > 0 {
+			d.MaxAttemptsByType[name] = h.Retry.MaxAttempts
+		}
+	}
+	return d
+}
+
+func handlerOptions(cfg config.Config) map[string]worker.HandlerOptions {
+	opts := make(map[string]worker.HandlerOptions, len(cfg.Handlers))
+	for name, h := range cfg.Handlers {
+		opts[name] = worker.HandlerOptions{
+			Timeout:     h.Timeout,
+			Concurrency: h.Concurrency,
+			BackoffBase: h.Retry.BackoffBase,
+			BackoffMax:  h.Retry.BackoffMax,
+		}
+	}
+	return opts
+}
+
+// retentionRules turns the retention periods into pruning rules.
+func retentionRules(rc config.RetentionConfig) []retention.Rule {
+	periods := map[string]time.Duration{
+		"succeeded": rc.Succeeded,
+		"failed":    rc.Failed,
+		"canceled":  rc.Canceled,
+		"expired":   rc.Expired,
+	}
+	types := make(map[string]map[string]time.Duration, len(rc.Types))
+	for name, o := range rc.Types {
+		overrides := map[string]time.Duration{}
+		for state, d := range map[string]*time.Duration{"succeeded": o.Succeeded, "failed": o.Failed, "canceled": o.Canceled, "expired": o.Expired} {
+			if d != nil {
+				overrides[state] = *d
+			}
+		}
+		types[name] = overrides
+	}
+	return retention.Rules(periods, types)
+}
+
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return fmt.Errorf("usage: localflow config check [-config file]")
+	}
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	cfgPath := fs.String("config", os.Getenv("LOCALFLOW_CONFIG"), "YAML config file (env LOCALFLOW_CONFIG)")
+	quiet := fs.Bool("q", false, "only report errors")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(cfg); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	if *quiet {
+		return nil
+	}
+	out, err := cfg.YAML()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "config OK")
+	_, err = os.Stdout.Write(out)
+	return err
+}
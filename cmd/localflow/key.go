@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"localflow/internal/auth"
+	"localflow/internal/config"
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+)
+
+// runKey manages API keys. It works on the database directly so the first
+// admin key can be created before the server accepts authenticated requests.
+func runKey(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: localflow key create|list|revoke")
+	}
+	fs := flag.NewFlagSet("key "+args[0], flag.ExitOnError)
+	cfgPath := fs.String("config", os.Getenv("LOCALFLOW_CONFIG"), "YAML config file (env LOCALFLOW_CONFIG)")
+	dbPath := fs.String("db", "", "SQLite DB path (default from config)")
+	output := fs.String("o", "table", "output format: table or json")
+
+	var name, scopes *string
+	if args[0] == "create" {
+		name = fs.String("name", "", "key name (required)")
+		scopes = fs.String("scopes", auth.ScopeRead, "comma separated scopes: read, submit, admin")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		return err
+	}
+	if *dbPath != "" {
+		cfg.DB = *dbPath
+	}
+	db, err := openDB(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	repo := queue.NewSQLiteRepo(db)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "create":
+		if *name == "" {
+			return fmt.Errorf("-name is required")
+		}
+		sc, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			return err
+		}
+		token, hash, err := auth.NewToken(auth.KeyPrefix)
+		if err != nil {
+			return err
+		}
+		id, err := repo.CreateAPIKey(ctx, domain.APIKey{Name: *name, KeyHash: hash, Scopes: sc})
+		if err != nil {
+			return err
+		}
+		if *output == "json" {
+			return writeJSONOut(os.Stdout, map[string]any{"id": id, "name": *name, "scopes": sc, "key": token})
+		}
+		fmt.Printf("id:     %s\nscopes: %s\nkey:    %s\n", id, strings.Join(sc, ","), token)
+		fmt.Fprintln(os.Stderr, "Store the key now; it cannot be shown again.")
+		return nil
+
+	case "list":
+		keys, err := repo.ListAPIKeys(ctx)
+		if err != nil {
+			return err
+		}
+		if *output == "json" {
+			views := make([]map[string]any, 0, len(keys))
+			for _, k := range keys {
+				views = append(views, map[string]any{
+					"id": k.ID, "name": k.Name, "scopes": k.Scopes,
+					"created_at": k.CreatedAt, "revoked_at": k.RevokedAt,
+				})
+			}
+			return writeJSONOut(os.Stdout, views)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tCREATED\tREVOKED")
+		for _, k := range keys {
+			revoked := "-"
+			if k.RevokedAt != nil {
+				revoked = k.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, strings.Join(k.Scopes, ","), k.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("expected exactly one key ID argument")
+		}
+		if err := repo.RevokeAPIKey(ctx, fs.Arg(0)); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("no active key %s", fs.Arg(0))
+			}
+			return err
+		}
+		fmt.Println(fs.Arg(0))
+		return nil
+	}
+	return fmt.Errorf("unknown key command %q", args[0])
+}
@@ -9,6 +9,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,73 +18,224 @@ import (
 	_ "modernc.org/sqlite"
 
 	"localflow/internal/api"
-	httphandler "localflow/internal/handlers/http"
+	"localflow/internal/backup"
+	"localflow/internal/config"
 	"localflow/internal/handlers/shell"
 	"localflow/internal/queue"
+	"localflow/internal/retention"
 	"localflow/internal/scheduler"
 	"localflow/internal/worker"
 )
 
 func main() {
+	shell.RunRlimitHelper()
+
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "serve":
+		serve(args[1:])
+		return
+	case "task":
+		err = runTask(args[1:])
+	case "schedule":
+		err = runSchedule(args[1:])
+	case "stats":
+		err = runStats(args[1:])
+	case "config":
+		err = runConfig(args[1:])
+	case "key":
+		err = runKey(args[1:])
+	case "secret":
+		err = runSecret(args[1:])
+	case "calendar":
+		err = runCalendar(args[1:])
+	case "backup":
+		err = runBackup(args[1:])
+	case "export":
+		err = runExport(args[1:])
+	case "import":
+		err = runImport(args[1:])
+	case "help", "-h", "--help":
+		usage()
+		return
+	default:
+		err = fmt.Errorf("unknown command %q", args[0])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, `Usage: localflow [command] [flags]
+
+Commands:
+  serve                                   start the server (default)
+  task submit|get|list|cancel|retry|logs|watch
+                                          manage tasks on a running server
+  schedule create|list|get|enable|disable|delete|trigger|runs|misfires|preview|upcoming
+                                          manage schedules on a running server
+  stats                                   show queue and schedule counts
+  config check                            validate configuration and print it
+  key create|list|revoke                  manage API keys (operates on the DB directly)
+  secret set|list|delete|gen-key          manage encrypted secrets on a running server
+  calendar set|get|list|delete            manage calendars of dates schedules can exclude
+  backup                                  snapshot the database of a running server
+  export                                  write schedules, calendars and tasks to a file
+  import FILE                             load an export into a running server
+
+Run "localflow <command> -h" for command flags.
+`)
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	def := config.Default()
 	var (
-		addr     = flag.String("addr", ":8080", "HTTP bind address")
-		dbPath   = flag.String("db", "localflow.db", "SQLite DB path")
-		workers  = flag.Int("workers", 8, "number of worker goroutines")
-		poll     = flag.Duration("poll", 250*time.Millisecond, "poll interval for queue")
-		debug    = flag.Bool("debug", false, "enable debug mode with pprof endpoints")
-		schedInt = flag.Duration("schedule-interval", 10*time.Second, "schedule check interval")
+		cfgPath  = fs.String("config", os.Getenv("LOCALFLOW_CONFIG"), "YAML config file (env LOCALFLOW_CONFIG)")
+		addr     = fs.String("addr", def.Addr, "HTTP bind address")
+		dbPath   = fs.String("db", def.DB, "SQLite DB path")
+		workers  = fs.Int("workers", def.Workers, "number of worker goroutines")
+		poll     = fs.Duration("poll", def.Poll, "poll interval for queue")
+		debug    = fs.Bool("debug", def.Debug, "enable debug mode with pprof endpoints")
+		schedInt = fs.Duration("schedule-interval", def.ScheduleInterval, "schedule check interval")
+		maxFires = fs.Int("schedule-max-fires", def.ScheduleMaxFires, "tasks one schedule check may enqueue, 0 for no limit")
+		schedDir = fs.String("schedule-dir", def.ScheduleDir, "directory of YAML schedule definitions, reloaded on SIGHUP")
 	)
-	flag.Parse()
+	_ = fs.Parse(args)
 
 	zerolog.TimeFieldFormat = time.RFC3339
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
-	dsn := fmt.Sprintf("file:%s?cache=shared&mode=rwc&_pragma=journal_mode(WAL)", *dbPath)
-	db, err := sql.Open("sqlite", dsn)
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		log.Fatal().Err(err).Msg("load config")
+	}
+	// Flags given explicitly on the command line win over file and env.
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "db":
+			cfg.DB = *dbPath
+		case "workers":
+			cfg.Workers = *workers
+		case "poll":
+			cfg.Poll = *poll
+		case "debug":
+			cfg.Debug = *debug
+		case "schedule-interval":
+			cfg.ScheduleInterval = *schedInt
+		case "schedule-max-fires":
+			cfg.ScheduleMaxFires = *maxFires
+		case "schedule-dir":
+			cfg.ScheduleDir = *schedDir
+		}
+	})
+	if err := validateConfig(cfg); err != nil {
+		log.Fatal().Err(err).Msg("invalid config")
+	}
+	db, err := openDB(cfg.DB)
 	if err != nil {
 		log.Fatal().Err(err).Msg("open db")
 	}
 	defer db.Close()
-	db.SetMaxOpenConns(1) // SQLite single writer
 
-	if err := queue.EnsureSchema(db); err != nil {
-		log.Fatal().Err(err).Msg("ensure schema")
+	repo := queue.NewSQLiteRepoWithDefaults(db, taskDefaults(cfg))
+	store, err := secretStore(cfg.Secrets, repo)
+	if err != nil {
+		log.Fatal().Err(err).Msg("secrets store")
+	}
+	if store == nil {
+		log.Info().Msg("no master key configured - encrypted secrets store disabled")
+	}
+	handlers, err := newHandlers(cfg, store)
+	if err != nil {
+		log.Fatal().Err(err).Msg("build handlers")
+	}
+	if !cfg.Shell.Sandbox {
+		log.Warn().Msg("shell sandbox disabled - shell tasks run with the server's privileges")
 	}
-
-	repo := queue.NewSQLiteRepo(db)
 	if n, err := repo.RecoverStale(context.Background(), time.Now()); err == nil {
 		log.Info().Int("recovered", n).Msg("recovered stale running tasks")
 	}
-
-	// Handlers registry
-	handlers := map[string]worker.Handler{
-		"shell": shell.Shell{},
-		"http":  httphandler.HTTP{},
+	if cfg.ScheduleDir != "" {
+		if err := reloadSchedules(context.Background(), repo, cfg.ScheduleDir); err != nil {
+			log.Fatal().Err(err).Msg("load schedule files")
+		}
 	}
 
 	// Start worker pool
 	ctx, cancel := context.WithCancel(context.Background())
-	pool := worker.NewPool(repo, handlers, *workers, *poll)
+	pool := worker.NewPool(repo, handlers, cfg.Workers, cfg.Poll).WithHandlerOptions(handlerOptions(cfg))
 	go pool.Run(ctx)
 
 	// Start scheduler service
-	schedulerSvc := scheduler.NewService(repo, *schedInt)
-	go schedulerSvc.Start(ctx)
+	// Processes sharing the database elect one of them to run schedules.
+	schedulerSvc := scheduler.NewService(repo, cfg.ScheduleInterval).WithMaxFiresPerTick(cfg.ScheduleMaxFires)
+	schedulerDone := make(chan struct{})
+	go func() {
+		schedulerSvc.Start(ctx)
+		close(schedulerDone)
+	}()
+
+	if cfg.Backup.Interval > 0 {
+		backupSvc := backup.NewService(repo, cfg.Backup.Dir, cfg.Backup.Interval).WithKeep(cfg.Backup.Keep)
+		go backupSvc.Start(ctx)
+	}
+
+	if cfg.Retention.Enabled() {
+		pruner := retention.NewService(repo, retentionRules(cfg.Retention), cfg.Retention.Interval).
+			WithBatchSize(cfg.Retention.BatchSize).
+			WithArchive(cfg.Retention.ArchiveDir).
+			WithMisfires(cfg.Retention.Misfires).
+			WithVacuum(cfg.Retention.VacuumInterval)
+		go pruner.Start(ctx)
+	}
 
 	// HTTP server with optional debug endpoints
-	server := api.NewServerWithDebug(repo, *debug)
-	if *debug {
+	server := api.NewServerWithOptions(repo, api.Options{
+		Debug:         cfg.Debug,
+		Auth:          cfg.Auth.Enabled,
+		SessionTTL:    cfg.Auth.SessionTTL,
+		Secrets:       store,
+		BackupDir:     cfg.Backup.Dir,
+		BackupAnyPath: cfg.Backup.AllowAnyPath,
+	})
+	if cfg.Debug {
 		log.Info().Msg("debug mode enabled - pprof available at /debug/pprof/")
 	}
+	if !cfg.Auth.Enabled {
+		log.Warn().Msg("authentication disabled - anyone who can reach the server can run shell tasks")
+	}
 
-	srv := &http.Server{Addr: *addr, Handler: server}
+	srv := &http.Server{Addr: cfg.Addr, Handler: server}
 	go func() {
-		log.Info().Str("addr", *addr).Bool("debug", *debug).Msg("HTTP server starting")
+		log.Info().Str("addr", cfg.Addr).Bool("debug", cfg.Debug).Msg("HTTP server starting")
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("http server")
 		}
 	}()
 
+	if cfg.ScheduleDir != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := reloadSchedules(ctx, repo, cfg.ScheduleDir); err != nil {
+					log.Error().Err(err).Msg("reload schedule files failed, schedules left unchanged")
+				}
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
@@ -93,4 +245,38 @@ func main() {
 	ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancelTimeout()
 	_ = srv.Shutdown(ctxTimeout)
+	<-schedulerDone // releases the scheduler lease for a standby
+}
+
+// reloadSchedules reconciles the schedules table with the definition files
+// in dir.
+func reloadSchedules(ctx context.Context, repo queue.Repository, dir string) error {
+	defs, err := scheduler.LoadDefinitions(dir)
+	if err != nil {
+		return err
+	}
+	res, err := scheduler.Reconcile(ctx, repo, defs, time.Now())
+	if err != nil {
+		return err
+	}
+	log.Info().Str("dir", dir).Int("definitions", len(defs)).
+		Int("created", res.Created).Int("updated", res.Updated).Int("disabled", res.Disabled).
+		Msg("schedule files loaded")
+	return nil
+}
+
+// openDB opens the SQLite database and ensures the schema exists.
+func openDB(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?cache=shared&mode=rwc&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1) // SQLite single writer
+
+	if err := queue.EnsureSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ensure schema: %w", err)
+	}
+	return db, nil
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"localflow/internal/secrets"
+)
+
+// runSecret manages secrets through the admin API. Values are sent to the
+// server but never printed or read back.
+func runSecret(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: localflow secret set|list|delete|gen-key")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cmd := newCLICommand("secret " + args[0])
+	switch args[0] {
+	case "set":
+		var (
+			value = cmd.fs.String("value", "", "secret value (prefer -file or stdin to keep it out of shell history)")
+			file  = cmd.fs.String("file", "", "read the value from a file")
+		)
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		name, err := cmd.arg("secret name")
+		if err != nil {
+			return err
+		}
+		v := *value
+		switch {
+		case *file != "":
+			b, err := os.ReadFile(*file)
+			if err != nil {
+				return err
+			}
+			v = strings.TrimSuffix(string(b), "\n")
+		case v == "":
+			b, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			v = strings.TrimSuffix(string(b), "\n")
+		}
+		if err := cmd.client().PutSecret(ctx, name, v); err != nil {
+			return err
+		}
+		return printID(cmd, name)
+
+	case "list":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		list, err := cmd.client().ListSecrets(ctx)
+		if err != nil {
+			return err
+		}
+		if cmd.json() {
+			return writeJSONOut(os.Stdout, list)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCREATED\tUPDATED")
+		for _, s := range list {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, s.CreatedAt, s.UpdatedAt)
+		}
+		return tw.Flush()
+
+	case "delete":
+		if err := cmd.parse(args[1:]); err != nil {
+			return err
+		}
+		name, err := cmd.arg("secret name")
+		if err != nil {
+			return err
+		}
+		if err := cmd.client().DeleteSecret(ctx, name); err != nil {
+			return err
+		}
+		return printID(cmd, name)
+
+	case "gen-key":
+		key, err := secrets.NewMasterKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	}
+	return fmt.Errorf("unknown secret command %q", args[0])
+}
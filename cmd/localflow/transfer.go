@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"localflow/internal/transfer"
+)
+
+// runExport writes schedules, calendars and optionally tasks from a running
+// server to a file or stdout.
+func runExport(args []string) error {
+	cmd := newCLICommand("export")
+	var (
+		tasks  = cmd.fs.String("tasks", "", "comma-separated task states to include, e.g. queued,failed (default none)")
+		format = cmd.fs.String("format", "ndjson", "ndjson, or json for a single document")
+		out    = cmd.fs.String("out", "", "write to this file instead of stdout")
+	)
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if *format != "ndjson" && *format != "json" {
+		return fmt.Errorf("invalid format %q", *format)
+	}
+	var states []string
+	for _, s := range strings.Split(*tasks, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			states = append(states, s)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := cmd.client().Export(context.Background(), states, *format == "json", w); err != nil {
+		if *out != "" {
+			os.Remove(*out)
+		}
+		return err
+	}
+	return nil
+}
+
+// runImport loads an export file, or stdin for "-", into a running server.
+func runImport(args []string) error {
+	cmd := newCLICommand("import")
+	conflict := cmd.fs.String("conflict", transfer.ConflictSkip, "existing calendar, schedule or task: skip, overwrite or rename")
+	if err := cmd.parse(args); err != nil {
+		return err
+	}
+	if err := transfer.ValidateConflict(*conflict); err != nil {
+		return err
+	}
+	path, err := cmd.arg("file")
+	if err != nil {
+		return err
+	}
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	res, err := cmd.client().Import(context.Background(), r, *conflict)
+	if err != nil {
+		return err
+	}
+	if cmd.json() {
+		return writeJSONOut(os.Stdout, res)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tCREATED\tOVERWRITTEN\tRENAMED\tSKIPPED")
+	for _, row := range []struct {
+		kind string
+		c    transfer.Counts
+	}{{"calendars", res.Calendars}, {"schedules", res.Schedules}, {"tasks", res.Tasks}} {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", row.kind, row.c.Created, row.c.Overwritten, row.c.Renamed, row.c.Skipped)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, w := range res.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"localflow/internal/auth"
+	"localflow/internal/domain"
+)
+
+const sessionCookie = "localflow_session"
+
+// require returns middleware that authenticates the request by bearer API key
+// or dashboard session and checks it carries scope. It is a no-op when auth
+// is disabled.
+func (s *Server) require(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !s.opts.Auth {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, ok := s.authenticate(r)
+			if !ok {
+				s.unauthorized(w, r)
+				return
+			}
+			if !auth.HasScope(key.Scopes, scope) {
+				http.Error(w, "forbidden: key lacks scope "+scope, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithKey(r.Context(), key)))
+		})
+	}
+}
+
+func (s *Server) authenticate(r *http.Request) (domain.APIKey, bool) {
+	if h := r.Header.Get("Authorization"); h != "" {
+		token, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return domain.APIKey{}, false
+		}
+		key, err := s.repo.GetAPIKeyByHash(r.Context(), auth.HashToken(strings.TrimSpace(token)))
+		if err != nil || key.RevokedAt != nil {
+			return domain.APIKey{}, false
+		}
+		return key, true
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		key, err := s.repo.GetSessionKey(r.Context(), auth.HashToken(c.Value), time.Now())
+		if err == nil {
+			return key, true
+		}
+	}
+	return domain.APIKey{}, false
+}
+
+func (s *Server) unauthorized(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/"):
+		w.Header().Set("WWW-Authenticate", `Bearer realm="localflow"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	case r.Header.Get("HX-Request") != "":
+		// HTMX fragment requests can't follow a redirect into a full page.
+		w.Header().Set("HX-Redirect", "/login")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	default:
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}
+}
+
+func (s *Server) loginPage(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.Auth {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	s.renderLogin(w, http.StatusOK, "")
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if !s.opts.Auth {
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	key, err := s.repo.GetAPIKeyByHash(r.Context(), auth.HashToken(strings.TrimSpace(r.FormValue("api_key"))))
+	if err != nil || key.RevokedAt != nil || !auth.HasScope(key.Scopes, auth.ScopeRead) {
+		s.renderLogin(w, http.StatusUnauthorized, "Invalid or revoked API key")
+		return
+	}
+
+	token, hash, err := auth.NewToken("")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	expires := time.Now().Add(s.opts.SessionTTL)
+	if err := s.repo.CreateSession(r.Context(), hash, key.ID, expires); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		_ = s.repo.DeleteSession(r.Context(), auth.HashToken(c.Value))
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, code int, errMsg string) {
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	if err := s.templates.ExecuteTemplate(w, "login.html", map[string]any{"Error": errMsg}); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
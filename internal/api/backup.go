@@ -0,0 +1,119 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"localflow/internal/backup"
+)
+
+type backupReq struct {
+	// Path is where the server writes the snapshot. It must lie inside the
+	// backup directory, relative paths being taken from it, unless the
+	// server allows any path; empty picks a timestamped name in it.
+	Path string `json:"path"`
+}
+
+func backupView(info backup.Info) map[string]any {
+	return map[string]any{
+		"path": info.Path,
+		"size": info.Size,
+		"took": info.Took.Round(time.Millisecond).String(),
+	}
+}
+
+// backupPath resolves the snapshot path of a backup request against the
+// backup directory dir. Paths outside dir are rejected unless anyPath
+// allows absolute ones anywhere.
+func backupPath(dir, path string, anyPath bool) (string, error) {
+	switch {
+	case path != "" && filepath.IsAbs(path) && anyPath:
+		return filepath.Clean(path), nil
+	case dir == "" && anyPath:
+		return "", errors.New("path must be absolute when backup.dir is not set")
+	case dir == "":
+		return "", errors.New("backup.dir is not set")
+	case path == "":
+		return filepath.Join(dir, backup.Name(time.Now())), nil
+	}
+	resolved := filepath.Clean(path)
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(dir, resolved)
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("path must name a file inside backup.dir")
+	}
+	return resolved, nil
+}
+
+// createBackup writes a snapshot of the database to a path on the server.
+// The copy reads the whole database on a connection of its own, so other
+// requests aren't held up, but it costs a full read of the file and as
+// much disk again, and the response waits until it is done.
+
+func (s *Server) createBackup(w http.ResponseWriter, r *http.Request) {
+	var req backupReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	path, err := backupPath(s.opts.BackupDir, req.Path, s.opts.BackupAnyPath)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	info, err := backup.Snapshot(r.Context(), s.repo, path)
+	if errors.Is(err, backup.ErrExists) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	log.Info().Str("path", info.Path).Int64("size", info.Size).Dur("took", info.Took).Msg("snapshot written")
+	writeJSON(w, http.StatusCreated, backupView(info))
+}
+
+// downloadBackup snapshots the database to a temporary file and sends it.
+// It costs what createBackup does, plus the temporary file's disk space
+// until the response is sent.
+func (s *Server) downloadBackup(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "localflow-backup-")
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	name := backup.Name(time.Now())
+	info, err := backup.Snapshot(r.Context(), s.repo, filepath.Join(dir, name))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	f, err := os.Open(info.Path)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("content-type", "application/vnd.sqlite3")
+	w.Header().Set("content-length", strconv.FormatInt(info.Size, 10))
+	w.Header().Set("content-disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Error().Err(err).Msg("sending snapshot failed")
+	}
+}
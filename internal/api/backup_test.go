@@ -0,0 +1,58 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBackupPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	tests := []struct {
+		dir, path string
+		anyPath   bool
+		want      string // "" for an error
+	}{
+		{dir, "nightly.db", false, filepath.Join(dir, "nightly.db")},
+		{dir, "sub/nightly.db", false, filepath.Join(dir, "sub", "nightly.db")},
+		{dir, "sub/../nightly.db", false, filepath.Join(dir, "nightly.db")},
+		{dir, filepath.Join(dir, "nightly.db"), false, filepath.Join(dir, "nightly.db")},
+		{dir, "..lf.db", false, filepath.Join(dir, "..lf.db")},
+		{dir, "/mnt/nas/lf.db", false, ""},
+		{dir, filepath.Join(dir, "..", "lf.db"), false, ""},
+		{dir, dir, false, ""},
+		{dir, "../lf.db", false, ""},
+		{dir, "sub/../../lf.db", false, ""},
+		{dir, "..", false, ""},
+		{dir, ".", false, ""},
+		{dir, "sub/..", false, ""},
+		{"", "lf.db", false, ""},
+		{"", "", false, ""},
+		{"", "/tmp/lf.db", false, ""},
+
+		// allow_any_path lets absolute paths out, but relative ones stay in.
+		{dir, "/mnt/nas/lf.db", true, "/mnt/nas/lf.db"},
+		{dir, "/mnt/nas/../lf.db", true, "/mnt/lf.db"},
+		{dir, "../lf.db", true, ""},
+		{"", "/tmp/lf.db", true, "/tmp/lf.db"},
+		{"", "lf.db", true, ""},
+		{"", "", true, ""},
+	}
+	for _, tt := range tests {
+		got, err := backupPath(tt.dir, tt.path, tt.anyPath)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("backupPath(%q, %q, %v) = %q, want an error", tt.dir, tt.path, tt.anyPath, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("backupPath(%q, %q, %v) = %q, %v; want %q", tt.dir, tt.path, tt.anyPath, got, err, tt.want)
+		}
+	}
+
+	got, err := backupPath(dir, "", false)
+	if err != nil || filepath.Dir(got) != dir || !strings.HasSuffix(got, ".db") {
+		t.Errorf("backupPath(%q, \"\") = %q, %v", dir, got, err)
+	}
+}
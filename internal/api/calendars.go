@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+	"localflow/internal/domain"
+	"localflow/internal/scheduler"
+)
+
+type putCalendarReq struct {
+	Description string   `json:"description"`
+	Dates       []string `json:"dates"`
+}
+
+func calendarView(c domain.Calendar) map[string]any {
+	v := map[string]any{
+		"name":        c.Name,
+		"description": c.Description,
+		"created_at":  c.CreatedAt.Format(time.RFC3339),
+		"updated_at":  c.UpdatedAt.Format(time.RFC3339),
+	}
+	if c.Dates != nil {
+		v["dates"] = c.Dates
+	}
+	return v
+}
+
+func (s *Server) listCalendars(w http.ResponseWriter, r *http.Request) {
+	list, err := s.repo.ListCalendars(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, c := range list {
+		out = append(out, calendarView(c))
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request) {
+	c, err := s.repo.GetCalendar(r.Context(), chi.URLParam(r, "name"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	if c.Dates == nil {
+		c.Dates = []string{}
+	}
+	writeJSON(w, 200, calendarView(c))
+}
+
+// putCalendar creates a calendar or replaces its dates, then moves the next
+// run of schedules excluding it off any date it now covers.
+func (s *Server) putCalendar(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !scheduler.ValidCalendarName(name) {
+		http.Error(w, "invalid calendar name", 400)
+		return
+	}
+	var req putCalendarReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	dates, err := scheduler.NormalizeDates(req.Dates)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := s.repo.PutCalendar(r.Context(), domain.Calendar{Name: name, Description: req.Description, Dates: dates}); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	schedules, err := s.repo.ListSchedules(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	now := time.Now()
+	for _, sch := range schedules {
+		if !sch.Enabled || !scheduler.UsesCalendar(sch, name) {
+			continue
+		}
+		next, err := scheduler.NextRun(r.Context(), s.repo, sch, now)
+		if errors.Is(err, scheduler.ErrNoFutureRun) {
+			// Left for the scheduler, which disables finished schedules.
+			continue
+		}
+		if err == nil {
+			sch.NextRun = next
+			err = s.repo.UpdateSchedule(r.Context(), sch)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("schedule_id", sch.ID).Msg("failed to reschedule after calendar change")
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteCalendar removes a calendar no schedule excludes.
+func (s *Server) deleteCalendar(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	schedules, err := s.repo.ListSchedules(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	for _, sch := range schedules {
+		if scheduler.UsesCalendar(sch, name) {
+			http.Error(w, "calendar is used by schedule "+sch.Name, http.StatusConflict)
+			return
+		}
+	}
+	if err := s.repo.DeleteCalendar(r.Context(), name); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,214 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"localflow/internal/domain"
+)
+
+const (
+	logPageSize   = 500
+	logFollowPoll = 500 * time.Millisecond
+)
+
+type logLine struct {
+	ID      int64  `json:"id"`
+	Attempt int    `json:"attempt"`
+	Stream  string `json:"stream"`
+	Line    string `json:"line"`
+	Time    string `json:"time"`
+}
+
+func logView(l domain.TaskLog) logLine {
+	return logLine{ID: l.ID, Attempt: l.Attempt, Stream: l.Stream, Line: l.Line, Time: l.CreatedAt.Format(time.RFC3339Nano)}
+}
+
+func isTerminal(state string) bool {
+	switch state {
+	case "succeeded", "failed", "canceled", "expired":
+		return true
+	}
+	return false
+}
+
+// getTaskLogs returns a task's log lines after the optional `after` cursor.
+// With follow=true it streams NDJSON until the task reaches a terminal state
+// or the client disconnects.
+func (s *Server) getTaskLogs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.repo.Get(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	after, _ := strconv.ParseInt(r.URL.Query().Get("after"), 10, 64)
+	attempt, _ := strconv.Atoi(r.URL.Query().Get("attempt"))
+
+	if r.URL.Query().Get("follow") != "true" {
+		var out []logLine
+		for {
+			lines, err := s.repo.ListTaskLogs(r.Context(), id, after, logPageSize)
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			for _, l := range lines {
+				if attempt == 0 || l.Attempt == attempt {
+					out = append(out, logView(l))
+				}
+				after = l.ID
+			}
+			if len(lines) < logPageSize {
+				break
+			}
+		}
+		if out == nil {
+			out = []logLine{}
+		}
+		writeJSON(w, 200, out)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for {
+		// Read the state before the lines: once a task is terminal its
+		// logger has flushed, so an empty page after that means we're done.
+		t, err := s.repo.Get(r.Context(), id)
+		if err != nil {
+			return
+		}
+		lines, err := s.repo.ListTaskLogs(r.Context(), id, after, logPageSize)
+		if err != nil {
+			return
+		}
+		for _, l := range lines {
+			if attempt == 0 || l.Attempt == attempt {
+				if err := enc.Encode(logView(l)); err != nil {
+					return
+				}
+			}
+			after = l.ID
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(lines) == logPageSize {
+			continue
+		}
+		if isTerminal(t.State) {
+			return
+		}
+		if !sleepCtx(r.Context(), logFollowPoll) {
+			return
+		}
+	}
+}
+
+// taskEvent is a change in a task's state or reported progress.
+type taskEvent struct {
+	State         string   `json:"state"`
+	Attempts      int      `json:"attempts"`
+	Progress      *float64 `json:"progress"`
+	StatusMessage string   `json:"status_message"`
+	Time          string   `json:"time"` // when the change was seen
+}
+
+func (e taskEvent) same(o taskEvent) bool {
+	return e.State == o.State && e.Attempts == o.Attempts && e.StatusMessage == o.StatusMessage &&
+		(e.Progress == nil) == (o.Progress == nil) && (e.Progress == nil || *e.Progress == *o.Progress)
+}
+
+// getTaskEvents streams NDJSON events with the task's state, attempts and
+// handler-reported progress: the current values first, then each change
+// seen while polling, until the task reaches a terminal state or the
+// client disconnects. Progress is written at most once a second, so faster
+// updates are coalesced.
+func (s *Server) getTaskEvents(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.repo.Get(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	var last *taskEvent
+	for {
+		t, err := s.repo.Get(r.Context(), id)
+		if err != nil {
+			return
+		}
+		ev := taskEvent{State: t.State, Attempts: t.Attempts, Progress: t.Progress, StatusMessage: t.StatusMessage}
+		if last == nil || !ev.same(*last) {
+			ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			last = &ev
+		}
+		if isTerminal(t.State) {
+			return
+		}
+		if !sleepCtx(r.Context(), logFollowPoll) {
+			return
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// dashboardTaskLogs renders the log pane for a task. While the task is still
+// active the fragment polls itself.
+func (s *Server) dashboardTaskLogs(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	t, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	var lines []domain.TaskLog
+	var after int64
+	for {
+		page, err := s.repo.ListTaskLogs(r.Context(), id, after, logPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		lines = append(lines, page...)
+		if len(page) < logPageSize {
+			break
+		}
+		after = page[len(page)-1].ID
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := s.templates.ExecuteTemplate(w, "logs.html", map[string]any{
+		"Task":   t,
+		"Lines":  lines,
+		"Active": !isTerminal(t.State),
+	}); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
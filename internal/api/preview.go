@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"localflow/internal/domain"
+	"localflow/internal/scheduler"
+)
+
+const (
+	defaultPreviewFires = 10
+	maxPreviewFires     = 100
+)
+
+type fireView struct {
+	Time  string `json:"time"`  // UTC
+	Local string `json:"local"` // in the schedule's zone
+	In    string `json:"in"`    // e.g. "in 3h20m"
+
+	At time.Time `json:"-"` // in the schedule's zone, for the dashboard
+}
+
+type upcomingView struct {
+	Description string     `json:"description"`
+	Timezone    string     `json:"timezone"`
+	Next        []fireView `json:"next"`
+}
+
+// upcoming lists the schedule's next n fires after now, honouring its time
+// zone, window and exclusion calendars.
+func (s *Server) upcoming(ctx context.Context, schedule domain.Schedule, now time.Time, n int) (upcomingView, error) {
+	spec, err := scheduler.ScheduleSpec(ctx, s.repo, schedule)
+	if err != nil {
+		return upcomingView{}, err
+	}
+	v := upcomingView{
+		Description: scheduler.Describe(schedule.CronExpr),
+		Timezone:    spec.Location().String(),
+		Next:        []fireView{},
+	}
+	for _, t := range scheduler.Upcoming(spec, now, n) {
+		v.Next = append(v.Next, fireView{
+			Time:  t.UTC().Format(time.RFC3339),
+			Local: t.In(spec.Location()).Format(time.RFC3339),
+			In:    untilText(t.Sub(now)),
+			At:    t.In(spec.Location()),
+		})
+	}
+	return v, nil
+}
+
+// untilText formats the time until a fire, coarser the further out it is.
+func untilText(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("in %ds", int(d.Round(time.Second)/time.Second))
+	case d < time.Hour:
+		return "in " + d.Round(time.Second).String()
+	case d < 48*time.Hour:
+		return "in " + strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	default:
+		return fmt.Sprintf("in %dd %dh", int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour))
+	}
+}
+
+// previewCount reads the n query parameter.
+func previewCount(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("n")
+	if v == "" {
+		return defaultPreviewFires, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 || n > maxPreviewFires {
+		return 0, fmt.Errorf("n must be between 1 and %d", maxPreviewFires)
+	}
+	return n, nil
+}
+
+// splitNames splits a comma-separated list, dropping empty entries.
+func splitNames(s string) []string {
+	var out []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// previewSchedule shows when an expression would fire without creating a
+// schedule, so typos show up before they're saved.
+func (s *Server) previewSchedule(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("cron_expr") == "" {
+		http.Error(w, "cron_expr is required", 400)
+		return
+	}
+	n, err := previewCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	schedule := domain.Schedule{
+		CronExpr:         q.Get("cron_expr"),
+		Timezone:         q.Get("tz"),
+		ExcludeCalendars: splitNames(q.Get("exclude")),
+	}
+	v, err := s.upcoming(r.Context(), schedule, time.Now(), n)
+	if err != nil {
+		http.Error(w, "invalid cron expression: "+err.Error(), 400)
+		return
+	}
+	writeJSON(w, 200, v)
+}
+
+// scheduleUpcoming lists a saved schedule's next fires.
+func (s *Server) scheduleUpcoming(w http.ResponseWriter, r *http.Request) {
+	schedule, err := s.repo.GetSchedule(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	n, err := previewCount(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	v, err := s.upcoming(r.Context(), schedule, time.Now(), n)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, 200, v)
+}
+
+// dashboardSchedulePreview renders the create form's live preview.
+func (s *Server) dashboardSchedulePreview(w http.ResponseWriter, r *http.Request) {
+	data := map[string]any{}
+	if expr := r.FormValue("cron_expr"); expr != "" {
+		schedule := domain.Schedule{
+			CronExpr:         expr,
+			Timezone:         r.FormValue("timezone"),
+			ExcludeCalendars: splitNames(r.FormValue("exclude_calendars")),
+		}
+		v, err := s.upcoming(r.Context(), schedule, time.Now(), 5)
+		if err != nil {
+			data["Error"] = err.Error()
+		} else {
+			data["Preview"] = v
+		}
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if err := s.templates.ExecuteTemplate(w, "preview.html", data); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
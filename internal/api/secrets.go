@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"localflow/internal/secrets"
+)
+
+type putSecretReq struct {
+	Value string `json:"value"`
+}
+
+// secretStore returns the store, answering 503 when it isn't configured.
+func (s *Server) secretStore(w http.ResponseWriter) *secrets.Store {
+	if s.opts.Secrets == nil {
+		http.Error(w, "secrets store is not configured; set "+secrets.MasterKeyEnv+" or secrets.master_key_file", http.StatusServiceUnavailable)
+	}
+	return s.opts.Secrets
+}
+
+// putSecret creates or replaces a secret. The value is write-only: no
+// endpoint ever returns it.
+func (s *Server) putSecret(w http.ResponseWriter, r *http.Request) {
+	store := s.secretStore(w)
+	if store == nil {
+		return
+	}
+	name := chi.URLParam(r, "name")
+	if !secrets.ValidName(name) {
+		http.Error(w, "invalid secret name", 400)
+		return
+	}
+	var req putSecretReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := store.Put(r.Context(), name, req.Value); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSecrets(w http.ResponseWriter, r *http.Request) {
+	store := s.secretStore(w)
+	if store == nil {
+		return
+	}
+	list, err := store.List(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]map[string]any, 0, len(list))
+	for _, sec := range list {
+		out = append(out, map[string]any{
+			"name":       sec.Name,
+			"created_at": sec.CreatedAt.Format(time.RFC3339),
+			"updated_at": sec.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) deleteSecret(w http.ResponseWriter, r *http.Request) {
+	store := s.secretStore(w)
+	if store == nil {
+		return
+	}
+	if err := store.Delete(r.Context(), chi.URLParam(r, "name")); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,8 +1,14 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
+	"io"
 	"net/http"
 	"net/http/pprof"
 	"strconv"
@@ -10,15 +16,35 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"localflow/internal/auth"
 	"localflow/internal/domain"
 	"localflow/internal/queue"
 	"localflow/internal/scheduler"
+	"localflow/internal/secrets"
 )
 
 type Server struct {
 	r         *chi.Mux
 	repo      queue.Repository
 	templates *template.Template
+	opts      Options
+}
+
+// Options configure optional server features.
+type Options struct {
+	// Debug exposes pprof endpoints under /debug/pprof/.
+	Debug bool
+	// Auth requires an API key on /api/* and a login session on the
+	// dashboard. SessionTTL controls how long dashboard logins last.
+	Auth       bool
+	SessionTTL time.Duration
+	// Secrets enables the /api/secrets endpoints.
+	Secrets *secrets.Store
+	// BackupDir is where POST /api/backup writes snapshots; paths outside
+	// it are rejected unless BackupAnyPath is set, which allows absolute
+	// paths anywhere the server can write.
+	BackupDir     string
+	BackupAnyPath bool
 }
 
 func NewServer(repo queue.Repository) http.Handler {
@@ -26,45 +52,82 @@ func NewServer(repo queue.Repository) http.Handler {
 }
 
 func NewServerWithDebug(repo queue.Repository, enableDebug bool) http.Handler {
+	return NewServerWithOptions(repo, Options{Debug: enableDebug})
+}
+
+func NewServerWithOptions(repo queue.Repository, opts Options) http.Handler {
+	if opts.SessionTTL <= 0 {
+		opts.SessionTTL = 12 * time.Hour
+	}
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID, middleware.RealIP, middleware.Logger, middleware.Recoverer)
 
 	// Load templates
-	templates := template.Must(template.ParseGlob("templates/*.html"))
+	templates := template.Must(template.New("").Funcs(templateFuncs).ParseGlob("templates/*.html"))
 
-	s := &Server{r: r, repo: repo, templates: templates}
+	s := &Server{r: r, repo: repo, templates: templates, opts: opts}
+	read := s.require(auth.ScopeRead)
+	submit := s.require(auth.ScopeSubmit)
+	admin := s.require(auth.ScopeAdmin)
 
 	// API routes
 	r.Get("/health", s.health)
 	r.Get("/metrics", s.metrics)
-	r.Post("/api/tasks", s.submitTask)
-	r.Get("/api/tasks/{id}", s.getTask)
-	r.Post("/api/schedules", s.createSchedule)
-	r.Get("/api/schedules", s.listSchedules)
-	r.Get("/api/schedules/{id}", s.getSchedule)
-	r.Put("/api/schedules/{id}", s.updateSchedule)
-	r.Delete("/api/schedules/{id}", s.deleteSchedule)
+	r.With(submit).Post("/api/tasks", s.submitTask)
+	r.With(read).Get("/api/tasks", s.listTasks)
+	r.With(read).Get("/api/tasks/{id}", s.getTask)
+	r.With(read).Get("/api/tasks/{id}/logs", s.getTaskLogs)
+	r.With(read).Get("/api/tasks/{id}/events", s.getTaskEvents)
+	r.With(submit).Post("/api/tasks/{id}/cancel", s.cancelTask)
+	r.With(submit).Post("/api/tasks/{id}/retry", s.retryTask)
+	r.With(admin).Post("/api/schedules", s.createSchedule)
+	r.With(read).Get("/api/schedules", s.listSchedules)
+	r.With(read).Get("/api/schedules/preview", s.previewSchedule)
+	r.With(read).Get("/api/schedules/{id}", s.getSchedule)
+	r.With(admin).Put("/api/schedules/{id}", s.updateSchedule)
+	r.With(admin).Delete("/api/schedules/{id}", s.deleteSchedule)
+	r.With(admin).Post("/api/schedules/{id}/trigger", s.triggerSchedule)
+	r.With(read).Get("/api/schedules/{id}/runs", s.listScheduleRuns)
+	r.With(read).Get("/api/schedules/{id}/misfires", s.listScheduleMisfires)
+	r.With(read).Get("/api/schedules/{id}/upcoming", s.scheduleUpcoming)
+	r.With(read).Get("/api/stats", s.stats)
+	r.With(read).Get("/api/calendars", s.listCalendars)
+	r.With(read).Get("/api/calendars/{name}", s.getCalendar)
+	r.With(admin).Put("/api/calendars/{name}", s.putCalendar)
+	r.With(admin).Delete("/api/calendars/{name}", s.deleteCalendar)
+	r.With(admin).Post("/api/backup", s.createBackup)
+	r.With(admin).Get("/api/backup", s.downloadBackup)
+	r.With(admin).Get("/api/export", s.export)
+	r.With(admin).Post("/api/import", s.importData)
+	r.With(admin).Get("/api/secrets", s.listSecrets)
+	r.With(admin).Put("/api/secrets/{name}", s.putSecret)
+	r.With(admin).Delete("/api/secrets/{name}", s.deleteSecret)
 
 	// Dashboard routes
-	r.Get("/", s.dashboard)
-	r.Get("/dashboard", s.dashboard)
-	r.Get("/dashboard/tasks", s.dashboardTasks)
-	r.Get("/dashboard/schedules", s.dashboardSchedules)
-	r.Post("/dashboard/tasks", s.dashboardSubmitTask)
-	r.Post("/dashboard/schedules", s.dashboardCreateSchedule)
-	r.Delete("/dashboard/schedules/{id}", s.dashboardDeleteSchedule)
+	r.Get("/login", s.loginPage)
+	r.Post("/login", s.login)
+	r.Post("/logout", s.logout)
+	r.With(read).Get("/", s.dashboard)
+	r.With(read).Get("/dashboard", s.dashboard)
+	r.With(read).Get("/dashboard/tasks", s.dashboardTasks)
+	r.With(read).Get("/dashboard/tasks/{id}/logs", s.dashboardTaskLogs)
+	r.With(read).Get("/dashboard/schedules", s.dashboardSchedules)
+	r.With(read).Get("/dashboard/schedules/preview", s.dashboardSchedulePreview)
+	r.With(submit).Post("/dashboard/tasks", s.dashboardSubmitTask)
+	r.With(admin).Post("/dashboard/schedules", s.dashboardCreateSchedule)
+	r.With(admin).Delete("/dashboard/schedules/{id}", s.dashboardDeleteSchedule)
 
 	// Debug routes (pprof)
-	if enableDebug {
-		r.HandleFunc("/debug/pprof/", pprof.Index)
-		r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-		r.HandleFunc("/debug/pprof/profile", pprof.Profile)
-		r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-		r.HandleFunc("/debug/pprof/trace", pprof.Trace)
-		r.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
-		r.Handle("/debug/pprof/heap", pprof.Handler("heap"))
-		r.Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
-		r.Handle("/debug/pprof/block", pprof.Handler("block"))
+	if opts.Debug {
+		r.With(admin).HandleFunc("/debug/pprof/", pprof.Index)
+		r.With(admin).HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		r.With(admin).HandleFunc("/debug/pprof/profile", pprof.Profile)
+		r.With(admin).HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		r.With(admin).HandleFunc("/debug/pprof/trace", pprof.Trace)
+		r.With(admin).Handle("/debug/pprof/goroutine", pprof.Handler("goroutine"))
+		r.With(admin).Handle("/debug/pprof/heap", pprof.Handler("heap"))
+		r.With(admin).Handle("/debug/pprof/threadcreate", pprof.Handler("threadcreate"))
+		r.With(admin).Handle("/debug/pprof/block", pprof.Handler("block"))
 	}
 
 	return r
@@ -75,10 +138,22 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("ok"))
 }
 
+// taskStates are reported in metrics even when no task is in them.
+var taskStates = []string{"queued", "running", "succeeded", "failed", "canceled", "expired"}
+
 func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	counts, err := s.repo.CountTasksByState(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
 	w.Header().Set("content-type", "text/plain; version=0.0.4")
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("localflow_up 1\n"))
+	fmt.Fprintln(w, "localflow_up 1")
+	fmt.Fprintln(w, "# TYPE localflow_tasks gauge")
+	for _, state := range taskStates {
+		fmt.Fprintf(w, "localflow_tasks{state=%q} %d\n", state, counts[state])
+	}
 }
 
 type submitReq struct {
@@ -87,10 +162,26 @@ type submitReq struct {
 	Priority       int             `json:"priority"`
 	MaxAttempts    int             `json:"max_attempts"`
 	IdempotencyKey *string         `json:"idempotency_key"`
+	// A task still queued at ExpiresAt, or TTL seconds after submission,
+	// expires instead of running. At most one of them may be set.
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       *int       `json:"ttl"`
+	Unique    *uniqueReq `json:"unique"`
+}
+
+// uniqueReq allows one task per type and key; see domain.Unique.
+type uniqueReq struct {
+	Key        string `json:"key"`
+	Mode       string `json:"mode"`   // default active
+	Window     int    `json:"window"` // seconds, for mode window
+	OnConflict string `json:"on_conflict"`
 }
 
 type submitResp struct {
 	ID string `json:"id"`
+	// Existing is set when a unique submission returned, or replaced the
+	// payload of, a task that was already there.
+	Existing bool `json:"existing,omitempty"`
 }
 
 func (s *Server) submitTask(w http.ResponseWriter, r *http.Request) {
@@ -103,16 +194,60 @@ func (s *Server) submitTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "type is required", 400)
 		return
 	}
-	id, err := s.repo.Enqueue(r.Context(), domain.Task{
+	expiresAt := req.ExpiresAt
+	switch {
+	case expiresAt != nil && req.TTL != nil:
+		http.Error(w, "expires_at and ttl are mutually exclusive", 400)
+		return
+	case req.TTL != nil:
+		if *req.TTL <= 0 {
+			http.Error(w, "ttl must be positive", 400)
+			return
+		}
+		t := time.Now().Add(time.Duration(*req.TTL) * time.Second)
+		expiresAt = &t
+	case expiresAt != nil && !expiresAt.After(time.Now()):
+		http.Error(w, "expires_at is in the past", 400)
+		return
+	}
+	task := domain.Task{
 		Type: req.Type, Payload: req.Payload, Priority: req.Priority,
 		MaxAttempts: req.MaxAttempts, IdempotencyKey: req.IdempotencyKey,
-		VisibilityTimeout: 60,
-	})
+		ExpiresAt: expiresAt,
+	}
+	if req.Unique == nil {
+		id, err := s.repo.Enqueue(r.Context(), task)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, submitResp{ID: id})
+		return
+	}
+
+	u := domain.Unique{
+		Key:        req.Unique.Key,
+		Mode:       req.Unique.Mode,
+		Window:     time.Duration(req.Unique.Window) * time.Second,
+		OnConflict: req.Unique.OnConflict,
+	}
+	if u.Mode == "" {
+		u.Mode = domain.UniqueActive
+	}
+	if err := queue.ValidateUnique(u); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, existing, err := s.repo.EnqueueUnique(r.Context(), task, u)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeRepoError(w, err)
 		return
 	}
-	writeJSON(w, http.StatusAccepted, submitResp{ID: id})
+	code := http.StatusAccepted
+	if existing {
+		code = http.StatusOK
+	}
+	writeJSON(w, code, submitResp{ID: id, Existing: existing})
 }
 
 func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
@@ -122,25 +257,178 @@ func (s *Server) getTask(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", 404)
 		return
 	}
+	writeJSON(w, 200, taskView(t))
+}
+
+var templateFuncs = template.FuncMap{
+	// percent formats handler-reported progress for display.
+	"percent": func(p *float64) string {
+		if p == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*p, 'f', 0, 64) + "%"
+	},
+	// nextRunLocal formats a schedule's next run in the zone its cron
+	// expression is evaluated in, whether set via Timezone or CRON_TZ=.
+	"nextRunLocal": func(sch domain.Schedule) string {
+		spec, err := scheduler.Parse(sch.CronExpr, sch.Timezone)
+		if err != nil || sch.NextRun.IsZero() {
+			return "-"
+		}
+		return sch.NextRun.In(spec.Location()).Format("2006-01-02 15:04 MST")
+	},
+	"utc": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+}
+
+func taskView(t domain.Task) map[string]any {
+	var expiresAt any
+	if t.ExpiresAt != nil {
+		expiresAt = t.ExpiresAt.Format(time.RFC3339)
+	}
+	return map[string]any{
+		"id":             t.ID,
+		"type":           t.Type,
+		"state":          t.State,
+		"attempts":       t.Attempts,
+		"max_attempts":   t.MaxAttempts,
+		"priority":       t.Priority,
+		"next_run_at":    t.NextRunAt.Format(time.RFC3339),
+		"created_at":     t.CreatedAt.Format(time.RFC3339),
+		"progress":       t.Progress,
+		"status_message": t.StatusMessage,
+		"schedule_id":    t.ScheduleID,
+		"expires_at":     expiresAt,
+		"unique_key":     t.UniqueKey,
+	}
+}
+
+func (s *Server) listTasks(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	tasks, err := s.repo.ListTasks(r.Context(), queue.TaskFilter{
+		State:      r.URL.Query().Get("state"),
+		Type:       r.URL.Query().Get("type"),
+		ScheduleID: r.URL.Query().Get("schedule_id"),
+		Limit:      limit,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	views := make([]map[string]any, 0, len(tasks))
+	for _, t := range tasks {
+		views = append(views, taskView(t))
+	}
+	writeJSON(w, 200, views)
+}
+
+func (s *Server) cancelTask(w http.ResponseWriter, r *http.Request) {
+	s.transitionTask(w, r, s.repo.Cancel)
+}
+
+func (s *Server) retryTask(w http.ResponseWriter, r *http.Request) {
+	s.transitionTask(w, r, s.repo.Requeue)
+}
+
+func (s *Server) transitionTask(w http.ResponseWriter, r *http.Request, fn func(context.Context, string) error) {
+	id := chi.URLParam(r, "id")
+	if err := fn(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	t, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, 200, taskView(t))
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	counts, err := s.repo.CountTasksByState(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	schedules, err := s.repo.ListSchedules(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	enabled := 0
+	for _, sc := range schedules {
+		if sc.Enabled {
+			enabled++
+		}
+	}
 	writeJSON(w, 200, map[string]any{
-		"id":           t.ID,
-		"type":         t.Type,
-		"state":        t.State,
-		"attempts":     t.Attempts,
-		"max_attempts": t.MaxAttempts,
-		"priority":     t.Priority,
-		"next_run_at":  t.NextRunAt.Format(time.RFC3339),
+		"tasks": counts,
+		"schedules": map[string]int{
+			"total":   len(schedules),
+			"enabled": enabled,
+		},
 	})
 }
 
 type createScheduleReq struct {
 	Name        string          `json:"name"`
 	CronExpr    string          `json:"cron_expr"`
+	Timezone    string          `json:"timezone"`
 	TaskType    string          `json:"task_type"`
 	Payload     json.RawMessage `json:"payload"`
 	Priority    int             `json:"priority"`
 	MaxAttempts int             `json:"max_attempts"`
 	Enabled     bool            `json:"enabled"`
+	// MisfirePolicy is run_once (default), skip or run_all; see
+	// domain.MisfireRunOnce. MisfireGrace is in seconds. Null leaves
+	// MisfireGrace and MaxCatchup unchanged on update, 0 resets them to
+	// the defaults.
+	MisfirePolicy string `json:"misfire_policy"`
+	MisfireGrace  *int   `json:"misfire_grace"`
+	MaxCatchup    *int   `json:"max_catchup"`
+	// ConcurrencyPolicy is allow (default), forbid or replace.
+	ConcurrencyPolicy string `json:"concurrency_policy"`
+	// StartAt and EndAt limit fires to a window (RFC 3339).
+	StartAt          *time.Time `json:"start_at"`
+	EndAt            *time.Time `json:"end_at"`
+	ExcludeCalendars []string   `json:"exclude_calendars"`
+	// Jitter is a window in seconds each fire is delayed within; null
+	// leaves it unchanged on update.
+	Jitter *int `json:"jitter"`
+}
+
+// decodeScheduleUpdate reads an update request and the top-level fields it
+// sets to null or empty. Omitted fields keep their value, while these clear
+// it: {"timezone": null} goes back to the server's zone, {"end_at": ""}
+// lets the schedule run forever.
+func decodeScheduleUpdate(body io.Reader) (createScheduleReq, map[string]bool, error) {
+	var req createScheduleReq
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		return req, nil, err
+	}
+	cleared := make(map[string]bool)
+	for name, raw := range fields {
+		switch string(bytes.TrimSpace(raw)) {
+		case "null", `""`, "[]":
+			cleared[name] = true
+			// "" isn't a valid time, so drop cleared fields before
+			// decoding the rest.
+			delete(fields, name)
+		}
+	}
+	rest, err := json.Marshal(fields)
+	if err != nil {
+		return req, nil, err
+	}
+	if err := json.Unmarshal(rest, &req); err != nil {
+		return req, nil, err
+	}
+	return req, cleared, nil
 }
 
 type createScheduleResp struct {
@@ -167,28 +455,71 @@ func (s *Server) createSchedule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate cron expression
-	if err := scheduler.ValidateCronExpression(req.CronExpr); err != nil {
+	if err := scheduler.ValidateCronExpression(req.CronExpr, req.Timezone); err != nil {
 		http.Error(w, "invalid cron expression: "+err.Error(), 400)
 		return
 	}
 
-	// Calculate next run time
-	nextRun, err := scheduler.NextRunTime(req.CronExpr, time.Now())
-	if err != nil {
-		http.Error(w, "failed to calculate next run time: "+err.Error(), 400)
+	if err := scheduler.ValidatePayload(req.Payload); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var grace, maxCatchup int
+	if req.MisfireGrace != nil {
+		grace = *req.MisfireGrace
+	}
+	if req.MaxCatchup != nil {
+		maxCatchup = *req.MaxCatchup
+	}
+	if err := scheduler.ValidateMisfire(req.MisfirePolicy, grace, maxCatchup); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := scheduler.ValidateConcurrency(req.ConcurrencyPolicy); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var jitter int
+	if req.Jitter != nil {
+		jitter = *req.Jitter
+	}
+	if err := scheduler.ValidateJitter(jitter); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
 	schedule := domain.Schedule{
+		// The jitter offset is derived from the ID, so it is needed before
+		// the first run can be computed.
+		ID:          queue.NewScheduleID(),
 		Name:        req.Name,
 		CronExpr:    req.CronExpr,
+		Timezone:    req.Timezone,
 		TaskType:    req.TaskType,
 		Payload:     req.Payload,
 		Priority:    req.Priority,
 		MaxAttempts: req.MaxAttempts,
 		Enabled:     req.Enabled,
-		NextRun:     nextRun,
+
+		MisfirePolicy: req.MisfirePolicy,
+		MisfireGrace:  grace,
+		MaxCatchup:    maxCatchup,
+
+		ConcurrencyPolicy: req.ConcurrencyPolicy,
+
+		StartAt:          req.StartAt,
+		EndAt:            req.EndAt,
+		ExcludeCalendars: req.ExcludeCalendars,
+		Jitter:           jitter,
+	}
+
+	// Calculate next run time
+	nextRun, err := scheduler.NextRun(r.Context(), s.repo, schedule, time.Now())
+	if err != nil {
+		http.Error(w, "failed to calculate next run time: "+err.Error(), 400)
+		return
 	}
+	schedule.NextRun = nextRun
 
 	id, err := s.repo.CreateSchedule(r.Context(), schedule)
 	if err != nil {
@@ -226,9 +557,12 @@ func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", 404)
 		return
 	}
+	if rejectFileManaged(w, schedule) {
+		return
+	}
 
-	var req createScheduleReq
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	req, cleared, err := decodeScheduleUpdate(r.Body)
+	if err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
@@ -237,14 +571,45 @@ func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
 	if req.Name != "" {
 		schedule.Name = req.Name
 	}
+	// A change to when the schedule fires, or enabling it, recomputes its
+	// next run. A schedule enabled again starts from now rather than
+	// catching up on the fires it missed while disabled.
+	retime := req.Enabled && (!schedule.Enabled || schedule.NextRun.IsZero())
 	if req.CronExpr != "" {
-		if err := scheduler.ValidateCronExpression(req.CronExpr); err != nil {
+		schedule.CronExpr = req.CronExpr
+		retime = true
+	}
+	if req.Timezone != "" || cleared["timezone"] {
+		schedule.Timezone = req.Timezone
+		retime = true
+	}
+	if req.StartAt != nil || cleared["start_at"] {
+		schedule.StartAt = req.StartAt
+		retime = true
+	}
+	if req.EndAt != nil || cleared["end_at"] {
+		schedule.EndAt = req.EndAt
+		retime = true
+	}
+	if req.ExcludeCalendars != nil || cleared["exclude_calendars"] {
+		schedule.ExcludeCalendars = req.ExcludeCalendars
+		retime = true
+	}
+	if req.Jitter != nil {
+		if err := scheduler.ValidateJitter(*req.Jitter); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		schedule.Jitter = *req.Jitter
+		retime = true
+	}
+	if retime {
+		if err := scheduler.ValidateCronExpression(schedule.CronExpr, schedule.Timezone); err != nil {
 			http.Error(w, "invalid cron expression: "+err.Error(), 400)
 			return
 		}
-		schedule.CronExpr = req.CronExpr
 		// Recalculate next run time
-		nextRun, err := scheduler.NextRunTime(req.CronExpr, time.Now())
+		nextRun, err := scheduler.NextRun(r.Context(), s.repo, schedule, time.Now())
 		if err != nil {
 			http.Error(w, "failed to calculate next run time: "+err.Error(), 400)
 			return
@@ -255,6 +620,10 @@ func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
 		schedule.TaskType = req.TaskType
 	}
 	if req.Payload != nil {
+		if err := scheduler.ValidatePayload(req.Payload); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
 		schedule.Payload = req.Payload
 	}
 	if req.Priority > 0 {
@@ -263,6 +632,26 @@ func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
 	if req.MaxAttempts > 0 {
 		schedule.MaxAttempts = req.MaxAttempts
 	}
+	if req.MisfirePolicy != "" {
+		schedule.MisfirePolicy = req.MisfirePolicy
+	}
+	if req.MisfireGrace != nil {
+		schedule.MisfireGrace = *req.MisfireGrace
+	}
+	if req.MaxCatchup != nil {
+		schedule.MaxCatchup = *req.MaxCatchup
+	}
+	if req.ConcurrencyPolicy != "" {
+		schedule.ConcurrencyPolicy = req.ConcurrencyPolicy
+	}
+	if err := scheduler.ValidateMisfire(schedule.MisfirePolicy, schedule.MisfireGrace, schedule.MaxCatchup); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := scheduler.ValidateConcurrency(schedule.ConcurrencyPolicy); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
 	schedule.Enabled = req.Enabled
 
 	if err := s.repo.UpdateSchedule(r.Context(), schedule); err != nil {
@@ -274,6 +663,9 @@ func (s *Server) updateSchedule(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	if sch, err := s.repo.GetSchedule(r.Context(), id); err == nil && rejectFileManaged(w, sch) {
+		return
+	}
 	if err := s.repo.DeleteSchedule(r.Context(), id); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -281,9 +673,97 @@ func (s *Server) deleteSchedule(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (s *Server) triggerSchedule(w http.ResponseWriter, r *http.Request) {
+	schedule, err := s.repo.GetSchedule(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	ok, err := scheduler.Admit(r.Context(), s.repo, schedule)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if !ok {
+		http.Error(w, "previous run still active", http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	task, err := scheduler.TaskFromSchedule(schedule, now, now)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	id, err := s.repo.Enqueue(r.Context(), task)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	// Manual runs count like scheduled ones, so {{.RunCount}} stays unique.
+	if err := s.repo.CountScheduleRun(r.Context(), schedule.ID, now); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, submitResp{ID: id})
+}
+
+// listScheduleRuns returns the tasks a schedule enqueued, newest first,
+// with the error of each task's latest attempt.
+func (s *Server) listScheduleRuns(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.repo.GetSchedule(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	runs, err := s.repo.ListScheduleRuns(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	views := make([]map[string]any, 0, len(runs))
+	for _, run := range runs {
+		v := taskView(run.Task)
+		v["updated_at"] = run.Task.UpdatedAt.Format(time.RFC3339)
+		v["error"] = run.Error
+		views = append(views, v)
+	}
+	writeJSON(w, 200, views)
+}
+
+type misfireView struct {
+	ID        int64  `json:"id"`
+	FireTime  string `json:"fire_time"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+// listScheduleMisfires returns the schedule's skipped fires, newest first.
+func (s *Server) listScheduleMisfires(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, err := s.repo.GetSchedule(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	misfires, err := s.repo.ListScheduleMisfires(r.Context(), id, limit)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	out := make([]misfireView, 0, len(misfires))
+	for _, m := range misfires {
+		out = append(out, misfireView{
+			ID: m.ID, FireTime: m.FireTime.UTC().Format(time.RFC3339),
+			Reason: m.Reason, CreatedAt: m.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	writeJSON(w, 200, out)
+}
+
 // Dashboard handlers
 func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
-	if err := s.templates.ExecuteTemplate(w, "dashboard.html", nil); err != nil {
+	if err := s.templates.ExecuteTemplate(w, "dashboard.html", map[string]any{"Auth": s.opts.Auth}); err != nil {
 		http.Error(w, err.Error(), 500)
 	}
 }
@@ -298,7 +778,7 @@ func (s *Server) dashboardTasks(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "text/html")
 	if len(tasks) > 0 {
-		w.Write([]byte(`<table class="table"><thead><tr><th>ID</th><th>Type</th><th>State</th><th>Attempts</th><th>Priority</th><th>Created</th></tr></thead><tbody>`))
+		w.Write([]byte(`<table class="table"><thead><tr><th>ID</th><th>Type</th><th>State</th><th>Attempts</th><th>Priority</th><th>Progress</th><th>Created</th><th>Logs</th></tr></thead><tbody>`))
 		if err := s.templates.ExecuteTemplate(w, "tasks.html", tasks); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
@@ -309,6 +789,38 @@ func (s *Server) dashboardTasks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// sparklineRuns is how many recent runs the dashboard history shows.
+const sparklineRuns = 20
+
+// scheduleRow is a schedule with its recent runs, oldest first, for the
+// dashboard history sparkline.
+type scheduleRow struct {
+	domain.Schedule
+	Runs        []domain.ScheduleRun
+	SuccessRate *float64 // percent of finished runs that succeeded, nil if none finished
+}
+
+func newScheduleRow(sch domain.Schedule, runs []domain.ScheduleRun) scheduleRow {
+	row := scheduleRow{Schedule: sch}
+	var finished, succeeded int
+	for i := len(runs) - 1; i >= 0; i-- {
+		run := runs[i]
+		row.Runs = append(row.Runs, run)
+		switch run.Task.State {
+		case "succeeded":
+			succeeded++
+			finished++
+		case "failed":
+			finished++
+		}
+	}
+	if finished > 0 {
+		rate := float64(succeeded) / float64(finished) * 100
+		row.SuccessRate = &rate
+	}
+	return row
+}
+
 func (s *Server) dashboardSchedules(w http.ResponseWriter, r *http.Request) {
 	schedules, err := s.repo.ListSchedules(r.Context())
 	if err != nil {
@@ -316,10 +828,20 @@ func (s *Server) dashboardSchedules(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	runs, err := s.repo.RecentScheduleRuns(r.Context(), sparklineRuns)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	rows := make([]scheduleRow, 0, len(schedules))
+	for _, sch := range schedules {
+		rows = append(rows, newScheduleRow(sch, runs[sch.ID]))
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	if len(schedules) > 0 {
-		w.Write([]byte(`<table class="table"><thead><tr><th>Name</th><th>Cron</th><th>Type</th><th>Enabled</th><th>Last Run</th><th>Next Run</th><th>Actions</th></tr></thead><tbody>`))
-		if err := s.templates.ExecuteTemplate(w, "schedules.html", schedules); err != nil {
+		w.Write([]byte(`<table class="table"><thead><tr><th>Name</th><th>Cron</th><th>Type</th><th>Enabled</th><th>Last Run</th><th>Next Run (UTC)</th><th>Next Run (schedule zone)</th><th>History</th><th>Actions</th></tr></thead><tbody>`))
+		if err := s.templates.ExecuteTemplate(w, "schedules.html", rows); err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
@@ -346,14 +868,15 @@ func (s *Server) dashboardSubmitTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Non-positive values fall back to the repository defaults.
 	priority, _ := strconv.Atoi(priorityStr)
-	if priority <= 0 {
-		priority = 5
+	if priority < 0 {
+		priority = 0
 	}
 
 	maxAttempts, _ := strconv.Atoi(maxAttemptsStr)
-	if maxAttempts <= 0 {
-		maxAttempts = 5
+	if maxAttempts < 0 {
+		maxAttempts = 0
 	}
 
 	task := domain.Task{
@@ -385,6 +908,9 @@ func (s *Server) dashboardCreateSchedule(w http.ResponseWriter, r *http.Request)
 
 	name := r.FormValue("name")
 	cronExpr := r.FormValue("cron_expr")
+	timezone := r.FormValue("timezone")
+	misfirePolicy := r.FormValue("misfire_policy")
+	concurrencyPolicy := r.FormValue("concurrency_policy")
 	taskType := r.FormValue("task_type")
 	payload := r.FormValue("payload")
 	priorityStr := r.FormValue("priority")
@@ -396,37 +922,63 @@ func (s *Server) dashboardCreateSchedule(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := scheduler.ValidateCronExpression(cronExpr); err != nil {
+	if err := scheduler.ValidateCronExpression(cronExpr, timezone); err != nil {
 		http.Error(w, "invalid cron expression: "+err.Error(), 400)
 		return
 	}
 
-	nextRun, err := scheduler.NextRunTime(cronExpr, time.Now())
-	if err != nil {
-		http.Error(w, "failed to calculate next run time: "+err.Error(), 400)
+	if err := scheduler.ValidatePayload([]byte(payload)); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := scheduler.ValidateMisfire(misfirePolicy, 0, 0); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err := scheduler.ValidateConcurrency(concurrencyPolicy); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	jitter, _ := strconv.Atoi(r.FormValue("jitter"))
+	if err := scheduler.ValidateJitter(jitter); err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
+	// Non-positive values fall back to the repository defaults.
 	priority, _ := strconv.Atoi(priorityStr)
-	if priority <= 0 {
-		priority = 5
+	if priority < 0 {
+		priority = 0
 	}
 
 	maxAttempts, _ := strconv.Atoi(maxAttemptsStr)
-	if maxAttempts <= 0 {
-		maxAttempts = 5
+	if maxAttempts < 0 {
+		maxAttempts = 0
 	}
 
 	schedule := domain.Schedule{
+		ID:          queue.NewScheduleID(),
 		Name:        name,
 		CronExpr:    cronExpr,
+		Timezone:    timezone,
 		TaskType:    taskType,
 		Payload:     []byte(payload),
 		Priority:    priority,
 		MaxAttempts: maxAttempts,
 		Enabled:     enabled,
-		NextRun:     nextRun,
+
+		MisfirePolicy:     misfirePolicy,
+		ConcurrencyPolicy: concurrencyPolicy,
+		Jitter:            jitter,
+	}
+	schedule.ExcludeCalendars = splitNames(r.FormValue("exclude_calendars"))
+
+	nextRun, err := scheduler.NextRun(r.Context(), s.repo, schedule, time.Now())
+	if err != nil {
+		http.Error(w, "failed to calculate next run time: "+err.Error(), 400)
+		return
 	}
+	schedule.NextRun = nextRun
 
 	_, err = s.repo.CreateSchedule(r.Context(), schedule)
 	if err != nil {
@@ -440,6 +992,9 @@ func (s *Server) dashboardCreateSchedule(w http.ResponseWriter, r *http.Request)
 
 func (s *Server) dashboardDeleteSchedule(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
+	if sch, err := s.repo.GetSchedule(r.Context(), id); err == nil && rejectFileManaged(w, sch) {
+		return
+	}
 	if err := s.repo.DeleteSchedule(r.Context(), id); err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -449,6 +1004,28 @@ func (s *Server) dashboardDeleteSchedule(w http.ResponseWriter, r *http.Request)
 	s.dashboardSchedules(w, r)
 }
 
+// rejectFileManaged answers 409 for a schedule defined in a file, whose
+// next reload would undo any edit made here.
+func rejectFileManaged(w http.ResponseWriter, schedule domain.Schedule) bool {
+	if schedule.Source == "" {
+		return false
+	}
+	http.Error(w, "schedule is managed by definition file "+schedule.Source+"; change the file instead", http.StatusConflict)
+	return true
+}
+
+// writeRepoError maps repository errors onto HTTP status codes.
+func writeRepoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, "not found", 404)
+	case errors.Is(err, queue.ErrInvalidState), errors.Is(err, queue.ErrDuplicate):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), 500)
+	}
+}
+
 func writeJSON(w http.ResponseWriter, code int, v any) {
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(code)
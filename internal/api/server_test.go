@@ -0,0 +1,169 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	_ "modernc.org/sqlite"
+)
+
+// TestMain runs the tests from the repository root, where the server
+// finds its templates.
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newTestServer(t *testing.T) (http.Handler, queue.Repository) {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := queue.EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	repo := queue.NewSQLiteRepo(db)
+	return NewServer(repo), repo
+}
+
+// do sends a JSON request to h and decodes the response into out.
+func do(t *testing.T, h http.Handler, method, path, body string, out any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	if out != nil && rec.Code < 300 {
+		if err := json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestDecodeScheduleUpdate(t *testing.T) {
+	tests := []struct {
+		body    string
+		cleared []string
+		check   func(createScheduleReq) bool
+	}{
+		{`{"name":"n"}`, nil, func(r createScheduleReq) bool { return r.Name == "n" }},
+		{`{"timezone":null}`, []string{"timezone"}, nil},
+		{`{"timezone":""}`, []string{"timezone"}, nil},
+		{`{"timezone":"UTC"}`, nil, func(r createScheduleReq) bool { return r.Timezone == "UTC" }},
+		{`{"start_at":"","end_at":null}`, []string{"start_at", "end_at"}, func(r createScheduleReq) bool {
+			return r.StartAt == nil && r.EndAt == nil
+		}},
+		{`{"end_at":"2026-01-02T03:04:05Z"}`, nil, func(r createScheduleReq) bool {
+			return r.EndAt != nil && r.EndAt.Year() == 2026
+		}},
+		{`{"exclude_calendars":[]}`, []string{"exclude_calendars"}, nil},
+		{`{"exclude_calendars":null, "payload":{"a":[1,2]}}`, []string{"exclude_calendars"}, func(r createScheduleReq) bool {
+			return string(r.Payload) == `{"a":[1,2]}`
+		}},
+	}
+	for _, tt := range tests {
+		req, cleared, err := decodeScheduleUpdate(strings.NewReader(tt.body))
+		if err != nil {
+			t.Errorf("%s: %v", tt.body, err)
+			continue
+		}
+		if len(cleared) != len(tt.cleared) {
+			t.Errorf("%s: cleared = %v, want %v", tt.body, cleared, tt.cleared)
+		}
+		for _, name := range tt.cleared {
+			if !cleared[name] {
+				t.Errorf("%s: %s not cleared", tt.body, name)
+			}
+		}
+		if tt.check != nil && !tt.check(req) {
+			t.Errorf("%s: decoded %+v", tt.body, req)
+		}
+	}
+	if _, _, err := decodeScheduleUpdate(strings.NewReader(`{"start_at":"soon"}`)); err == nil {
+		t.Error("invalid start_at accepted")
+	}
+}
+
+func TestUpdateScheduleReenable(t *testing.T) {
+	ctx := context.Background()
+	h, repo := newTestServer(t)
+	// Disabled three weeks ago under run_all.
+	stale := time.Now().Add(-21 * 24 * time.Hour).UTC().Truncate(time.Second)
+	id, err := repo.CreateSchedule(ctx, domain.Schedule{
+		Name: "daily", CronExpr: "0 3 * * *", TaskType: "shell", Payload: []byte(`{}`),
+		MisfirePolicy: domain.MisfireRunAll, MisfireGrace: 120, MaxCatchup: 10, NextRun: stale,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s domain.Schedule
+	if code := do(t, h, "PUT", "/api/schedules/"+id, `{"enabled":true}`, &s); code != 200 {
+		t.Fatalf("enable: status %d", code)
+	}
+	if !s.Enabled || !s.NextRun.After(time.Now()) {
+		t.Errorf("enabled schedule next run %s, want after now", s.NextRun)
+	}
+	next := s.NextRun
+
+	// Updating an enabled schedule keeps its next run.
+	if code := do(t, h, "PUT", "/api/schedules/"+id, `{"enabled":true,"priority":7}`, &s); code != 200 || !s.NextRun.Equal(next) {
+		t.Errorf("update: status %d, next run %s, want %s", code, s.NextRun, next)
+	}
+
+	// Null keeps the misfire settings, 0 resets them.
+	if do(t, h, "PUT", "/api/schedules/"+id, `{"enabled":true,"misfire_grace":null}`, &s); s.MisfireGrace != 120 || s.MaxCatchup != 10 {
+		t.Errorf("null changed misfire settings: grace %d, max catchup %d", s.MisfireGrace, s.MaxCatchup)
+	}
+	if do(t, h, "PUT", "/api/schedules/"+id, `{"enabled":true,"misfire_grace":0,"max_catchup":0}`, &s); s.MisfireGrace != 0 || s.MaxCatchup != 0 {
+		t.Errorf("0 kept misfire settings: grace %d, max catchup %d", s.MisfireGrace, s.MaxCatchup)
+	}
+	if code := do(t, h, "PUT", "/api/schedules/"+id, `{"enabled":true,"max_catchup":-1}`, nil); code != 400 {
+		t.Errorf("negative max catchup: status %d, want 400", code)
+	}
+}
+
+func TestTriggerScheduleCountsRun(t *testing.T) {
+	ctx := context.Background()
+	h, repo := newTestServer(t)
+	next := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	id, err := repo.CreateSchedule(ctx, domain.Schedule{
+		Name: "report", CronExpr: "0 * * * *", TaskType: "shell", Enabled: true, NextRun: next,
+		Payload: []byte(`{"command":"echo","args":["run {{.RunCount}}"]}`), RunCount: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"run 5", "run 6"} {
+		var resp submitResp
+		if code := do(t, h, "POST", "/api/schedules/"+id+"/trigger", "", &resp); code != http.StatusAccepted {
+			t.Fatalf("trigger: status %d", code)
+		}
+		task, err := repo.Get(ctx, resp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(task.Payload), want) {
+			t.Errorf("payload %s, want %q", task.Payload, want)
+		}
+	}
+	s, _ := repo.GetSchedule(ctx, id)
+	if s.RunCount != 6 || s.LastRun == nil || !s.NextRun.Equal(next) {
+		t.Errorf("after triggers: run count %d, last run %v, next run %s", s.RunCount, s.LastRun, s.NextRun)
+	}
+}
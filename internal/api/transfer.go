@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"localflow/internal/transfer"
+)
+
+// export streams schedules, calendars and, with ?tasks=queued,failed, the
+// tasks in those states. ?format=json writes a single document instead of
+// NDJSON.
+func (s *Server) export(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := transfer.ExportOptions{TaskStates: splitNames(q.Get("tasks"))}
+	if err := transfer.ValidateTaskStates(opts.TaskStates); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	contentType, ext := "application/x-ndjson", "ndjson"
+	switch q.Get("format") {
+	case "", "ndjson":
+	case "json":
+		opts.Document = true
+		contentType, ext = "application/json", "json"
+	default:
+		http.Error(w, "format must be ndjson or json", 400)
+		return
+	}
+
+	w.Header().Set("content-type", contentType)
+	w.Header().Set("content-disposition", fmt.Sprintf(`attachment; filename="localflow-%s.%s"`, time.Now().UTC().Format("20060102-150405"), ext))
+	if err := transfer.Export(r.Context(), s.repo, w, opts); err != nil {
+		// Headers are gone by now; the truncated body fails to import.
+		log.Error().Err(err).Msg("export failed")
+	}
+}
+
+// importData reads an export in either form and applies it with the
+// ?conflict policy, skip by default.
+func (s *Server) importData(w http.ResponseWriter, r *http.Request) {
+	conflict := r.URL.Query().Get("conflict")
+	if conflict == "" {
+		conflict = transfer.ConflictSkip
+	}
+	if err := transfer.ValidateConflict(conflict); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	doc, err := transfer.Read(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := transfer.Import(r.Context(), s.repo, doc, conflict)
+	if errors.Is(err, transfer.ErrInvalid) {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	log.Info().Interface("result", res).Msg("import finished")
+	writeJSON(w, 200, res)
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"localflow/internal/domain"
+)
+
+// Scopes granted to API keys. Admin implies every other scope.
+const (
+	ScopeRead   = "read"
+	ScopeSubmit = "submit"
+	ScopeAdmin  = "admin"
+)
+
+// KeyPrefix marks localflow API keys so they are recognisable in configs
+// and secret scanners.
+const KeyPrefix = "lf_"
+
+// ParseScopes parses a comma separated scope list.
+func ParseScopes(s string) ([]string, error) {
+	var scopes []string
+	seen := map[string]bool{}
+	for _, sc := range strings.Split(s, ",") {
+		sc = strings.TrimSpace(sc)
+		if sc == "" || seen[sc] {
+			continue
+		}
+		switch sc {
+		case ScopeRead, ScopeSubmit, ScopeAdmin:
+		default:
+			return nil, fmt.Errorf("unknown scope %q (want read, submit or admin)", sc)
+		}
+		seen[sc] = true
+		scopes = append(scopes, sc)
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is required")
+	}
+	return scopes, nil
+}
+
+// HasScope reports whether scopes grant want.
+func HasScope(scopes []string, want string) bool {
+	for _, sc := range scopes {
+		if sc == want || sc == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// NewToken returns a random token with the given prefix and its hash. Only
+// the hash is stored; the token is shown to the user once.
+func NewToken(prefix string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = prefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken hashes a token for storage and lookup. Tokens carry 256 bits of
+// randomness, so a plain SHA-256 is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type ctxKey struct{}
+
+// WithKey attaches the authenticated key to a context.
+func WithKey(ctx context.Context, k domain.APIKey) context.Context {
+	return context.WithValue(ctx, ctxKey{}, k)
+}
+
+// KeyFromContext returns the authenticated key, if any.
+func KeyFromContext(ctx context.Context) (domain.APIKey, bool) {
+	k, ok := ctx.Value(ctxKey{}).(domain.APIKey)
+	return k, ok
+}
@@ -0,0 +1,153 @@
+// Package backup takes consistent snapshots of the database while the
+// server runs, on demand or periodically with retention.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"localflow/internal/queue"
+)
+
+const (
+	prefix = "localflow-"
+	suffix = ".db"
+)
+
+// ErrExists is returned by Snapshot when the target path is taken.
+var ErrExists = errors.New("snapshot file already exists")
+
+// Info describes a snapshot that was written.
+type Info struct {
+	Path string
+	Size int64 // bytes
+	Took time.Duration
+}
+
+// Name returns the file name of a snapshot taken at t. Names sort by time.
+func Name(t time.Time) string {
+	return prefix + t.UTC().Format("20060102T150405.000Z") + suffix
+}
+
+// Snapshot writes a copy of the database to path. The copy is written
+// next to path first and renamed into place, so path never holds a
+// partial file. An existing file at path is an error.
+func Snapshot(ctx context.Context, repo queue.Repository, path string) (Info, error) {
+	start := time.Now()
+	if _, err := os.Stat(path); err == nil {
+		return Info{}, fmt.Errorf("%w: %s", ErrExists, path)
+	}
+	tmp := path + ".partial"
+	// Left over from an interrupted snapshot; VACUUM INTO won't overwrite it.
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Info{}, err
+	}
+	if err := repo.Backup(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return Info{}, fmt.Errorf("backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return Info{}, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Path: path, Size: fi.Size(), Took: time.Since(start)}, nil
+}
+
+// Prune deletes all but the newest keep snapshots in dir, judged by their
+// names, and returns the deleted paths. Other files are left alone.
+func Prune(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), prefix) && strings.HasSuffix(e.Name(), suffix) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= keep {
+		return nil, nil
+	}
+	sort.Strings(names)
+	var deleted []string
+	for _, name := range names[:len(names)-keep] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, path)
+	}
+	return deleted, nil
+}
+
+// Service snapshots the database into a directory at a fixed interval.
+type Service struct {
+	repo     queue.Repository
+	dir      string
+	interval time.Duration
+	keep     int // snapshots kept in dir, 0 keeps all
+	stop     chan struct{}
+}
+
+func NewService(repo queue.Repository, dir string, interval time.Duration) *Service {
+	return &Service{repo: repo, dir: dir, interval: interval, stop: make(chan struct{})}
+}
+
+// WithKeep deletes older snapshots after each one so that n remain. n <= 0
+// keeps every snapshot.
+func (s *Service) WithKeep(n int) *Service {
+	s.keep = max(n, 0)
+	return s
+}
+
+func (s *Service) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", s.interval).Str("dir", s.dir).Int("keep", s.keep).Msg("backup service started")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.snapshot(ctx, now)
+		}
+	}
+}
+
+func (s *Service) Stop() {
+	close(s.stop)
+}
+
+func (s *Service) snapshot(ctx context.Context, now time.Time) {
+	info, err := Snapshot(ctx, s.repo, filepath.Join(s.dir, Name(now)))
+	if err != nil {
+		log.Error().Err(err).Msg("snapshot failed")
+		return
+	}
+	log.Info().Str("path", info.Path).Int64("size", info.Size).Dur("took", info.Took).Msg("snapshot written")
+	if s.keep == 0 {
+		return
+	}
+	deleted, err := Prune(s.dir, s.keep)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to delete old snapshots")
+	}
+	if len(deleted) > 0 {
+		log.Info().Strs("deleted", deleted).Msg("old snapshots deleted")
+	}
+}
@@ -0,0 +1,534 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/transfer"
+)
+
+// Client talks to a running localflow server over its REST API.
+type Client struct {
+	BaseURL string
+	// APIKey is sent as a bearer token when set.
+	APIKey string
+	HTTP   *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Task mirrors the JSON task view returned by /api/tasks.
+type Task struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	State       string `json:"state"`
+	Attempts    int    `json:"attempts"`
+	MaxAttempts int    `json:"max_attempts"`
+	Priority    int    `json:"priority"`
+	NextRunAt   string `json:"next_run_at"`
+	CreatedAt   string `json:"created_at"`
+	// Progress is the handler-reported percent complete, nil if unknown.
+	Progress      *float64 `json:"progress"`
+	StatusMessage string   `json:"status_message"`
+	ScheduleID    string   `json:"schedule_id"`
+	ExpiresAt     string   `json:"expires_at"`
+	UniqueKey     string   `json:"unique_key"`
+}
+
+type SubmitTask struct {
+	Type           string          `json:"type"`
+	Payload        json.RawMessage `json:"payload"`
+	Priority       int             `json:"priority,omitempty"`
+	MaxAttempts    int             `json:"max_attempts,omitempty"`
+	IdempotencyKey *string         `json:"idempotency_key,omitempty"`
+	// ExpiresAt or TTL (seconds) expire the task if it hasn't run by then.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	TTL       int        `json:"ttl,omitempty"`
+	Unique    *Unique    `json:"unique,omitempty"`
+}
+
+// Unique allows one task per type and Key. Mode is queued, active (the
+// default) or window, with Window in seconds; OnConflict is existing (the
+// default), replace or reject.
+type Unique struct {
+	Key        string `json:"key"`
+	Mode       string `json:"mode,omitempty"`
+	Window     int    `json:"window,omitempty"`
+	OnConflict string `json:"on_conflict,omitempty"`
+}
+
+type CreateSchedule struct {
+	Name        string          `json:"name,omitempty"`
+	CronExpr    string          `json:"cron_expr,omitempty"`
+	Timezone    string          `json:"timezone,omitempty"`
+	TaskType    string          `json:"task_type,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Priority    int             `json:"priority,omitempty"`
+	MaxAttempts int             `json:"max_attempts,omitempty"`
+	Enabled     bool            `json:"enabled"`
+
+	MisfirePolicy string `json:"misfire_policy,omitempty"`
+	MisfireGrace  int    `json:"misfire_grace,omitempty"` // seconds
+	MaxCatchup    int    `json:"max_catchup,omitempty"`
+
+	ConcurrencyPolicy string `json:"concurrency_policy,omitempty"`
+
+	StartAt          *time.Time `json:"start_at,omitempty"`
+	EndAt            *time.Time `json:"end_at,omitempty"`
+	ExcludeCalendars []string   `json:"exclude_calendars,omitempty"`
+	Jitter           *int       `json:"jitter,omitempty"` // seconds
+}
+
+// Calendar is a named set of dates schedules can exclude. Dates are only
+// filled in by GetCalendar.
+type Calendar struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Dates       []string `json:"dates,omitempty"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+// ScheduleRun is a task a schedule enqueued, from /api/schedules/{id}/runs.
+type ScheduleRun struct {
+	Task
+	UpdatedAt string `json:"updated_at"`
+	Error     string `json:"error"`
+}
+
+// Misfire is a schedule fire that did not enqueue a task.
+type Misfire struct {
+	ID        int64  `json:"id"`
+	FireTime  string `json:"fire_time"`
+	Reason    string `json:"reason"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Upcoming lists the next fire times of a schedule or expression.
+type Upcoming struct {
+	Description string `json:"description"`
+	Timezone    string `json:"timezone"`
+	Next        []struct {
+		Time  string `json:"time"`
+		Local string `json:"local"`
+		In    string `json:"in"`
+	} `json:"next"`
+}
+
+// Backup describes a snapshot the server wrote.
+type Backup struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Took string `json:"took"`
+}
+
+type Stats struct {
+	Tasks     map[string]int `json:"tasks"`
+	Schedules map[string]int `json:"schedules"`
+}
+
+// LogLine is one line of task output from /api/tasks/{id}/logs.
+type LogLine struct {
+	ID      int64  `json:"id"`
+	Attempt int    `json:"attempt"`
+	Stream  string `json:"stream"`
+	Line    string `json:"line"`
+	Time    string `json:"time"`
+}
+
+// TaskEvent is a change in a task's state or reported progress.
+type TaskEvent struct {
+	State         string   `json:"state"`
+	Attempts      int      `json:"attempts"`
+	Progress      *float64 `json:"progress"`
+	StatusMessage string   `json:"status_message"`
+	Time          string   `json:"time"`
+}
+
+// Secret is secret metadata; values are never returned by the server.
+type Secret struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type idResp struct {
+	ID string `json:"id"`
+}
+
+// Error is returned for non-2xx responses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Submitted is the result of SubmitTask. Existing is set when a unique
+// submission matched a task that was already there.
+type Submitted struct {
+	ID       string `json:"id"`
+	Existing bool   `json:"existing,omitempty"`
+}
+
+func (c *Client) SubmitTask(ctx context.Context, req SubmitTask) (Submitted, error) {
+	var resp Submitted
+	err := c.do(ctx, http.MethodPost, "/api/tasks", req, &resp)
+	return resp, err
+}
+
+func (c *Client) GetTask(ctx context.Context, id string) (Task, error) {
+	var t Task
+	err := c.do(ctx, http.MethodGet, "/api/tasks/"+url.PathEscape(id), nil, &t)
+	return t, err
+}
+
+func (c *Client) ListTasks(ctx context.Context, state, taskType, scheduleID string, limit int) ([]Task, error) {
+	q := url.Values{}
+	if state != "" {
+		q.Set("state", state)
+	}
+	if taskType != "" {
+		q.Set("type", taskType)
+	}
+	if scheduleID != "" {
+		q.Set("schedule_id", scheduleID)
+	}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var tasks []Task
+	err := c.do(ctx, http.MethodGet, "/api/tasks?"+q.Encode(), nil, &tasks)
+	return tasks, err
+}
+
+func (c *Client) CancelTask(ctx context.Context, id string) (Task, error) {
+	var t Task
+	err := c.do(ctx, http.MethodPost, "/api/tasks/"+url.PathEscape(id)+"/cancel", nil, &t)
+	return t, err
+}
+
+func (c *Client) RetryTask(ctx context.Context, id string) (Task, error) {
+	var t Task
+	err := c.do(ctx, http.MethodPost, "/api/tasks/"+url.PathEscape(id)+"/retry", nil, &t)
+	return t, err
+}
+
+// TaskLogs calls fn for each log line of a task. With follow it keeps
+// streaming until the task finishes or ctx is done; the client's timeout
+// does not apply in that case.
+func (c *Client) TaskLogs(ctx context.Context, id string, follow bool, fn func(LogLine) error) error {
+	path := "/api/tasks/" + url.PathEscape(id) + "/logs"
+	if !follow {
+		var lines []LogLine
+		if err := c.do(ctx, http.MethodGet, path, nil, &lines); err != nil {
+			return err
+		}
+		for _, l := range lines {
+			if err := fn(l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path+"?follow=true", nil)
+	if err != nil {
+		return err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	hc := *c.HTTP
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var l LogLine
+		if err := dec.Decode(&l); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
+}
+
+// WatchTask calls fn with the task's current state and progress, then with
+// every change until the task finishes or ctx is done. The client's timeout
+// does not apply.
+func (c *Client) WatchTask(ctx context.Context, id string, fn func(TaskEvent) error) error {
+	resp, err := c.stream(ctx, http.MethodGet, "/api/tasks/"+url.PathEscape(id)+"/events", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev TaskEvent
+		if err := dec.Decode(&ev); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) CreateSchedule(ctx context.Context, req CreateSchedule) (string, error) {
+	var resp idResp
+	err := c.do(ctx, http.MethodPost, "/api/schedules", req, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+	var schedules []domain.Schedule
+	err := c.do(ctx, http.MethodGet, "/api/schedules", nil, &schedules)
+	return schedules, err
+}
+
+func (c *Client) GetSchedule(ctx context.Context, id string) (domain.Schedule, error) {
+	var s domain.Schedule
+	err := c.do(ctx, http.MethodGet, "/api/schedules/"+url.PathEscape(id), nil, &s)
+	return s, err
+}
+
+func (c *Client) UpdateSchedule(ctx context.Context, id string, req CreateSchedule) (domain.Schedule, error) {
+	var s domain.Schedule
+	err := c.do(ctx, http.MethodPut, "/api/schedules/"+url.PathEscape(id), req, &s)
+	return s, err
+}
+
+func (c *Client) DeleteSchedule(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/schedules/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) TriggerSchedule(ctx context.Context, id string) (string, error) {
+	var resp idResp
+	err := c.do(ctx, http.MethodPost, "/api/schedules/"+url.PathEscape(id)+"/trigger", nil, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) ScheduleRuns(ctx context.Context, id string, limit int) ([]ScheduleRun, error) {
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	var runs []ScheduleRun
+	err := c.do(ctx, http.MethodGet, "/api/schedules/"+url.PathEscape(id)+"/runs?"+q.Encode(), nil, &runs)
+	return runs, err
+}
+
+func (c *Client) ScheduleMisfires(ctx context.Context, id string) ([]Misfire, error) {
+	var list []Misfire
+	err := c.do(ctx, http.MethodGet, "/api/schedules/"+url.PathEscape(id)+"/misfires", nil, &list)
+	return list, err
+}
+
+// PreviewSchedule lists when cronExpr would fire in zone tz, skipping the
+// dates of the exclude calendars, without creating a schedule.
+func (c *Client) PreviewSchedule(ctx context.Context, cronExpr, tz string, exclude []string, n int) (Upcoming, error) {
+	q := url.Values{"cron_expr": {cronExpr}}
+	if tz != "" {
+		q.Set("tz", tz)
+	}
+	if len(exclude) > 0 {
+		q.Set("exclude", strings.Join(exclude, ","))
+	}
+	if n > 0 {
+		q.Set("n", strconv.Itoa(n))
+	}
+	var up Upcoming
+	err := c.do(ctx, http.MethodGet, "/api/schedules/preview?"+q.Encode(), nil, &up)
+	return up, err
+}
+
+func (c *Client) ScheduleUpcoming(ctx context.Context, id string, n int) (Upcoming, error) {
+	q := url.Values{}
+	if n > 0 {
+		q.Set("n", strconv.Itoa(n))
+	}
+	var up Upcoming
+	err := c.do(ctx, http.MethodGet, "/api/schedules/"+url.PathEscape(id)+"/upcoming?"+q.Encode(), nil, &up)
+	return up, err
+}
+
+func (c *Client) PutCalendar(ctx context.Context, name, description string, dates []string) error {
+	return c.do(ctx, http.MethodPut, "/api/calendars/"+url.PathEscape(name), map[string]any{"description": description, "dates": dates}, nil)
+}
+
+func (c *Client) GetCalendar(ctx context.Context, name string) (Calendar, error) {
+	var cal Calendar
+	err := c.do(ctx, http.MethodGet, "/api/calendars/"+url.PathEscape(name), nil, &cal)
+	return cal, err
+}
+
+func (c *Client) ListCalendars(ctx context.Context) ([]Calendar, error) {
+	var list []Calendar
+	err := c.do(ctx, http.MethodGet, "/api/calendars", nil, &list)
+	return list, err
+}
+
+func (c *Client) DeleteCalendar(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/calendars/"+url.PathEscape(name), nil, nil)
+}
+
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	var st Stats
+	err := c.do(ctx, http.MethodGet, "/api/stats", nil, &st)
+	return st, err
+}
+
+func (c *Client) PutSecret(ctx context.Context, name, value string) error {
+	return c.do(ctx, http.MethodPut, "/api/secrets/"+url.PathEscape(name), map[string]string{"value": value}, nil)
+}
+
+func (c *Client) ListSecrets(ctx context.Context) ([]Secret, error) {
+	var list []Secret
+	err := c.do(ctx, http.MethodGet, "/api/secrets", nil, &list)
+	return list, err
+}
+
+func (c *Client) DeleteSecret(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/secrets/"+url.PathEscape(name), nil, nil)
+}
+
+// Backup has the server snapshot its database to path, a path on the
+// server; empty writes a timestamped file in its backup directory.
+func (c *Client) Backup(ctx context.Context, path string) (Backup, error) {
+	var b Backup
+	err := c.do(ctx, http.MethodPost, "/api/backup", map[string]string{"path": path}, &b)
+	return b, err
+}
+
+// DownloadBackup writes a fresh snapshot of the server's database to w.
+// The client's timeout does not apply.
+func (c *Client) DownloadBackup(ctx context.Context, w io.Writer) error {
+	resp, err := c.stream(ctx, http.MethodGet, "/api/backup", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Export writes the server's schedules and calendars, and tasks in the
+// given states, to w as NDJSON, or as one JSON document with document.
+// The client's timeout does not apply.
+func (c *Client) Export(ctx context.Context, taskStates []string, document bool, w io.Writer) error {
+	q := url.Values{}
+	if len(taskStates) > 0 {
+		q.Set("tasks", strings.Join(taskStates, ","))
+	}
+	if document {
+		q.Set("format", "json")
+	}
+	resp, err := c.stream(ctx, http.MethodGet, "/api/export?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Import sends an export to the server, resolving existing records with
+// the conflict policy: skip, overwrite or rename. The client's timeout
+// does not apply.
+func (c *Client) Import(ctx context.Context, r io.Reader, conflict string) (transfer.Result, error) {
+	var res transfer.Result
+	resp, err := c.stream(ctx, http.MethodPost, "/api/import?conflict="+url.QueryEscape(conflict), r)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	return res, err
+}
+
+// stream sends a request without the client's timeout and returns the
+// response for the caller to read and close.
+func (c *Client) stream(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	hc := *c.HTTP
+	hc.Timeout = 0
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,501 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix for environment variable overrides.
+const EnvPrefix = "LOCALFLOW_"
+
+// Config is the server configuration. Values are resolved in order:
+// built-in defaults, config file, LOCALFLOW_* environment, command line flags.
+type Config struct {
+	Addr             string                   `yaml:"addr"`
+	DB               string                   `yaml:"db"`
+	Workers          int                      `yaml:"workers"`
+	Poll             time.Duration            `yaml:"poll"`
+	Debug            bool                     `yaml:"debug"`
+	ScheduleInterval time.Duration            `yaml:"schedule_interval"`
+	ScheduleMaxFires int                      `yaml:"schedule_max_fires"` // tasks one schedule check may enqueue, 0 for no limit
+	ScheduleDir      string                   `yaml:"schedule_dir"`       // YAML schedule definitions, loaded at start and on SIGHUP
+	Defaults         TaskDefaults             `yaml:"defaults"`
+	Handlers         map[string]HandlerConfig `yaml:"handlers"`
+	Auth             AuthConfig               `yaml:"auth"`
+	Shell            ShellConfig              `yaml:"shell"`
+	Secrets          SecretsConfig            `yaml:"secrets"`
+	Backup           BackupConfig             `yaml:"backup"`
+	Retention        RetentionConfig          `yaml:"retention"`
+}
+
+// RetentionConfig deletes finished tasks, with their attempts and logs,
+// once they are older than the period for their state. Types override the
+// periods for individual task types.
+type RetentionConfig struct {
+	RetentionPeriods `yaml:",inline"`
+	Types            map[string]RetentionOverride `yaml:"types"`
+	Interval         time.Duration                `yaml:"interval"`        // how often the pruner runs
+	BatchSize        int                          `yaml:"batch_size"`      // tasks deleted per transaction
+	ArchiveDir       string                       `yaml:"archive_dir"`     // gzipped NDJSON of deleted tasks, empty to not archive
+	VacuumInterval   time.Duration                `yaml:"vacuum_interval"` // free space and refresh statistics, 0 never
+	Misfires         time.Duration                `yaml:"misfires"`        // how long schedule misfire records are kept, 0 forever
+}
+
+// RetentionPeriods are how long finished tasks are kept by state; 0 keeps
+// them forever.
+type RetentionPeriods struct {
+	Succeeded time.Duration `yaml:"succeeded"`
+	Failed    time.Duration `yaml:"failed"`
+	Canceled  time.Duration `yaml:"canceled"`
+	Expired   time.Duration `yaml:"expired"`
+}
+
+// RetentionOverride replaces the periods for one task type. Unset fields
+// keep the global period; 0 keeps the type's tasks forever.
+type RetentionOverride struct {
+	Succeeded *time.Duration `yaml:"succeeded"`
+	Failed    *time.Duration `yaml:"failed"`
+	Canceled  *time.Duration `yaml:"canceled"`
+	Expired   *time.Duration `yaml:"expired"`
+}
+
+// Enabled reports whether any period is set, i.e. the pruner has work.
+func (r RetentionConfig) Enabled() bool {
+	if r.Succeeded > 0 || r.Failed > 0 || r.Canceled > 0 || r.Expired > 0 || r.Misfires > 0 {
+		return true
+	}
+	for _, o := range r.Types {
+		for _, d := range []*time.Duration{o.Succeeded, o.Failed, o.Canceled, o.Expired} {
+			if d != nil && *d > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BackupConfig controls database snapshots. Dir is where snapshots are
+// written, by the backup endpoint and every Interval when it is set; Keep
+// bounds how many periodic snapshots are kept. AllowAnyPath lets the
+// endpoint write to absolute paths outside Dir.
+type BackupConfig struct {
+	Dir          string        `yaml:"dir"`
+	Interval     time.Duration `yaml:"interval"` // 0 disables periodic snapshots
+	Keep         int           `yaml:"keep"`     // 0 keeps every snapshot
+	AllowAnyPath bool          `yaml:"allow_any_path"`
+}
+
+// SecretsConfig controls where named secrets referenced by tasks are looked
+// up. LOCALFLOW_SECRET_<NAME> environment variables are always consulted
+// first, then the encrypted store, then Dir.
+type SecretsConfig struct {
+	// Dir holds one file per secret, named after the secret.
+	Dir string `yaml:"dir"`
+	// MasterKeyFile holds the base64 master key of the encrypted store.
+	// LOCALFLOW_MASTER_KEY takes precedence; without either the store is
+	// disabled.
+	MasterKeyFile string `yaml:"master_key_file"`
+}
+
+// ShellConfig sandboxes the shell handler. With Sandbox false commands run
+// unrestricted and the remaining fields are ignored.
+type ShellConfig struct {
+	Sandbox    bool              `yaml:"sandbox"`
+	Allow      []ShellRule       `yaml:"allow"`
+	Dir        string            `yaml:"dir"`
+	InheritEnv []string          `yaml:"inherit_env"`
+	Env        map[string]string `yaml:"env"`
+	AllowEnv   []string          `yaml:"allow_env"`
+	User       string            `yaml:"user"`
+	MaxOutput  int               `yaml:"max_output"`
+	Limits     ShellLimits       `yaml:"limits"`
+	Cgroup     ShellCgroup       `yaml:"cgroup"`
+}
+
+// ShellRule allows an executable (PATH name or absolute path glob) and,
+// optionally, restricts its arguments to full matches of Args regexps.
+type ShellRule struct {
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+}
+
+type ShellLimits struct {
+	CPUTime   time.Duration `yaml:"cpu_time"`
+	Memory    int64         `yaml:"memory"`
+	OpenFiles uint64        `yaml:"open_files"`
+	Processes uint64        `yaml:"processes"`
+}
+
+type ShellCgroup struct {
+	Enabled bool    `yaml:"enabled"`
+	Parent  string  `yaml:"parent"`
+	CPU     float64 `yaml:"cpu"`
+	Memory  int64   `yaml:"memory"`
+}
+
+// AuthConfig controls API key authentication. When disabled every endpoint,
+// including shell task submission, is open to anyone who can reach addr.
+type AuthConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	SessionTTL time.Duration `yaml:"session_ttl"`
+}
+
+// TaskDefaults apply to submitted tasks that leave the field unset.
+type TaskDefaults struct {
+	Priority          int           `yaml:"priority"`
+	MaxAttempts       int           `yaml:"max_attempts"`
+	VisibilityTimeout time.Duration `yaml:"visibility_timeout"`
+}
+
+// HandlerConfig holds per task type options. Zero values fall back to the
+// task defaults and pool behaviour.
+type HandlerConfig struct {
+	Timeout     time.Duration `yaml:"timeout"`
+	Concurrency int           `yaml:"concurrency"`
+	Retry       RetryPolicy   `yaml:"retry"`
+}
+
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"max_attempts"`
+	BackoffBase time.Duration `yaml:"backoff_base"`
+	BackoffMax  time.Duration `yaml:"backoff_max"`
+}
+
+// Default returns the built-in configuration.
+func Default() Config {
+	return Config{
+		Addr:             ":8080",
+		DB:               "localflow.db",
+		Workers:          8,
+		Poll:             250 * time.Millisecond,
+		ScheduleInterval: 10 * time.Second,
+		Defaults: TaskDefaults{
+			Priority:          5,
+			MaxAttempts:       5,
+			VisibilityTimeout: 60 * time.Second,
+		},
+		Handlers: map[string]HandlerConfig{},
+		Auth:     AuthConfig{SessionTTL: 12 * time.Hour},
+		Shell:    ShellConfig{InheritEnv: []string{"PATH"}, MaxOutput: 1 << 20},
+		Retention: RetentionConfig{
+			Interval:  10 * time.Minute,
+			BatchSize: 500,
+			Misfires:  30 * 24 * time.Hour,
+		},
+	}
+}
+
+// Load builds a Config from the defaults, the optional YAML file at path and
+// the process environment.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("read config: %w", err)
+		}
+		defer f.Close()
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return Config{}, fmt.Errorf("parse config %s: %w", path, err)
+		}
+		if cfg.Handlers == nil {
+			cfg.Handlers = map[string]HandlerConfig{}
+		}
+	}
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides fields from LOCALFLOW_* variables. Per-handler options
+// use LOCALFLOW_HANDLER_<TYPE>_<OPTION>, e.g. LOCALFLOW_HANDLER_SHELL_TIMEOUT.
+func (c *Config) applyEnv(environ []string) error {
+	for _, kv := range environ {
+		key, val, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, EnvPrefix)
+		var err error
+		switch name {
+		case "ADDR":
+			c.Addr = val
+		case "DB":
+			c.DB = val
+		case "WORKERS":
+			c.Workers, err = strconv.Atoi(val)
+		case "POLL":
+			c.Poll, err = time.ParseDuration(val)
+		case "DEBUG":
+			c.Debug, err = strconv.ParseBool(val)
+		case "SCHEDULE_INTERVAL":
+			c.ScheduleInterval, err = time.ParseDuration(val)
+		case "SCHEDULE_MAX_FIRES":
+			c.ScheduleMaxFires, err = strconv.Atoi(val)
+		case "SCHEDULE_DIR":
+			c.ScheduleDir = val
+		case "DEFAULT_PRIORITY":
+			c.Defaults.Priority, err = strconv.Atoi(val)
+		case "DEFAULT_MAX_ATTEMPTS":
+			c.Defaults.MaxAttempts, err = strconv.Atoi(val)
+		case "DEFAULT_VISIBILITY_TIMEOUT":
+			c.Defaults.VisibilityTimeout, err = time.ParseDuration(val)
+		case "AUTH_ENABLED":
+			c.Auth.Enabled, err = strconv.ParseBool(val)
+		case "AUTH_SESSION_TTL":
+			c.Auth.SessionTTL, err = time.ParseDuration(val)
+		case "SHELL_SANDBOX":
+			c.Shell.Sandbox, err = strconv.ParseBool(val)
+		case "SHELL_DIR":
+			c.Shell.Dir = val
+		case "SHELL_USER":
+			c.Shell.User = val
+		case "SECRETS_DIR":
+			c.Secrets.Dir = val
+		case "SECRETS_MASTER_KEY_FILE":
+			c.Secrets.MasterKeyFile = val
+		case "BACKUP_DIR":
+			c.Backup.Dir = val
+		case "BACKUP_INTERVAL":
+			c.Backup.Interval, err = time.ParseDuration(val)
+		case "BACKUP_KEEP":
+			c.Backup.Keep, err = strconv.Atoi(val)
+		case "BACKUP_ALLOW_ANY_PATH":
+			c.Backup.AllowAnyPath, err = strconv.ParseBool(val)
+		case "RETENTION_SUCCEEDED":
+			c.Retention.Succeeded, err = time.ParseDuration(val)
+		case "RETENTION_FAILED":
+			c.Retention.Failed, err = time.ParseDuration(val)
+		case "RETENTION_CANCELED":
+			c.Retention.Canceled, err = time.ParseDuration(val)
+		case "RETENTION_EXPIRED":
+			c.Retention.Expired, err = time.ParseDuration(val)
+		case "RETENTION_INTERVAL":
+			c.Retention.Interval, err = time.ParseDuration(val)
+		case "RETENTION_BATCH_SIZE":
+			c.Retention.BatchSize, err = strconv.Atoi(val)
+		case "RETENTION_ARCHIVE_DIR":
+			c.Retention.ArchiveDir = val
+		case "RETENTION_VACUUM_INTERVAL":
+			c.Retention.VacuumInterval, err = time.ParseDuration(val)
+		case "RETENTION_MISFIRES":
+			c.Retention.Misfires, err = time.ParseDuration(val)
+		default:
+			if strings.HasPrefix(name, "HANDLER_") {
+				err = c.applyHandlerEnv(strings.TrimPrefix(name, "HANDLER_"), val)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+var handlerEnvOptions = []string{"TIMEOUT", "CONCURRENCY", "MAX_ATTEMPTS", "BACKOFF_BASE", "BACKOFF_MAX"}
+
+func (c *Config) applyHandlerEnv(name, val string) error {
+	for _, opt := range handlerEnvOptions {
+		typ, ok := strings.CutSuffix(name, "_"+opt)
+		if !ok || typ == "" {
+			continue
+		}
+		typ = strings.ToLower(typ)
+		h := c.Handlers[typ]
+		var err error
+		switch opt {
+		case "TIMEOUT":
+			h.Timeout, err = time.ParseDuration(val)
+		case "CONCURRENCY":
+			h.Concurrency, err = strconv.Atoi(val)
+		case "MAX_ATTEMPTS":
+			h.Retry.MaxAttempts, err = strconv.Atoi(val)
+		case "BACKOFF_BASE":
+			h.Retry.BackoffBase, err = time.ParseDuration(val)
+		case "BACKOFF_MAX":
+			h.Retry.BackoffMax, err = time.ParseDuration(val)
+		}
+		if err != nil {
+			return err
+		}
+		c.Handlers[typ] = h
+		return nil
+	}
+	return fmt.Errorf("unknown handler option")
+}
+
+// Validate checks the configuration for values the server cannot run with.
+// All problems are reported together.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr must not be empty"))
+	}
+	if c.DB == "" {
+		errs = append(errs, errors.New("db must not be empty"))
+	}
+	if c.Workers < 1 {
+		errs = append(errs, errors.New("workers must be at least 1"))
+	}
+	if c.Poll <= 0 {
+		errs = append(errs, errors.New("poll must be positive"))
+	}
+	if c.ScheduleInterval <= 0 {
+		errs = append(errs, errors.New("schedule_interval must be positive"))
+	}
+	if c.ScheduleMaxFires < 0 {
+		errs = append(errs, errors.New("schedule_max_fires must not be negative"))
+	}
+	if c.ScheduleDir != "" {
+		if fi, err := os.Stat(c.ScheduleDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("schedule_dir %q is not a directory", c.ScheduleDir))
+		}
+	}
+	if c.Defaults.Priority < 1 {
+		errs = append(errs, errors.New("defaults.priority must be at least 1"))
+	}
+	if c.Defaults.MaxAttempts < 1 {
+		errs = append(errs, errors.New("defaults.max_attempts must be at least 1"))
+	}
+	if c.Defaults.VisibilityTimeout < time.Second {
+		errs = append(errs, errors.New("defaults.visibility_timeout must be at least 1s"))
+	}
+	if c.Auth.SessionTTL <= 0 {
+		errs = append(errs, errors.New("auth.session_ttl must be positive"))
+	}
+
+	errs = append(errs, c.Shell.validate()...)
+	if c.Secrets.Dir != "" {
+		if fi, err := os.Stat(c.Secrets.Dir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("secrets.dir %q is not a directory", c.Secrets.Dir))
+		}
+	}
+	if c.Backup.Dir != "" {
+		if fi, err := os.Stat(c.Backup.Dir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("backup.dir %q is not a directory", c.Backup.Dir))
+		}
+	}
+	if c.Backup.Interval < 0 {
+		errs = append(errs, errors.New("backup.interval must not be negative"))
+	}
+	if c.Backup.Interval > 0 && c.Backup.Dir == "" {
+		errs = append(errs, errors.New("backup.interval requires backup.dir"))
+	}
+	if c.Backup.Keep < 0 {
+		errs = append(errs, errors.New("backup.keep must not be negative"))
+	}
+	errs = append(errs, c.Retention.validate()...)
+
+	names := make([]string, 0, len(c.Handlers))
+	for name := range c.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := c.Handlers[name]
+		if h.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("handlers.%s.timeout must not be negative", name))
+		}
+		if h.Concurrency < 0 || h.Concurrency > c.Workers {
+			errs = append(errs, fmt.Errorf("handlers.%s.concurrency must be between 0 and workers (%d)", name, c.Workers))
+		}
+		if h.Retry.MaxAttempts < 0 {
+			errs = append(errs, fmt.Errorf("handlers.%s.retry.max_attempts must not be negative", name))
+		}
+		if h.Retry.BackoffBase < 0 || h.Retry.BackoffMax < 0 {
+			errs = append(errs, fmt.Errorf("handlers.%s.retry backoff must not be negative", name))
+		}
+		if h.Retry.BackoffBase > 0 && h.Retry.BackoffMax > 0 && h.Retry.BackoffMax < h.Retry.BackoffBase {
+			errs = append(errs, fmt.Errorf("handlers.%s.retry.backoff_max must be >= backoff_base", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r RetentionConfig) validate() []error {
+	var errs []error
+	if r.Succeeded < 0 || r.Failed < 0 || r.Canceled < 0 || r.Expired < 0 {
+		errs = append(errs, errors.New("retention periods must not be negative"))
+	}
+	types := make([]string, 0, len(r.Types))
+	for name := range r.Types {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	for _, name := range types {
+		o := r.Types[name]
+		for _, d := range []*time.Duration{o.Succeeded, o.Failed, o.Canceled, o.Expired} {
+			if d != nil && *d < 0 {
+				errs = append(errs, fmt.Errorf("retention.types.%s periods must not be negative", name))
+				break
+			}
+		}
+	}
+	if r.Interval <= 0 {
+		errs = append(errs, errors.New("retention.interval must be positive"))
+	}
+	if r.BatchSize < 1 || r.BatchSize > 10000 {
+		errs = append(errs, errors.New("retention.batch_size must be between 1 and 10000"))
+	}
+	if r.ArchiveDir != "" {
+		if fi, err := os.Stat(r.ArchiveDir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("retention.archive_dir %q is not a directory", r.ArchiveDir))
+		}
+	}
+	if r.VacuumInterval < 0 {
+		errs = append(errs, errors.New("retention.vacuum_interval must not be negative"))
+	}
+	return errs
+}
+
+func (s ShellConfig) validate() []error {
+	if !s.Sandbox {
+		return nil
+	}
+	var errs []error
+	for i, rule := range s.Allow {
+		if rule.Command == "" {
+			errs = append(errs, fmt.Errorf("shell.allow[%d].command must not be empty", i))
+		} else if strings.Contains(rule.Command, "/") && !filepath.IsAbs(rule.Command) {
+			errs = append(errs, fmt.Errorf("shell.allow[%d].command must be a bare name or an absolute path", i))
+		}
+		for _, pattern := range rule.Args {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("shell.allow[%d].args: %w", i, err))
+			}
+		}
+	}
+	if s.Dir != "" {
+		if fi, err := os.Stat(s.Dir); err != nil || !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("shell.dir %q is not a directory", s.Dir))
+		}
+	}
+	if s.MaxOutput < 0 {
+		errs = append(errs, errors.New("shell.max_output must not be negative"))
+	}
+	if s.Limits.CPUTime < 0 || s.Limits.Memory < 0 {
+		errs = append(errs, errors.New("shell.limits must not be negative"))
+	}
+	if s.Cgroup.CPU < 0 || s.Cgroup.Memory < 0 {
+		errs = append(errs, errors.New("shell.cgroup limits must not be negative"))
+	}
+	return errs
+}
+
+// YAML renders the effective configuration.
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
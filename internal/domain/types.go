@@ -13,14 +13,62 @@ type Task struct {
 	NextRunAt         time.Time
 	VisibilityTimeout int // seconds
 	IdempotencyKey    *string
+	Progress          *float64 // percent complete reported by the handler, nil if never reported
+	StatusMessage     string
+	ScheduleID        string // schedule that enqueued the task, empty for ad-hoc tasks
 	CreatedAt         time.Time
 	UpdatedAt         time.Time
+	ExpiresAt         *time.Time // queued tasks past it expire instead of running, nil never
+	UniqueKey         string     // with Type, identifies tasks a Unique submission conflicts with
+}
+
+// Unique modes decide which tasks with the same type and unique key a new
+// task conflicts with.
+const (
+	UniqueQueued = "queued" // a queued task, so one may run while another waits
+	UniqueActive = "active" // a queued or running task
+	UniqueWindow = "window" // any task created within the window
+)
+
+// Unique conflict actions decide what a submission does when it conflicts.
+const (
+	UniqueReturnExisting = "existing" // enqueue nothing, return the existing task
+	UniqueReplace        = "replace"  // replace the queued task's payload
+	UniqueReject         = "reject"   // fail the submission
+)
+
+// Unique constrains a submission to one task per type and Key.
+type Unique struct {
+	Key        string
+	Mode       string        // one of the Unique* modes
+	Window     time.Duration // for UniqueWindow
+	OnConflict string        // one of the Unique* conflict actions; empty means UniqueReturnExisting
+}
+
+// TaskAttempt is one finished run of a task's handler.
+type TaskAttempt struct {
+	ID         int64
+	TaskID     string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+	Success    bool
+	Error      string
+}
+
+type TaskLog struct {
+	ID        int64
+	TaskID    string
+	Attempt   int
+	Stream    string
+	Line      string
+	CreatedAt time.Time
 }
 
 type Schedule struct {
 	ID          string
 	Name        string
 	CronExpr    string
+	Timezone    string // IANA zone the expression is evaluated in, empty for server local
 	TaskType    string
 	Payload     []byte
 	Priority    int
@@ -28,6 +76,87 @@ type Schedule struct {
 	Enabled     bool
 	LastRun     *time.Time
 	NextRun     time.Time
+	RunCount    int // times the schedule has fired
+	// MisfirePolicy is one of the Misfire* constants; empty means
+	// MisfireRunOnce.
+	MisfirePolicy string
+	MisfireGrace  int // seconds a fire may be late and still count as on time, 0 for the default
+	MaxCatchup    int // missed fires enqueued under MisfireRunAll, 0 for the default
+	// ConcurrencyPolicy is one of the Concurrency* constants; empty means
+	// ConcurrencyAllow.
+	ConcurrencyPolicy string
+	// StartAt and EndAt limit fires to a window; nil leaves it open.
+	StartAt *time.Time
+	EndAt   *time.Time
+	// ExcludeCalendars names calendars whose dates the schedule skips.
+	ExcludeCalendars []string
+	// Jitter is a window in seconds; every fire is delayed by the same
+	// offset within it, derived from the schedule ID.
+	Jitter int
+	// Source is the definition file, relative to the schedule directory,
+	// of a schedule managed from files; empty for schedules created
+	// through the API or dashboard.
+	Source    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Misfire policies decide what happens to schedule fires that were missed,
+// e.g. because the server was down when they were due.
+const (
+	MisfireRunOnce = "run_once" // enqueue one task for the latest missed fire
+	MisfireSkip    = "skip"     // drop missed fires
+	MisfireRunAll  = "run_all"  // enqueue a task for every missed fire, up to MaxCatchup
+)
+
+// Concurrency policies decide what a schedule does when it fires while a
+// task from an earlier fire is still queued or running.
+const (
+	ConcurrencyAllow   = "allow"   // enqueue regardless
+	ConcurrencyForbid  = "forbid"  // skip the fire
+	ConcurrencyReplace = "replace" // cancel the earlier task, then enqueue
+)
+
+// Calendar is a named set of dates, e.g. company holidays, that schedules
+// can exclude.
+type Calendar struct {
+	Name        string
+	Description string
+	Dates       []string // YYYY-MM-DD, sorted
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
+
+// ScheduleRun is a task a schedule enqueued, with the error of its latest
+// attempt.
+type ScheduleRun struct {
+	Task  Task
+	Error string
+}
+
+// ScheduleMisfire records a schedule fire that did not enqueue a task.
+type ScheduleMisfire struct {
+	ID         int64
+	ScheduleID string
+	FireTime   time.Time
+	Reason     string
+	CreatedAt  time.Time
+}
+
+type APIKey struct {
+	ID        string
+	Name      string
+	KeyHash   string
+	Scopes    []string
+	CreatedAt time.Time
+	RevokedAt *time.Time
+}
+
+// Secret is an encrypted named value. Value holds the ciphertext and is
+// never exposed through the API.
+type Secret struct {
+	Name      string
+	Value     []byte
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -0,0 +1,198 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expect describes what a successful response looks like. Without it any
+// status below 400 counts as success.
+type Expect struct {
+	// Status lists accepted status codes.
+	Status []int `json:"status"`
+	// Headers must be present in the response. A non-empty value is a
+	// regular expression the header value must match.
+	Headers map[string]string `json:"headers"`
+	// Body is a regular expression the response body must match.
+	Body string `json:"body"`
+	// JSON assertions run against the body decoded as JSON.
+	JSON []JSONAssertion `json:"json"`
+	// Permanent fails the task without retrying when an expectation is
+	// not met. By default mismatches are retried like any other error.
+	Permanent bool `json:"permanent"`
+}
+
+// JSONAssertion checks the value at Path, a JSONPath subset such as
+// "$.data.items[0].status". With neither Equals nor Matches set the value
+// only has to exist.
+type JSONAssertion struct {
+	Path    string          `json:"path"`
+	Equals  json.RawMessage `json:"equals,omitempty"`
+	Matches string          `json:"matches,omitempty"`
+}
+
+// check returns a description of every expectation resp does not meet.
+func (e *Expect) check(resp *http.Response, body []byte) ([]string, error) {
+	var failures []string
+
+	if len(e.Status) > 0 {
+		ok := false
+		for _, code := range e.Status {
+			if resp.StatusCode == code {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			failures = append(failures, fmt.Sprintf("status %d not in %v", resp.StatusCode, e.Status))
+		}
+	} else if resp.StatusCode >= 400 {
+		failures = append(failures, fmt.Sprintf("status %d", resp.StatusCode))
+	}
+
+	for name, pattern := range e.Headers {
+		values, ok := resp.Header[http.CanonicalHeaderKey(name)]
+		if !ok {
+			failures = append(failures, fmt.Sprintf("header %s missing", name))
+			continue
+		}
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("header %s pattern: %w", name, err)
+		}
+		if !re.MatchString(strings.Join(values, ", ")) {
+			failures = append(failures, fmt.Sprintf("header %s=%q does not match %q", name, strings.Join(values, ", "), pattern))
+		}
+	}
+
+	if e.Body != "" {
+		re, err := regexp.Compile(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("body pattern: %w", err)
+		}
+		if !re.Match(body) {
+			failures = append(failures, fmt.Sprintf("body does not match %q", e.Body))
+		}
+	}
+
+	if len(e.JSON) > 0 {
+		var doc any
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return append(failures, fmt.Sprintf("body is not JSON: %v", err)), nil
+		}
+		for _, a := range e.JSON {
+			msg, err := a.check(doc)
+			if err != nil {
+				return nil, err
+			}
+			if msg != "" {
+				failures = append(failures, msg)
+			}
+		}
+	}
+	return failures, nil
+}
+
+func (a JSONAssertion) check(doc any) (string, error) {
+	v, ok, err := lookupPath(doc, a.Path)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return fmt.Sprintf("%s not found", a.Path), nil
+	}
+	if len(a.Equals) > 0 {
+		var want any
+		dec := json.NewDecoder(bytes.NewReader(a.Equals))
+		dec.UseNumber()
+		if err := dec.Decode(&want); err != nil {
+			return "", fmt.Errorf("%s equals: %w", a.Path, err)
+		}
+		if !jsonEqual(v, want) {
+			got, _ := json.Marshal(v)
+			return fmt.Sprintf("%s = %s, want %s", a.Path, got, a.Equals), nil
+		}
+	}
+	if a.Matches != "" {
+		re, err := regexp.Compile(a.Matches)
+		if err != nil {
+			return "", fmt.Errorf("%s pattern: %w", a.Path, err)
+		}
+		s, ok := v.(string)
+		if !ok {
+			b, _ := json.Marshal(v)
+			s = string(b)
+		}
+		if !re.MatchString(s) {
+			return fmt.Sprintf("%s = %q does not match %q", a.Path, s, a.Matches), nil
+		}
+	}
+	return "", nil
+}
+
+// lookupPath resolves a dotted JSONPath such as "$.a.b[0]" in a decoded
+// document. Bracketed keys, wildcards and filters are not supported.
+func lookupPath(doc any, path string) (any, bool, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	cur := doc
+	for p != "" {
+		var key string
+		if p[0] == '[' {
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, false, fmt.Errorf("invalid path %q", path)
+			}
+			idx, err := strconv.Atoi(p[1:end])
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid index in path %q", path)
+			}
+			arr, ok := cur.([]any)
+			if !ok || idx < 0 || idx >= len(arr) {
+				return nil, false, nil
+			}
+			cur = arr[idx]
+			p = strings.TrimPrefix(p[end+1:], ".")
+			continue
+		}
+		end := strings.IndexAny(p, ".[")
+		if end < 0 {
+			key, p = p, ""
+		} else {
+			key, p = p[:end], strings.TrimPrefix(p[end:], ".")
+		}
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false, nil
+		}
+		if cur, ok = obj[key]; !ok {
+			return nil, false, nil
+		}
+	}
+	return cur, true, nil
+}
+
+// jsonEqual compares decoded JSON values, treating numbers by value.
+func jsonEqual(a, b any) bool {
+	if an, ok := a.(json.Number); ok {
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, err1 := an.Float64()
+		bf, err2 := bn.Float64()
+		return err1 == nil && err2 == nil && af == bf
+	}
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"localflow/internal/worker"
+)
+
+func response(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	for k, v := range headers {
+		resp.Header.Add(k, v)
+	}
+	return resp
+}
+
+func TestExpectCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		expect   Expect
+		status   int
+		headers  map[string]string
+		body     string
+		failures int
+		invalid  bool // check returns an error
+	}{
+		{name: "default accepts 2xx", status: 204},
+		{name: "default accepts 3xx", status: 304},
+		{name: "default rejects 4xx", status: 404, failures: 1},
+		{name: "default rejects 5xx", status: 503, failures: 1},
+		{name: "listed status", expect: Expect{Status: []int{200, 202}}, status: 202},
+		{name: "unlisted status", expect: Expect{Status: []int{200, 202}}, status: 201, failures: 1},
+		{name: "listed error status", expect: Expect{Status: []int{404}}, status: 404},
+
+		{name: "header present", expect: Expect{Headers: map[string]string{"x-request-id": ""}},
+			status: 200, headers: map[string]string{"X-Request-Id": "abc"}},
+		{name: "header missing", expect: Expect{Headers: map[string]string{"X-Request-Id": ""}},
+			status: 200, failures: 1},
+		{name: "header matches", expect: Expect{Headers: map[string]string{"Content-Type": `^application/json`}},
+			status: 200, headers: map[string]string{"Content-Type": "application/json; charset=utf-8"}},
+		{name: "header mismatch", expect: Expect{Headers: map[string]string{"Content-Type": `^application/json`}},
+			status: 200, headers: map[string]string{"Content-Type": "text/html"}, failures: 1},
+		{name: "bad header pattern", expect: Expect{Headers: map[string]string{"Content-Type": `(`}},
+			status: 200, headers: map[string]string{"Content-Type": "text/html"}, invalid: true},
+
+		{name: "body matches", expect: Expect{Body: `"ok":\s*true`}, status: 200, body: `{"ok": true}`},
+		{name: "body mismatch", expect: Expect{Body: `"ok":\s*true`}, status: 200, body: `{"ok": false, "error": "db down"}`, failures: 1},
+		{name: "bad body pattern", expect: Expect{Body: `[`}, status: 200, invalid: true},
+
+		{name: "json on non-JSON body", expect: Expect{JSON: []JSONAssertion{{Path: "$.ok"}}},
+			status: 200, body: `<html>oops</html>`, failures: 1},
+		{name: "json on empty body", expect: Expect{JSON: []JSONAssertion{{Path: "$.ok"}}},
+			status: 200, failures: 1},
+		{name: "json equals", expect: Expect{JSON: []JSONAssertion{{Path: "$.status", Equals: json.RawMessage(`"up"`)}}},
+			status: 200, body: `{"status":"up"}`},
+		{name: "json not equal", expect: Expect{JSON: []JSONAssertion{{Path: "$.status", Equals: json.RawMessage(`"up"`)}}},
+			status: 200, body: `{"status":"down"}`, failures: 1},
+		{name: "json missing", expect: Expect{JSON: []JSONAssertion{{Path: "$.status"}}},
+			status: 200, body: `{}`, failures: 1},
+		{name: "json matches number", expect: Expect{JSON: []JSONAssertion{{Path: "$.count", Matches: `^[1-9]`}}},
+			status: 200, body: `{"count":12}`},
+		{name: "json bad equals", expect: Expect{JSON: []JSONAssertion{{Path: "$.a", Equals: json.RawMessage(`{`)}}},
+			status: 200, body: `{"a":1}`, invalid: true},
+		{name: "json malformed path", expect: Expect{JSON: []JSONAssertion{{Path: "$.items[0"}}},
+			status: 200, body: `{"items":[1]}`, invalid: true},
+
+		{name: "every failure reported", expect: Expect{Status: []int{200}, Body: `ok`, JSON: []JSONAssertion{{Path: "$.a"}, {Path: "$.b"}}},
+			status: 500, body: `{}`, failures: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures, err := tt.expect.check(response(tt.status, tt.headers), []byte(tt.body))
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("check succeeded with failures %q", failures)
+				}
+				return
+			}
+			if err != nil || len(failures) != tt.failures {
+				t.Errorf("check = %q, %v; want %d failures", failures, err, tt.failures)
+			}
+		})
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	doc := map[string]any{
+		"data": map[string]any{
+			"items": []any{
+				map[string]any{"status": "ok"},
+				map[string]any{"status": "failed", "tags": []any{"a", "b"}},
+			},
+		},
+		"n": json.Number("3"),
+	}
+	tests := []struct {
+		path    string
+		want    any // nil when not found
+		invalid bool
+	}{
+		{path: "$.n", want: json.Number("3")},
+		{path: "n", want: json.Number("3")},
+		{path: "$.data.items[0].status", want: "ok"},
+		{path: "$.data.items[1].tags[1]", want: "b"},
+		{path: "data.items[1].status", want: "failed"},
+		{path: "$.data.items[2].status"},
+		{path: "$.data.items[-1]"},
+		{path: "$.data.missing"},
+		{path: "$.n.deeper"},
+		{path: "$.n[0]"},
+		{path: "$.data.items[0", invalid: true},
+		{path: "$.data.items[x]", invalid: true},
+		{path: "$.data.items[]", invalid: true},
+	}
+	for _, tt := range tests {
+		v, ok, err := lookupPath(doc, tt.path)
+		switch {
+		case tt.invalid:
+			if err == nil {
+				t.Errorf("lookupPath(%q) = %v, %v; want an error", tt.path, v, ok)
+			}
+		case err != nil:
+			t.Errorf("lookupPath(%q): %v", tt.path, err)
+		case tt.want == nil && ok:
+			t.Errorf("lookupPath(%q) = %v, want not found", tt.path, v)
+		case tt.want != nil && (!ok || v != tt.want):
+			t.Errorf("lookupPath(%q) = %v, %v; want %v", tt.path, v, ok, tt.want)
+		}
+	}
+	if v, ok, _ := lookupPath(doc, "$"); !ok || v == nil {
+		t.Errorf("lookupPath($) = %v, %v; want the document", v, ok)
+	}
+}
+
+func TestJSONEqual(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{`1`, `1.0`, true},
+		{`1e2`, `100`, true},
+		{`0.1`, `0.10`, true},
+		{`1`, `2`, false},
+		{`1`, `"1"`, false},
+		{`"a"`, `"a"`, true},
+		{`true`, `true`, true},
+		{`null`, `null`, true},
+		{`null`, `0`, false},
+		{`{"a":1,"b":[1,2]}`, `{"b":[1,2],"a":1}`, true},
+		{`[1,2]`, `[2,1]`, false},
+	}
+	decode := func(s string) any {
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for _, tt := range tests {
+		if got := jsonEqual(decode(tt.a), decode(tt.b)); got != tt.want {
+			t.Errorf("jsonEqual(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestExpectPermanent(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":false}`))
+	}))
+	defer api.Close()
+
+	tests := []struct {
+		name      string
+		expect    *Expect
+		ok        bool
+		permanent bool
+	}{
+		{name: "no expectation", ok: true},
+		{name: "met", expect: &Expect{JSON: []JSONAssertion{{Path: "$.ok", Equals: json.RawMessage(`false`)}}}, ok: true},
+		{name: "retried by default", expect: &Expect{Body: `"ok":true`}},
+		{name: "permanent", expect: &Expect{Body: `"ok":true`, Permanent: true}, permanent: true},
+		{name: "invalid expectation", expect: &Expect{Body: `(`}, permanent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, _ := json.Marshal(Request{URL: api.URL, Method: "GET", Expect: tt.expect})
+			err := HTTP{}.Handle(context.Background(), payload)
+			if (err == nil) != tt.ok {
+				t.Fatalf("Handle = %v, want ok %v", err, tt.ok)
+			}
+			if got := errors.Is(err, worker.ErrPermanent); got != tt.permanent {
+				t.Errorf("permanent = %v, want %v (%v)", got, tt.permanent, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"localflow/internal/secrets"
+)
+
+// Auth adds credentials to a request. Credentials are never part of the
+// payload: Secret names a secret resolved when the task runs.
+type Auth struct {
+	// Type is basic, bearer, hmac or oauth2.
+	Type string `json:"type"`
+	// Username is the basic auth user name.
+	Username string `json:"username,omitempty"`
+	// Secret names the password (basic), token (bearer), signing key
+	// (hmac) or client secret (oauth2).
+	Secret string `json:"secret"`
+
+	// HMAC signing: the body is signed and sent as "<algorithm>=<hex>" in
+	// Header. With TimestampHeader set, "<unix seconds>.<body>" is signed
+	// and the timestamp is sent in that header.
+	Header          string `json:"header,omitempty"`
+	Algorithm       string `json:"algorithm,omitempty"`
+	TimestampHeader string `json:"timestamp_header,omitempty"`
+
+	// OAuth2 client credentials. Tokens are cached until shortly before
+	// they expire.
+	TokenURL string            `json:"token_url,omitempty"`
+	ClientID string            `json:"client_id,omitempty"`
+	Scopes   []string          `json:"scopes,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+func (a *Auth) validate() error {
+	if a.Secret == "" {
+		return fmt.Errorf("auth.secret is required")
+	}
+	switch a.Type {
+	case "basic":
+		if a.Username == "" {
+			return fmt.Errorf("auth.username is required for basic auth")
+		}
+	case "bearer":
+	case "hmac":
+		if _, err := hmacHash(a.Algorithm); err != nil {
+			return err
+		}
+	case "oauth2":
+		if a.TokenURL == "" || a.ClientID == "" {
+			return fmt.Errorf("auth.token_url and auth.client_id are required for oauth2")
+		}
+	default:
+		return fmt.Errorf("unknown auth type %q", a.Type)
+	}
+	return nil
+}
+
+// apply resolves the secret and sets the credentials on req. It returns
+// the secret and the credentials derived from it, also when it fails, so
+// the caller can redact them from logs and errors.
+func (a *Auth) apply(ctx context.Context, resolver secrets.Resolver, req *http.Request, body []byte) ([]string, error) {
+	if resolver == nil {
+		return nil, fmt.Errorf("auth.secret %q: no secret store configured", a.Secret)
+	}
+	secret, err := resolver.Resolve(ctx, a.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("auth.secret: %w", err)
+	}
+	used := []string{secret}
+	if escaped := url.QueryEscape(secret); escaped != secret {
+		// The form the oauth2 token request sends.
+		used = append(used, escaped)
+	}
+	switch a.Type {
+	case "basic":
+		req.SetBasicAuth(a.Username, secret)
+		used = append(used, strings.TrimPrefix(req.Header.Get("Authorization"), "Basic "))
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+secret)
+	case "hmac":
+		newHash, _ := hmacHash(a.Algorithm)
+		mac := hmac.New(newHash, []byte(secret))
+		if a.TimestampHeader != "" {
+			ts := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(a.TimestampHeader, ts)
+			mac.Write([]byte(ts + "."))
+		}
+		mac.Write(body)
+		header := a.Header
+		if header == "" {
+			header = "X-Signature"
+		}
+		alg := a.Algorithm
+		if alg == "" {
+			alg = "sha256"
+		}
+		req.Header.Set(header, alg+"="+hex.EncodeToString(mac.Sum(nil)))
+	case "oauth2":
+		// The token request's basic credentials, see fetchToken.
+		used = append(used, base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(a.ClientID)+":"+url.QueryEscape(secret))))
+		token, err := tokens.get(ctx, a, secret)
+		if err != nil {
+			return used, err
+		}
+		used = append(used, token)
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return used, nil
+}
+
+func hmacHash(alg string) (func() hash.Hash, error) {
+	switch alg {
+	case "", "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	case "sha1":
+		return sha1.New, nil
+	}
+	return nil, fmt.Errorf("unknown auth.algorithm %q", alg)
+}
+
+// tokenCache holds OAuth2 access tokens shared by all http tasks.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	value   string
+	expires time.Time
+}
+
+var tokens = &tokenCache{tokens: map[string]cachedToken{}}
+
+// tokenExpiryMargin renews tokens this long before they expire.
+const tokenExpiryMargin = 30 * time.Second
+
+func tokenKey(a *Auth, secret string) string {
+	scopes := append([]string(nil), a.Scopes...)
+	sort.Strings(scopes)
+	sum := sha256.Sum256([]byte(secret))
+	return strings.Join([]string{a.TokenURL, a.ClientID, strings.Join(scopes, " "), hex.EncodeToString(sum[:])}, "\x00")
+}
+
+func (c *tokenCache) get(ctx context.Context, a *Auth, secret string) (string, error) {
+	key := tokenKey(a, secret)
+	c.mu.Lock()
+	t, ok := c.tokens[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(t.expires) {
+		return t.value, nil
+	}
+	t, err := fetchToken(ctx, a, secret)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.tokens[key] = t
+	c.mu.Unlock()
+	return t.value, nil
+}
+
+// invalidate drops a cached token, e.g. after the API rejected it.
+func (c *tokenCache) invalidate(a *Auth, secret string) {
+	c.mu.Lock()
+	delete(c.tokens, tokenKey(a, secret))
+	c.mu.Unlock()
+}
+
+// rejected is called when the server answered 401 so that the next attempt
+// fetches a fresh OAuth2 token.
+func (a *Auth) rejected(ctx context.Context, resolver secrets.Resolver) {
+	if a.Type != "oauth2" || resolver == nil {
+		return
+	}
+	if secret, err := resolver.Resolve(ctx, a.Secret); err == nil {
+		tokens.invalidate(a, secret)
+	}
+}
+
+func fetchToken(ctx context.Context, a *Auth, secret string) (cachedToken, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	for k, v := range a.Params {
+		form.Set(k, v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("oauth2 token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(secret))
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return cachedToken{}, fmt.Errorf("oauth2 token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return cachedToken{}, fmt.Errorf("oauth2 token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tr); err != nil || tr.AccessToken == "" {
+		return cachedToken{}, fmt.Errorf("oauth2 token endpoint returned no access_token")
+	}
+	expires := time.Now().Add(time.Hour)
+	if tr.ExpiresIn > 0 {
+		expires = time.Now().Add(time.Duration(tr.ExpiresIn)*time.Second - tokenExpiryMargin)
+	}
+	return cachedToken{value: tr.AccessToken, expires: expires}, nil
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type staticSecrets map[string]string
+
+func (s staticSecrets) Resolve(_ context.Context, name string) (string, error) {
+	v, ok := s[name]
+	if !ok {
+		return "", fmt.Errorf("secret %q not found", name)
+	}
+	return v, nil
+}
+
+// echo answers 400 with the request's Authorization header and body, like
+// an API that reflects its input in error messages.
+func echo(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	http.Error(w, r.Header.Get("Authorization")+" "+string(body), http.StatusBadRequest)
+}
+
+func TestAuthCredentialsRedacted(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(echo))
+	defer api.Close()
+	tokenURL := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "fail") {
+			echo(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "tok-abc123", "expires_in": 3600})
+	}))
+	defer tokenURL.Close()
+
+	h := HTTP{Secrets: staticSecrets{"pw": "s3cr3t+pw"}}
+	tests := []struct {
+		name   string
+		auth   Auth
+		leaked []string
+	}{
+		{"basic", Auth{Type: "basic", Username: "bob", Secret: "pw"}, []string{"s3cr3t+pw", "Ym9iOnMzY3IzdCtwdw=="}},
+		{"bearer", Auth{Type: "bearer", Secret: "pw"}, []string{"s3cr3t+pw"}},
+		{"oauth2", Auth{Type: "oauth2", Secret: "pw", ClientID: "app", TokenURL: tokenURL.URL + "/token"}, []string{"tok-abc123", "s3cr3t+pw"}},
+		{"oauth2 token failure", Auth{Type: "oauth2", Secret: "pw", ClientID: "app", TokenURL: tokenURL.URL + "/fail"}, []string{"s3cr3t+pw", "s3cr3t%2Bpw", "YXBwOnMzY3IzdCUyQnB3"}},
+	}
+	for _, tt := range tests {
+		payload, _ := json.Marshal(Request{URL: api.URL, Method: "POST", Auth: &tt.auth})
+		err := h.Handle(context.Background(), payload)
+		if err == nil {
+			t.Fatalf("%s: request succeeded", tt.name)
+		}
+		for _, v := range tt.leaked {
+			if strings.Contains(err.Error(), v) {
+				t.Errorf("%s: error leaks %q: %v", tt.name, v, err)
+			}
+		}
+		if !strings.Contains(err.Error(), "***") {
+			t.Errorf("%s: nothing redacted: %v", tt.name, err)
+		}
+	}
+}
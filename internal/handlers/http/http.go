@@ -7,10 +7,19 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"localflow/internal/secrets"
+	"localflow/internal/tasklog"
+	"localflow/internal/worker"
 )
 
-type HTTP struct{}
+type HTTP struct {
+	// Secrets resolves the secrets named by Request.Auth and
+	// {{secret "name"}} references in headers and the body.
+	Secrets secrets.Resolver
+}
 
 type Request struct {
 	URL     string            `json:"url"`
@@ -18,6 +27,8 @@ type Request struct {
 	Headers map[string]string `json:"headers"`
 	Body    []byte            `json:"body"`
 	Timeout int               `json:"timeout"` // seconds
+	Auth    *Auth             `json:"auth"`
+	Expect  *Expect           `json:"expect"`
 }
 
 type Response struct {
@@ -41,6 +52,40 @@ func (h HTTP) Handle(ctx context.Context, payload json.RawMessage) error {
 		req.Method = "GET"
 	}
 
+	exp := secrets.NewExpander(ctx, h.Secrets)
+	if err := req.expandSecrets(exp); err != nil {
+		return err
+	}
+	tasklog.FromContext(ctx).Redact(exp.Values()...)
+	return exp.RedactError(h.do(ctx, req, exp))
+}
+
+// expandSecrets resolves secret references in header values and the body.
+func (req *Request) expandSecrets(exp *secrets.Expander) error {
+	headers := make(map[string]string, len(req.Headers))
+	for k, v := range req.Headers {
+		v, err := exp.Expand(v)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", k, err)
+		}
+		headers[k] = v
+	}
+	req.Headers = headers
+	body, err := exp.Expand(string(req.Body))
+	if err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	req.Body = []byte(body)
+	return nil
+}
+
+func (h HTTP) do(ctx context.Context, req Request, exp *secrets.Expander) error {
+	if req.Auth != nil {
+		if err := req.Auth.validate(); err != nil {
+			return worker.Permanent(err)
+		}
+	}
+
 	if req.Timeout <= 0 {
 		req.Timeout = 30 // default 30 seconds
 	}
@@ -65,13 +110,28 @@ func (h HTTP) Handle(ctx context.Context, payload json.RawMessage) error {
 	for key, value := range req.Headers {
 		httpReq.Header.Set(key, value)
 	}
+	logger := tasklog.FromContext(ctx)
+	if req.Auth != nil {
+		used, err := req.Auth.apply(ctx, h.Secrets, httpReq, req.Body)
+		exp.Add(used...)
+		logger.Redact(used...)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Make request
+	logger.Printf("%s %s", req.Method, req.URL)
+	start := time.Now()
 	resp, err := client.Do(httpReq)
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
+	logger.Printf("%s in %s", resp.Status, time.Since(start).Round(time.Millisecond))
+	if resp.StatusCode == http.StatusUnauthorized && req.Auth != nil {
+		req.Auth.rejected(ctx, h.Secrets)
+	}
 
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
@@ -79,10 +139,27 @@ func (h HTTP) Handle(ctx context.Context, payload json.RawMessage) error {
 		return fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	// Check for HTTP errors (4xx, 5xx)
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HTTP %d error: %s", resp.StatusCode, string(respBody))
+	if req.Expect == nil {
+		// Check for HTTP errors (4xx, 5xx)
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("HTTP %d error: %s", resp.StatusCode, string(respBody))
+		}
+		return nil
 	}
 
-	return nil
+	failures, err := req.Expect.check(resp, respBody)
+	if err != nil {
+		return worker.Permanent(fmt.Errorf("invalid expectation: %w", err))
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	for _, f := range failures {
+		logger.Printf("assertion failed: %s", f)
+	}
+	err = fmt.Errorf("HTTP response did not meet expectations: %s", strings.Join(failures, "; "))
+	if req.Expect.Permanent {
+		return worker.Permanent(err)
+	}
+	return err
 }
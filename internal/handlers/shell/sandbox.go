@@ -0,0 +1,246 @@
+package shell
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sandbox restricts what shell tasks may run and with which privileges.
+// A nil Sandbox leaves commands unrestricted.
+type Sandbox struct {
+	// Allow lists the permitted executables. A command not matched by any
+	// rule is rejected; an empty list rejects everything.
+	Allow []Rule
+	// Dir is the working directory for commands. Empty keeps the server's.
+	Dir string
+	// InheritEnv names server environment variables passed through to
+	// commands; everything else is scrubbed. Env is injected on top.
+	InheritEnv []string
+	Env        map[string]string
+	// AllowEnv lists the variables a payload may set, as names or path.Match
+	// patterns such as APP_*. An empty list lets payloads set none. Inherited
+	// variables and Env take precedence over payload values.
+	AllowEnv []string
+	// User runs commands as this user name or uid (requires root).
+	User string
+	// MaxOutput caps captured output bytes; the rest is discarded.
+	MaxOutput int
+	Limits    Limits
+	Cgroup    Cgroup
+}
+
+// Rule permits an executable, given as a name resolved via PATH or an
+// absolute path that may contain glob patterns. If Args is non-empty every
+// argument must match at least one of the patterns, compiled with
+// CompileArg.
+type Rule struct {
+	Command string
+	Args    []*regexp.Regexp
+}
+
+// CompileArg compiles an argument pattern anchored at both ends, so it
+// must match a whole argument.
+func CompileArg(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// Limits are per-process rlimits, set before the command is executed.
+// Zero values are left unlimited.
+type Limits struct {
+	CPUTime   time.Duration
+	Memory    int64 // address space, bytes
+	OpenFiles uint64
+	Processes uint64
+}
+
+// Cgroup places each command in its own cgroup v2 child of Parent with the
+// given CPU (in CPUs, e.g. 0.5) and memory (bytes) limits. It is skipped
+// when cgroup v2 is not available.
+type Cgroup struct {
+	Enabled bool
+	Parent  string
+	CPU     float64
+	Memory  int64
+}
+
+// DefaultMaxOutput bounds captured output when no sandbox cap is set.
+const DefaultMaxOutput = 1 << 20
+
+// resolve checks the command against the allowlist and returns the
+// executable path to run.
+func (s *Sandbox) resolve(command string, args []string) (string, error) {
+	exe, err := s.lookPath(command)
+	if err != nil {
+		return "", err
+	}
+	for _, rule := range s.Allow {
+		if !rule.matches(command, exe) {
+			continue
+		}
+		for _, arg := range args {
+			if !rule.argAllowed(arg) {
+				return "", fmt.Errorf("argument %q not permitted for %s", arg, command)
+			}
+		}
+		return exe, nil
+	}
+	return "", fmt.Errorf("command %q is not in the shell allowlist", command)
+}
+
+// lookPath resolves command using the sandbox's PATH. Payloads can't
+// change it: a payload PATH would lose to an inherited one, and without
+// one it only applies to what the command itself runs.
+func (s *Sandbox) lookPath(command string) (string, error) {
+	if strings.Contains(command, "/") {
+		if !filepath.IsAbs(command) {
+			return "", fmt.Errorf("relative command path %q is not permitted", command)
+		}
+		return filepath.Clean(command), nil
+	}
+	pathEnv := s.environ(nil)["PATH"]
+	for _, dir := range filepath.SplitList(pathEnv) {
+		if dir == "" {
+			continue
+		}
+		p := filepath.Join(dir, command)
+		if fi, err := os.Stat(p); err == nil && !fi.IsDir() && fi.Mode()&0o111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("command %q not found in sandbox PATH", command)
+}
+
+func (r Rule) matches(command, exe string) bool {
+	if !strings.Contains(r.Command, "/") {
+		return r.Command == command
+	}
+	ok, _ := path.Match(r.Command, exe)
+	return ok
+}
+
+func (r Rule) argAllowed(arg string) bool {
+	if len(r.Args) == 0 {
+		return true
+	}
+	for _, re := range r.Args {
+		if re.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkEnv rejects payload variables not in AllowEnv. An allowlist rather
+// than a denylist, since too many variables load code into some interpreter
+// (LD_PRELOAD, PYTHONPATH, NODE_OPTIONS, GIT_SSH_COMMAND, ...).
+func (s *Sandbox) checkEnv(env map[string]string) error {
+	for k := range env {
+		if !s.envAllowed(k) {
+			return fmt.Errorf("env %s is not permitted", k)
+		}
+	}
+	return nil
+}
+
+func (s *Sandbox) envAllowed(name string) bool {
+	for _, pattern := range s.AllowEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// workDir resolves a payload working directory. When the sandbox has a Dir,
+// payload directories are relative to it and may not escape it.
+func (s *Sandbox) workDir(dir string) (string, error) {
+	if s.Dir == "" || dir == "" {
+		return dir, nil
+	}
+	p := dir
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(s.Dir, p)
+	}
+	p = filepath.Clean(p)
+	rel, err := filepath.Rel(s.Dir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("dir %q is outside the sandbox directory", dir)
+	}
+	return p, nil
+}
+
+// environ builds the scrubbed environment as a map: payload variables, then
+// inherited ones and Env, so the sandbox's own values always win.
+func (s *Sandbox) environ(payload map[string]string) map[string]string {
+	env := map[string]string{}
+	for k, v := range payload {
+		env[k] = v
+	}
+	for _, name := range s.InheritEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			env[name] = v
+		}
+	}
+	for k, v := range s.Env {
+		env[k] = v
+	}
+	return env
+}
+
+func (s *Sandbox) envList(payload map[string]string) []string {
+	env := s.environ(payload)
+	list := make([]string, 0, len(env))
+	for k, v := range env {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// apply configures cmd according to the sandbox, with the payload variables
+// env, which checkEnv has accepted. The returned cleanup must be called
+// after the command exits.
+func (s *Sandbox) apply(cmd *exec.Cmd, env map[string]string) (cleanup func(), err error) {
+	cmd.Env = s.envList(env)
+	if s.Dir != "" {
+		cmd.Dir = s.Dir
+	}
+	return s.applyPlatform(cmd)
+}
+
+// cappedBuffer keeps at most max bytes and counts what it dropped.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	room := b.max - b.buf.Len()
+	if room <= 0 {
+		b.dropped += len(p)
+		return len(p), nil
+	}
+	if len(p) > room {
+		b.buf.Write(p[:room])
+		b.dropped += len(p) - room
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	if b.dropped > 0 {
+		return fmt.Sprintf("%s...[truncated %d bytes]", b.buf.String(), b.dropped)
+	}
+	return b.buf.String()
+}
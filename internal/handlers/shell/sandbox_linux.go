@@ -0,0 +1,162 @@
+package shell
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/google/uuid"
+	"golang.org/x/sys/unix"
+)
+
+// rlimitHelper as the first argument makes the localflow binary set the
+// sandbox rlimits on itself and exec the command, so the limits are in
+// place before any of the command's code runs. See RunRlimitHelper.
+const rlimitHelper = "__shell-rlimit-exec"
+
+func (s *Sandbox) applyPlatform(cmd *exec.Cmd) (func(), error) {
+	// Run in a new process group so a timeout kills everything the command
+	// spawned, not just its leader.
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+
+	if s.User != "" {
+		uid, gid, err := lookupUser(s.User)
+		if err != nil {
+			return nil, err
+		}
+		attr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	}
+
+	if s.Limits != (Limits{}) {
+		self, err := os.Executable()
+		if err != nil {
+			return nil, fmt.Errorf("rlimit helper: %w", err)
+		}
+		limits := fmt.Sprintf("%d,%d,%d,%d", uint64(s.Limits.CPUTime.Seconds()), s.Limits.Memory, s.Limits.OpenFiles, s.Limits.Processes)
+		cmd.Args = append([]string{self, rlimitHelper, limits, cmd.Path}, cmd.Args...)
+		cmd.Path = self
+	}
+
+	cleanup := func() {}
+	if s.Cgroup.Enabled {
+		dir, fd, err := s.createCgroup()
+		if err != nil {
+			return nil, err
+		}
+		if fd != nil {
+			attr.UseCgroupFD = true
+			attr.CgroupFD = int(fd.Fd())
+			cleanup = func() {
+				fd.Close()
+				_ = os.Remove(dir)
+			}
+		}
+	}
+	return cleanup, nil
+}
+
+// RunRlimitHelper returns unless the process was started as the rlimit
+// helper of a sandboxed command; then it sets the limits and execs the
+// command, exiting with 126 if that fails. main calls it first.
+func RunRlimitHelper() {
+	if len(os.Args) < 5 || os.Args[1] != rlimitHelper {
+		return
+	}
+	err := execLimited(os.Args[2], os.Args[3], os.Args[4:])
+	fmt.Fprintf(os.Stderr, "shell sandbox: %v\n", err)
+	os.Exit(126)
+}
+
+// execLimited sets limits, as written by applyPlatform, and replaces the
+// process with exe.
+func execLimited(limits, exe string, argv []string) error {
+	var cpu, memory, files, procs uint64
+	if _, err := fmt.Sscanf(limits, "%d,%d,%d,%d", &cpu, &memory, &files, &procs); err != nil {
+		return fmt.Errorf("bad limits %q", limits)
+	}
+	env := os.Environ()
+	for _, l := range []struct {
+		name     string
+		resource int
+		v        uint64
+	}{
+		{"cpu", syscall.RLIMIT_CPU, cpu},
+		{"memory", syscall.RLIMIT_AS, memory},
+		{"open files", syscall.RLIMIT_NOFILE, files},
+		{"process", unix.RLIMIT_NPROC, procs},
+	} {
+		if l.v == 0 {
+			continue
+		}
+		if err := syscall.Setrlimit(l.resource, &syscall.Rlimit{Cur: l.v, Max: l.v}); err != nil {
+			return fmt.Errorf("set %s limit: %w", l.name, err)
+		}
+	}
+	return syscall.Exec(exe, argv, env)
+}
+
+func lookupUser(name string) (uint32, uint32, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return 0, 0, fmt.Errorf("run-as user %q: %w", name, err)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint32(uid), uint32(gid), nil
+}
+
+// createCgroup makes a per-command cgroup v2 directory. It returns a nil fd
+// when cgroup v2 is not mounted, so callers run without it.
+func (s *Sandbox) createCgroup() (string, *os.File, error) {
+	parent := s.Cgroup.Parent
+	if parent == "" {
+		parent = "/sys/fs/cgroup/localflow"
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err != nil {
+		return "", nil, nil
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", nil, fmt.Errorf("create cgroup parent: %w", err)
+	}
+	// Delegate the controllers we need to children of parent.
+	_ = os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+cpu +memory"), 0o644)
+
+	dir := filepath.Join(parent, "task-"+uuid.NewString())
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", nil, fmt.Errorf("create cgroup: %w", err)
+	}
+	if s.Cgroup.Memory > 0 {
+		if err := os.WriteFile(filepath.Join(dir, "memory.max"), []byte(strconv.FormatInt(s.Cgroup.Memory, 10)), 0o644); err != nil {
+			_ = os.Remove(dir)
+			return "", nil, fmt.Errorf("set cgroup memory.max: %w", err)
+		}
+	}
+	if s.Cgroup.CPU > 0 {
+		const period = 100000
+		quota := fmt.Sprintf("%d %d", int(s.Cgroup.CPU*period), period)
+		if err := os.WriteFile(filepath.Join(dir, "cpu.max"), []byte(quota), 0o644); err != nil {
+			_ = os.Remove(dir)
+			return "", nil, fmt.Errorf("set cgroup cpu.max: %w", err)
+		}
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		_ = os.Remove(dir)
+		return "", nil, err
+	}
+	return dir, fd, nil
+}
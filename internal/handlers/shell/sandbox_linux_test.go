@@ -0,0 +1,37 @@
+package shell
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLimitsSetBeforeExec(t *testing.T) {
+	h := Shell{Sandbox: &Sandbox{
+		Allow:      []Rule{{Command: "sh"}},
+		InheritEnv: []string{"PATH"},
+		Limits:     Limits{OpenFiles: 64, Processes: 4096},
+	}}
+	tests := []struct {
+		script string
+		ok     bool
+	}{
+		{`test "$(ulimit -n)" = 64`, true},
+		{`test "$(ulimit -Hn)" = 64`, true},
+		{`test "$(ulimit -n)" = 1024`, false},
+		// Children inherit the limits.
+		{`sh -c 'test "$(ulimit -n)" = 64'`, true},
+	}
+	for _, tt := range tests {
+		err := h.run(context.Background(), Cmd{Command: tt.script, Shell: true})
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok %v", tt.script, err, tt.ok)
+		}
+	}
+}
+
+func TestNoLimitsRunsDirectly(t *testing.T) {
+	h := Shell{Sandbox: &Sandbox{Allow: []Rule{{Command: "true"}}, InheritEnv: []string{"PATH"}}}
+	if err := h.run(context.Background(), Cmd{Command: "true"}); err != nil {
+		t.Fatal(err)
+	}
+}
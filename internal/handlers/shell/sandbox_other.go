@@ -0,0 +1,19 @@
+//go:build !linux
+
+package shell
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func (s *Sandbox) applyPlatform(cmd *exec.Cmd) (func(), error) {
+	if s.User != "" || s.Cgroup.Enabled || s.Limits != (Limits{}) {
+		return nil, errors.New("run-as user, rlimits and cgroups are only supported on linux")
+	}
+	return func() {}, nil
+}
+
+// RunRlimitHelper does nothing outside linux, where rlimits aren't
+// supported.
+func RunRlimitHelper() {}
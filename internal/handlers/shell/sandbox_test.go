@@ -0,0 +1,196 @@
+package shell
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary act as the rlimit helper, as the localflow
+// binary does in main.
+func TestMain(m *testing.M) {
+	RunRlimitHelper()
+	os.Exit(m.Run())
+}
+
+func TestArgAllowed(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		arg      string
+		want     bool
+	}{
+		{nil, "anything", true},
+		{[]string{`a|ab`}, "ab", true},
+		{[]string{`a|ab`}, "a", true},
+		{[]string{`a|ab`}, "abc", false},
+		{[]string{`[0-9]+`}, "42", true},
+		{[]string{`[0-9]+`}, "42x", false},
+		{[]string{`[0-9]+`}, "x42", false},
+		{[]string{`-v`, `--verbose`}, "--verbose", true},
+		{[]string{`-v`, `--verbose`}, "-vv", false},
+		{[]string{`.*`}, "", true},
+	}
+	for _, tt := range tests {
+		var rule Rule
+		for _, p := range tt.patterns {
+			re, err := CompileArg(p)
+			if err != nil {
+				t.Fatalf("CompileArg(%q): %v", p, err)
+			}
+			rule.Args = append(rule.Args, re)
+		}
+		if got := rule.argAllowed(tt.arg); got != tt.want {
+			t.Errorf("patterns %q, arg %q: got %v, want %v", tt.patterns, tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{"tool": 0o755, "other": 0o755, "data": 0o644} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!/bin/sh\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sb := &Sandbox{
+		Env: map[string]string{"PATH": bin},
+		Allow: []Rule{
+			{Command: "tool", Args: []*regexp.Regexp{regexp.MustCompile(`^(?:--verbose|-n)$`)}},
+			{Command: "data"},
+			{Command: filepath.Join(dir, "b*", "oth*")},
+		},
+	}
+	tests := []struct {
+		command string
+		args    []string
+		want    string // empty when rejected
+	}{
+		{"tool", []string{"--verbose", "-n"}, filepath.Join(bin, "tool")},
+		{"tool", nil, filepath.Join(bin, "tool")},
+		{"tool", []string{"--force"}, ""},
+		{"other", nil, filepath.Join(bin, "other")}, // path rules match the resolved path
+		{filepath.Join(bin, "other"), []string{"x"}, filepath.Join(bin, "other")},
+		{filepath.Join(bin, "..", "bin", "other"), nil, filepath.Join(bin, "other")},
+		{filepath.Join(bin, "tool"), nil, ""}, // the rule names it, not the path
+		{"data", nil, ""},                     // not executable, so not found
+		{"missing", nil, ""},
+		{"bin/tool", nil, ""}, // relative paths are rejected
+	}
+	for _, tt := range tests {
+		got, err := sb.resolve(tt.command, tt.args)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("resolve(%q, %q) = %q, want an error", tt.command, tt.args, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolve(%q, %q) = %q, %v; want %q", tt.command, tt.args, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveEmptyAllowlist(t *testing.T) {
+	sb := &Sandbox{Env: map[string]string{"PATH": os.Getenv("PATH")}}
+	if _, err := sb.resolve("sh", nil); err == nil {
+		t.Fatal("empty allowlist permitted sh")
+	}
+}
+
+func TestWorkDir(t *testing.T) {
+	tests := []struct {
+		root, dir string
+		want      string // empty when rejected, unless dir is empty
+		ok        bool
+	}{
+		{"", "/anywhere", "/anywhere", true},
+		{"/srv/sb", "", "", true},
+		{"/srv/sb", "jobs/a", "/srv/sb/jobs/a", true},
+		{"/srv/sb", "jobs/../b", "/srv/sb/b", true},
+		{"/srv/sb", "/srv/sb/c", "/srv/sb/c", true},
+		{"/srv/sb", ".", "/srv/sb", true},
+		{"/srv/sb", "..", "", false},
+		{"/srv/sb", "../x", "", false},
+		{"/srv/sb", "jobs/../../x", "", false},
+		{"/srv/sb", "/etc", "", false},
+		{"/srv/sb", "/srv/sbx", "", false},
+	}
+	for _, tt := range tests {
+		sb := &Sandbox{Dir: tt.root}
+		got, err := sb.workDir(tt.dir)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("Dir %q: workDir(%q) = %q, %v; want %q, ok %v", tt.root, tt.dir, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCheckEnv(t *testing.T) {
+	sb := &Sandbox{AllowEnv: []string{"MODE", "APP_*"}}
+	for _, name := range []string{
+		"PATH", "LD_PRELOAD", "DYLD_INSERT_LIBRARIES", "BASH_ENV",
+		"PYTHONPATH", "PYTHONSTARTUP", "NODE_OPTIONS", "PERL5LIB", "RUBYOPT", "GIT_SSH_COMMAND",
+		"MODE2", "XAPP_X",
+	} {
+		if err := sb.checkEnv(map[string]string{name: "x"}); err == nil {
+			t.Errorf("env %s permitted", name)
+		}
+	}
+	if err := sb.checkEnv(map[string]string{"MODE": "fast", "APP_NAME": "x"}); err != nil {
+		t.Errorf("allowed env rejected: %v", err)
+	}
+	if err := (&Sandbox{}).checkEnv(map[string]string{"MODE": "fast"}); err == nil {
+		t.Error("empty allow_env permitted MODE")
+	}
+}
+
+func TestSandboxEnvWins(t *testing.T) {
+	t.Setenv("LF_TEST_INHERITED", "server")
+	sb := &Sandbox{
+		InheritEnv: []string{"LF_TEST_INHERITED"},
+		Env:        map[string]string{"PATH": os.Getenv("PATH"), "TZ": "UTC"},
+		AllowEnv:   []string{"*"},
+	}
+	env := sb.environ(map[string]string{
+		"TZ": "Asia/Tokyo", "PATH": "/tmp/evil", "LF_TEST_INHERITED": "payload", "MODE": "fast",
+	})
+	want := map[string]string{
+		"TZ": "UTC", "PATH": os.Getenv("PATH"), "LF_TEST_INHERITED": "server", "MODE": "fast",
+	}
+	for k, v := range want {
+		if env[k] != v {
+			t.Errorf("env %s = %q, want %q", k, env[k], v)
+		}
+	}
+
+	// The command sees one value per name, the sandbox's.
+	cmd := exec.CommandContext(context.Background(), "/bin/sh", "-c", "env")
+	cleanup, err := sb.apply(cmd, map[string]string{"TZ": "Asia/Tokyo", "MODE": "fast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tz []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "TZ=") {
+			tz = append(tz, line)
+		}
+	}
+	if len(tz) != 1 || tz[0] != "TZ=UTC" {
+		t.Errorf("command saw %q", tz)
+	}
+	if !strings.Contains(string(out), "MODE=fast\n") {
+		t.Errorf("payload MODE missing from %q", out)
+	}
+}
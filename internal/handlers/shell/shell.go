@@ -4,14 +4,32 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"strings"
+	"time"
+
+	"localflow/internal/secrets"
+	"localflow/internal/tasklog"
 )
 
-type Shell struct{}
+// Shell runs local commands. With a nil Sandbox commands run unrestricted
+// with the server's privileges, environment and working directory.
+type Shell struct {
+	Sandbox *Sandbox
+	// Secrets resolves {{secret "name"}} references in Args and Env.
+	Secrets secrets.Resolver
+}
 
 type Cmd struct {
-	Command string   `json:"command"`
-	Args    []string `json:"args"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Stdin   string            `json:"stdin"`
+	Env     map[string]string `json:"env"`
+	Dir     string            `json:"dir"`
+	// Shell runs Command as a sh script; Args become $1, $2, ...
+	Shell bool `json:"shell"`
 }
 
 func (h Shell) Handle(ctx context.Context, payload json.RawMessage) error {
@@ -22,10 +40,101 @@ func (h Shell) Handle(ctx context.Context, payload json.RawMessage) error {
 	if c.Command == "" {
 		return fmt.Errorf("command is required")
 	}
-	cmd := exec.CommandContext(ctx, c.Command, c.Args...)
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("shell error: %v; out=%s", err, string(out))
+
+	exp := secrets.NewExpander(ctx, h.Secrets)
+	if err := c.expandSecrets(exp); err != nil {
+		return err
+	}
+	tasklog.FromContext(ctx).Redact(exp.Values()...)
+	return exp.RedactError(h.run(ctx, c))
+}
+
+// expandSecrets resolves secret references in arguments and environment
+// values. The command itself is never expanded.
+func (c *Cmd) expandSecrets(exp *secrets.Expander) error {
+	args := make([]string, len(c.Args))
+	for i, a := range c.Args {
+		v, err := exp.Expand(a)
+		if err != nil {
+			return fmt.Errorf("args[%d]: %w", i, err)
+		}
+		args[i] = v
+	}
+	c.Args = args
+	env := make(map[string]string, len(c.Env))
+	for k, v := range c.Env {
+		v, err := exp.Expand(v)
+		if err != nil {
+			return fmt.Errorf("env %s: %w", k, err)
+		}
+		env[k] = v
+	}
+	c.Env = env
+	return nil
+}
+
+func (h Shell) run(ctx context.Context, c Cmd) error {
+	name, args := c.Command, c.Args
+	if c.Shell {
+		name, args = "sh", append([]string{"-c", c.Command, "sh"}, c.Args...)
+	}
+
+	exe, dir := name, c.Dir
+	cleanup := func() {}
+	var cmd *exec.Cmd
+	if h.Sandbox != nil {
+		var err error
+		if exe, err = h.Sandbox.resolve(name, args); err != nil {
+			return fmt.Errorf("shell sandbox: %w", err)
+		}
+		if err := h.Sandbox.checkEnv(c.Env); err != nil {
+			return fmt.Errorf("shell sandbox: %w", err)
+		}
+		if dir, err = h.Sandbox.workDir(c.Dir); err != nil {
+			return fmt.Errorf("shell sandbox: %w", err)
+		}
+		cmd = exec.CommandContext(ctx, exe, args...)
+		if cleanup, err = h.Sandbox.apply(cmd, c.Env); err != nil {
+			return fmt.Errorf("shell sandbox: %w", err)
+		}
+	} else {
+		cmd = exec.CommandContext(ctx, exe, args...)
+		if len(c.Env) > 0 {
+			cmd.Env = os.Environ()
+			for k, v := range c.Env {
+				cmd.Env = append(cmd.Env, k+"="+v)
+			}
+		}
+	}
+	defer cleanup()
+
+	if dir != "" {
+		cmd.Dir = dir
+	}
+	if c.Stdin != "" {
+		cmd.Stdin = strings.NewReader(c.Stdin)
+	}
+	// Don't let grandchildren holding the pipes open block Wait forever.
+	cmd.WaitDelay = time.Second
+
+	max := DefaultMaxOutput
+	if h.Sandbox != nil && h.Sandbox.MaxOutput > 0 {
+		max = h.Sandbox.MaxOutput
+	}
+	logger := tasklog.FromContext(ctx)
+	stdout := logger.Stream(tasklog.StreamStdout, max)
+	stderr := logger.Stream(tasklog.StreamStderr, max)
+	defer stdout.Close()
+	defer stderr.Close()
+	errOut := &cappedBuffer{max: max}
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, errOut)
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("shell error: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("shell error: %v; stderr=%s", err, errOut.String())
 	}
 	return nil
 }
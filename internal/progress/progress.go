@@ -0,0 +1,127 @@
+package progress
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store persists task progress.
+type Store interface {
+	SetTaskProgress(ctx context.Context, id string, progress *float64, message string) error
+}
+
+// minInterval is the shortest gap between two progress writes for a task.
+const minInterval = time.Second
+
+// Reporter records progress for one running task. Updates are coalesced so
+// a handler may report as often as it likes; the latest value reaches the
+// store at most once per second and on Close. A nil *Reporter discards
+// everything.
+type Reporter struct {
+	store  Store
+	taskID string
+
+	mu      sync.Mutex
+	percent *float64
+	message string
+	dirty   bool
+	last    time.Time
+	timer   *time.Timer
+	closed  bool
+
+	writeMu sync.Mutex // keeps writes in order
+}
+
+// New returns a reporter for taskID. Close must be called when the attempt
+// finishes.
+func New(store Store, taskID string) *Reporter {
+	return &Reporter{store: store, taskID: taskID}
+}
+
+// Report sets percent complete (clamped to 0-100) and the status message.
+func (r *Reporter) Report(percent float64, message string) {
+	if r == nil {
+		return
+	}
+	percent = min(max(percent, 0), 100)
+	r.update(func() {
+		r.percent = &percent
+		r.message = message
+	})
+}
+
+// Status sets the status message, keeping the reported percentage.
+func (r *Reporter) Status(message string) {
+	if r == nil {
+		return
+	}
+	r.update(func() { r.message = message })
+}
+
+func (r *Reporter) update(set func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	set()
+	r.dirty = true
+	if r.timer == nil {
+		wait := minInterval - time.Since(r.last)
+		r.timer = time.AfterFunc(max(wait, 0), r.flush)
+	}
+}
+
+func (r *Reporter) flush() {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	r.mu.Lock()
+	r.timer = nil
+	if !r.dirty {
+		r.mu.Unlock()
+		return
+	}
+	percent, message := r.percent, r.message
+	r.dirty = false
+	r.last = time.Now()
+	r.mu.Unlock()
+
+	// Progress is advisory, so store errors are dropped.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = r.store.SetTaskProgress(ctx, r.taskID, percent, message)
+}
+
+// Close writes any pending update and stops further reporting.
+func (r *Reporter) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.mu.Unlock()
+	r.flush()
+}
+
+type ctxKey struct{}
+
+// WithReporter attaches a reporter to a handler context.
+func WithReporter(ctx context.Context, r *Reporter) context.Context {
+	return context.WithValue(ctx, ctxKey{}, r)
+}
+
+// FromContext returns the task's reporter, or nil if there is none.
+func FromContext(ctx context.Context) *Reporter {
+	r, _ := ctx.Value(ctxKey{}).(*Reporter)
+	return r
+}
+
+// Report is shorthand for FromContext(ctx).Report(percent, message).
+func Report(ctx context.Context, percent float64, message string) {
+	FromContext(ctx).Report(percent, message)
+}
@@ -5,34 +5,63 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"localflow/internal/domain"
 )
 
-var ErrEmpty = errors.New("no tasks ready")
+var (
+	ErrEmpty        = errors.New("no tasks ready")
+	ErrInvalidState = errors.New("task is not in a valid state for this operation")
+	// ErrKeyConflict is returned by ImportTask when another task holds the
+	// imported task's idempotency key.
+	ErrKeyConflict = errors.New("idempotency key belongs to another task")
+	// ErrDuplicate is returned by EnqueueUnique when the submission
+	// conflicts with an existing task and may not be merged into it.
+	ErrDuplicate = errors.New("a task with this unique key exists")
+)
 
-// EnsureSchema creates tables if they don't exist.
-func EnsureSchema(db *sql.DB) error {
-	schema := `
-PRAGMA journal_mode=WAL;
-CREATE TABLE IF NOT EXISTS tasks (
+// tasksTable defines the tasks table. rebuildTasks creates it under another
+// name to migrate older databases.
+const tasksTable = `(
   id TEXT PRIMARY KEY,
   type TEXT NOT NULL,
   payload BLOB NOT NULL,
   priority INTEGER NOT NULL DEFAULT 5,
-  state TEXT NOT NULL CHECK(state IN ('queued','running','succeeded','failed','canceled')) DEFAULT 'queued',
+  state TEXT NOT NULL CHECK(state IN ('queued','running','succeeded','failed','canceled','expired')) DEFAULT 'queued',
   attempts INTEGER NOT NULL DEFAULT 0,
   max_attempts INTEGER NOT NULL DEFAULT 5,
   next_run_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   visibility_timeout INTEGER NOT NULL DEFAULT 60,
   idempotency_key TEXT,
+  progress REAL,
+  status_message TEXT,
+  schedule_id TEXT,
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
-);
-CREATE INDEX IF NOT EXISTS idx_tasks_next_run ON tasks(state, next_run_at, priority DESC);
-CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_idem ON tasks(idempotency_key) WHERE idempotency_key IS NOT NULL;
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME,
+  unique_key TEXT
+)`
+
+// EnsureSchema creates tables if they don't exist. New databases use
+// incremental auto-vacuum so Optimize can return free pages without a full
+// VACUUM; switching to it takes a VACUUM, which is instant while the
+// database is still empty.
+func EnsureSchema(db *sql.DB) error {
+	var tables int
+	if err := db.QueryRow("SELECT count(*) FROM sqlite_master").Scan(&tables); err != nil {
+		return err
+	}
+	if tables == 0 {
+		if _, err := db.Exec("PRAGMA auto_vacuum=INCREMENTAL; VACUUM"); err != nil {
+			return err
+		}
+	}
+	schema := `
+PRAGMA journal_mode=WAL;
+CREATE TABLE IF NOT EXISTS tasks ` + tasksTable + `;
 CREATE TABLE IF NOT EXISTS task_attempts (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   task_id TEXT NOT NULL,
@@ -42,10 +71,22 @@ CREATE TABLE IF NOT EXISTS task_attempts (
   error TEXT,
   FOREIGN KEY(task_id) REFERENCES tasks(id)
 );
+CREATE INDEX IF NOT EXISTS idx_task_attempts_task ON task_attempts(task_id);
+CREATE TABLE IF NOT EXISTS task_logs (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  task_id TEXT NOT NULL,
+  attempt INTEGER NOT NULL,
+  stream TEXT NOT NULL,
+  line TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(task_id) REFERENCES tasks(id)
+);
+CREATE INDEX IF NOT EXISTS idx_task_logs_task ON task_logs(task_id, id);
 CREATE TABLE IF NOT EXISTS schedules (
   id TEXT PRIMARY KEY,
   name TEXT NOT NULL,
   cron_expr TEXT NOT NULL,
+  timezone TEXT NOT NULL DEFAULT '',
   task_type TEXT NOT NULL,
   payload BLOB NOT NULL,
   priority INTEGER NOT NULL DEFAULT 5,
@@ -53,76 +94,479 @@ CREATE TABLE IF NOT EXISTS schedules (
   enabled INTEGER NOT NULL DEFAULT 1,
   last_run DATETIME,
   next_run DATETIME NOT NULL,
+  run_count INTEGER NOT NULL DEFAULT 0,
+  misfire_policy TEXT NOT NULL DEFAULT '',
+  misfire_grace INTEGER NOT NULL DEFAULT 0,
+  max_catchup INTEGER NOT NULL DEFAULT 0,
+  concurrency_policy TEXT NOT NULL DEFAULT '',
+  start_at DATETIME,
+  end_at DATETIME,
+  exclude_calendars TEXT NOT NULL DEFAULT '',
+  jitter INTEGER NOT NULL DEFAULT 0,
+  source TEXT NOT NULL DEFAULT '',
   created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
   updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 );
 CREATE INDEX IF NOT EXISTS idx_schedules_next_run ON schedules(enabled, next_run);
+CREATE TABLE IF NOT EXISTS schedule_misfires (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  schedule_id TEXT NOT NULL,
+  fire_time DATETIME NOT NULL,
+  reason TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(schedule_id) REFERENCES schedules(id)
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_misfires_schedule ON schedule_misfires(schedule_id, id);
+CREATE TABLE IF NOT EXISTS api_keys (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,
+  scopes TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at DATETIME
+);
+CREATE TABLE IF NOT EXISTS sessions (
+  token_hash TEXT PRIMARY KEY,
+  key_id TEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(key_id) REFERENCES api_keys(id)
+);
+CREATE TABLE IF NOT EXISTS calendars (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS calendar_dates (
+  calendar TEXT NOT NULL,
+  date TEXT NOT NULL,
+  PRIMARY KEY(calendar, date),
+  FOREIGN KEY(calendar) REFERENCES calendars(name)
+);
+CREATE TABLE IF NOT EXISTS leases (
+  name TEXT PRIMARY KEY,
+  owner TEXT NOT NULL,
+  expires_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS secrets (
+  name TEXT PRIMARY KEY,
+  value BLOB NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
 `
-	_, err := db.Exec(schema)
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	if err := addColumns(db); err != nil {
+		return err
+	}
+	if err := rebuildTasks(db); err != nil {
+		return err
+	}
+	// Indexes on tasks are created after it may have been rebuilt, and
+	// those on migrated columns once they exist.
+	_, err := db.Exec(`
+CREATE INDEX IF NOT EXISTS idx_tasks_next_run ON tasks(state, next_run_at, priority DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tasks_idem ON tasks(idempotency_key) WHERE idempotency_key IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_state_updated ON tasks(state, updated_at);
+CREATE INDEX IF NOT EXISTS idx_tasks_schedule ON tasks(schedule_id, state);
+CREATE INDEX IF NOT EXISTS idx_tasks_expires ON tasks(expires_at) WHERE expires_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_tasks_unique ON tasks(type, unique_key, state) WHERE unique_key IS NOT NULL;
+`)
 	return err
 }
 
+// rebuildTasks copies a tasks table created before the expired state into
+// a new one, as SQLite can't change a CHECK constraint in place. The
+// indexes are recreated by EnsureSchema.
+func rebuildTasks(db *sql.DB) error {
+	var ddl string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='tasks'`).Scan(&ddl); err != nil {
+		return err
+	}
+	if strings.Contains(ddl, "'expired'") {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`CREATE TABLE tasks_new ` + tasksTable,
+		`INSERT INTO tasks_new (` + taskColumns + `) SELECT ` + taskColumns + ` FROM tasks`,
+		`DROP TABLE tasks`,
+		`ALTER TABLE tasks_new RENAME TO tasks`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// columnMigrations are columns added to existing tables after their first
+// release. CREATE TABLE IF NOT EXISTS leaves older databases without them.
+var columnMigrations = []struct{ table, column, def string }{
+	{"tasks", "progress", "REAL"},
+	{"tasks", "status_message", "TEXT"},
+	{"schedules", "run_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"schedules", "timezone", "TEXT NOT NULL DEFAULT ''"},
+	{"schedules", "misfire_policy", "TEXT NOT NULL DEFAULT ''"},
+	{"schedules", "misfire_grace", "INTEGER NOT NULL DEFAULT 0"},
+	{"schedules", "max_catchup", "INTEGER NOT NULL DEFAULT 0"},
+	{"tasks", "schedule_id", "TEXT"},
+	{"schedules", "concurrency_policy", "TEXT NOT NULL DEFAULT ''"},
+	{"schedules", "start_at", "DATETIME"},
+	{"schedules", "end_at", "DATETIME"},
+	{"schedules", "exclude_calendars", "TEXT NOT NULL DEFAULT ''"},
+	{"schedules", "jitter", "INTEGER NOT NULL DEFAULT 0"},
+	{"schedules", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"tasks", "expires_at", "DATETIME"},
+	{"tasks", "unique_key", "TEXT"},
+}
+
+func addColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`, m.table, m.column).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.def)); err != nil {
+			return fmt.Errorf("add %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	return nil
+}
+
+const taskColumns = `id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,progress,status_message,schedule_id,created_at,updated_at,expires_at,unique_key`
+
+func scanTask(row rowScanner) (domain.Task, error) {
+	var t domain.Task
+	var idem, msg, scheduleID, uniqueKey sql.NullString
+	var progress sql.NullFloat64
+	var expiresAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Type, &t.Payload, &t.Priority, &t.Attempts, &t.MaxAttempts, &t.State, &t.NextRunAt, &t.VisibilityTimeout, &idem, &progress, &msg, &scheduleID, &t.CreatedAt, &t.UpdatedAt, &expiresAt, &uniqueKey); err != nil {
+		return domain.Task{}, err
+	}
+	if idem.Valid {
+		s := idem.String
+		t.IdempotencyKey = &s
+	}
+	if progress.Valid {
+		p := progress.Float64
+		t.Progress = &p
+	}
+	t.StatusMessage = msg.String
+	t.ScheduleID = scheduleID.String
+	if expiresAt.Valid {
+		t.ExpiresAt = &expiresAt.Time
+	}
+	t.UniqueKey = uniqueKey.String
+	return t, nil
+}
+
 type Repository interface {
 	Enqueue(ctx context.Context, t domain.Task) (string, error)
-	LeaseNext(ctx context.Context, now time.Time) (domain.Task, Lease, error)
+	// EnqueueUnique enqueues t with u.Key as its unique key unless it
+	// conflicts with an existing task, in which case u.OnConflict decides.
+	// It returns the new or existing task's ID and whether it is existing.
+	EnqueueUnique(ctx context.Context, t domain.Task, u domain.Unique) (string, bool, error)
+	LeaseNext(ctx context.Context, now time.Time, excludeTypes ...string) (domain.Task, Lease, error)
 	Retry(ctx context.Context, id, err string, delay time.Duration) error
 	Succeed(ctx context.Context, id string) error
 	Fail(ctx context.Context, id, err string, delay time.Duration) error
 	RecoverStale(ctx context.Context, now time.Time) (int, error)
 	Get(ctx context.Context, id string) (domain.Task, error)
 	ListRecentTasks(ctx context.Context, limit int) ([]domain.Task, error)
+	ListTasks(ctx context.Context, f TaskFilter) ([]domain.Task, error)
+	Cancel(ctx context.Context, id string) error
+	Requeue(ctx context.Context, id string) error
+	CountTasksByState(ctx context.Context) (map[string]int, error)
+	SetTaskProgress(ctx context.Context, id string, progress *float64, message string) error
+	AppendTaskLogs(ctx context.Context, lines []domain.TaskLog) error
+	ListTaskLogs(ctx context.Context, taskID string, afterID int64, limit int) ([]domain.TaskLog, error)
+	// ActiveScheduleTasks returns the queued and running tasks a schedule
+	// enqueued.
+	ActiveScheduleTasks(ctx context.Context, scheduleID string) ([]domain.Task, error)
+	// TasksAfter returns up to limit tasks in the given states with IDs
+	// after afterID, in ID order, for walking the table in batches.
+	TasksAfter(ctx context.Context, states []string, afterID string, limit int) ([]domain.Task, error)
+	ListTaskAttempts(ctx context.Context, taskID string) ([]domain.TaskAttempt, error)
+	// PrunableTasks returns finished tasks the retention policy selects,
+	// oldest first.
+	PrunableTasks(ctx context.Context, f PruneFilter) ([]domain.Task, error)
+	// DeleteFinishedTasks deletes the tasks with the given IDs that are
+	// still finished, with their attempts and logs, and returns how many
+	// it deleted.
+	DeleteFinishedTasks(ctx context.Context, ids []string) (int, error)
+	// ImportTask stores a task as is, with its state, counters and
+	// attempts. With replace an existing task of the same ID is deleted
+	// first, with its attempts and logs.
+	ImportTask(ctx context.Context, t domain.Task, attempts []domain.TaskAttempt, replace bool) error
 
 	// Schedule operations
 	CreateSchedule(ctx context.Context, s domain.Schedule) (string, error)
 	GetSchedule(ctx context.Context, id string) (domain.Schedule, error)
 	ListSchedules(ctx context.Context) ([]domain.Schedule, error)
 	UpdateSchedule(ctx context.Context, s domain.Schedule) error
+	// SaveSchedules creates and updates schedules in one transaction.
+	SaveSchedules(ctx context.Context, creates, updates []domain.Schedule) error
 	DeleteSchedule(ctx context.Context, id string) error
 	GetDueSchedules(ctx context.Context, now time.Time) ([]domain.Schedule, error)
-	UpdateScheduleLastRun(ctx context.Context, id string, lastRun, nextRun time.Time) error
+	// UpdateScheduleLastRun records a fire; newRun is false when the fire's
+	// task already existed, so the run isn't counted twice.
+	UpdateScheduleLastRun(ctx context.Context, id string, lastRun, nextRun time.Time, newRun bool) error
+	// CountScheduleRun records a run triggered outside the schedule, leaving
+	// its next run alone.
+	CountScheduleRun(ctx context.Context, id string, lastRun time.Time) error
+	// SkipScheduleRuns records fires that will not enqueue a task and moves
+	// the schedule's next run to nextRun.
+	SkipScheduleRuns(ctx context.Context, id string, fires []time.Time, reason string, nextRun time.Time) error
+	ListScheduleMisfires(ctx context.Context, id string, limit int) ([]domain.ScheduleMisfire, error)
+	// DeleteScheduleMisfires deletes up to limit misfire records created
+	// before before, oldest first, and returns how many it deleted.
+	DeleteScheduleMisfires(ctx context.Context, before time.Time, limit int) (int, error)
+	ListScheduleRuns(ctx context.Context, id string, limit int) ([]domain.ScheduleRun, error)
+	// RecentScheduleRuns returns up to limit runs of every schedule, newest
+	// first, keyed by schedule ID.
+	RecentScheduleRuns(ctx context.Context, limit int) (map[string][]domain.ScheduleRun, error)
+
+	// API key and dashboard session operations
+	CreateAPIKey(ctx context.Context, k domain.APIKey) (string, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	CreateSession(ctx context.Context, tokenHash, keyID string, expiresAt time.Time) error
+	GetSessionKey(ctx context.Context, tokenHash string, now time.Time) (domain.APIKey, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+
+	// Calendar operations. PutCalendar replaces the calendar's dates.
+	PutCalendar(ctx context.Context, c domain.Calendar) error
+	GetCalendar(ctx context.Context, name string) (domain.Calendar, error)
+	ListCalendars(ctx context.Context) ([]domain.Calendar, error)
+	DeleteCalendar(ctx context.Context, name string) error
+
+	// Lease operations coordinate processes sharing the database, e.g. to
+	// elect the one that runs the scheduler.
+	AcquireLease(ctx context.Context, name, owner string, ttl time.Duration, now time.Time) (bool, error)
+	ReleaseLease(ctx context.Context, name, owner string) error
+
+	// Secret operations. Values are ciphertext; see package secrets.
+	PutSecret(ctx context.Context, name string, value []byte) error
+	GetSecret(ctx context.Context, name string) (domain.Secret, error)
+	ListSecrets(ctx context.Context) ([]domain.Secret, error)
+	DeleteSecret(ctx context.Context, name string) error
+
+	// Optimize returns the space of deleted rows to the file system where
+	// the database allows it, then refreshes stale query planner
+	// statistics.
+	Optimize(ctx context.Context) error
+	// Backup writes a consistent copy of the database to path, which must
+	// not exist, while the server keeps running.
+	Backup(ctx context.Context, path string) error
+}
+
+// TaskDefaults are applied by Enqueue and CreateSchedule to fields left unset.
+type TaskDefaults struct {
+	Priority          int
+	MaxAttempts       int
+	VisibilityTimeout int // seconds
+	// MaxAttemptsByType overrides MaxAttempts for individual task types.
+	MaxAttemptsByType map[string]int
+}
+
+var DefaultTaskDefaults = TaskDefaults{Priority: 5, MaxAttempts: 5, VisibilityTimeout: 60}
+
+func (d TaskDefaults) maxAttempts(taskType string) int {
+	if n := d.MaxAttemptsByType[taskType]; n > 0 {
+		return n
+	}
+	return d.MaxAttempts
+}
+
+type sqliteRepo struct {
+	db       *sql.DB
+	file     string // database file, "" when in memory
+	defaults TaskDefaults
 }
 
-type sqliteRepo struct{ db *sql.DB }
+func NewSQLiteRepo(db *sql.DB) Repository {
+	return NewSQLiteRepoWithDefaults(db, DefaultTaskDefaults)
+}
 
-func NewSQLiteRepo(db *sql.DB) Repository { return &sqliteRepo{db: db} }
+func NewSQLiteRepoWithDefaults(db *sql.DB, defaults TaskDefaults) Repository {
+	r := &sqliteRepo{db: db, defaults: defaults}
+	// Looked up now, as Backup can't wait for the pool's connection.
+	_ = db.QueryRow("SELECT file FROM pragma_database_list WHERE name='main'").Scan(&r.file)
+	return r
+}
 
 // DB returns the underlying database connection (for dashboard queries)
 func (r *sqliteRepo) DB() *sql.DB { return r.db }
 
 type Lease struct{ Until time.Time }
 
+// TaskFilter narrows ListTasks results. Zero values match everything.
+type TaskFilter struct {
+	State      string
+	Type       string
+	ScheduleID string
+	Limit      int
+}
+
+// PruneFilter selects finished tasks for PrunableTasks.
+type PruneFilter struct {
+	State        string
+	Type         string   // only this type, empty for any
+	ExcludeTypes []string // types left to their own rules
+	Before       time.Time
+	Limit        int
+}
+
+// NewTaskID returns an ID for a task that isn't stored yet.
+func NewTaskID() string {
+	return "tsk_" + uuid.NewString()
+}
+
 func (r *sqliteRepo) Enqueue(ctx context.Context, t domain.Task) (string, error) {
+	return r.enqueue(ctx, r.db, t)
+}
+
+// querier is what enqueue and the schedule writes need from a *sql.DB or
+// *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (r *sqliteRepo) enqueue(ctx context.Context, q querier, t domain.Task) (string, error) {
 	id := t.ID
 	if id == "" {
-		id = "tsk_" + uuid.NewString()
+		id = NewTaskID()
 	}
 	if t.Priority == 0 {
-		t.Priority = 5
+		t.Priority = r.defaults.Priority
 	}
 	if t.MaxAttempts == 0 {
-		t.MaxAttempts = 5
+		t.MaxAttempts = r.defaults.maxAttempts(t.Type)
 	}
 	if t.VisibilityTimeout == 0 {
-		t.VisibilityTimeout = 60
+		t.VisibilityTimeout = r.defaults.VisibilityTimeout
 	}
 
 	// Check for existing task with same idempotency key
 	if t.IdempotencyKey != nil {
-		row := r.db.QueryRowContext(ctx, "SELECT id FROM tasks WHERE idempotency_key = ?", *t.IdempotencyKey)
+		row := q.QueryRowContext(ctx, "SELECT id FROM tasks WHERE idempotency_key = ?", *t.IdempotencyKey)
 		var existingID string
 		if err := row.Scan(&existingID); err == nil {
 			return existingID, nil // Return existing task ID
 		}
 	}
 
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO tasks (id,type,payload,priority,state,attempts,max_attempts,next_run_at,visibility_timeout,idempotency_key,created_at,updated_at)
-VALUES (?,?,?,?, 'queued',0,?, CURRENT_TIMESTAMP, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-`, id, t.Type, t.Payload, t.Priority, t.MaxAttempts, t.VisibilityTimeout, t.IdempotencyKey)
+	// Another process sharing the database may insert the same key between
+	// the check above and this insert; the unique index settles the race.
+	res, err := q.ExecContext(ctx, `
+INSERT INTO tasks (id,type,payload,priority,state,attempts,max_attempts,next_run_at,visibility_timeout,idempotency_key,schedule_id,created_at,updated_at,expires_at,unique_key)
+VALUES (?,?,?,?, 'queued',0,?, CURRENT_TIMESTAMP, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+ON CONFLICT(idempotency_key) WHERE idempotency_key IS NOT NULL DO NOTHING
+`, id, t.Type, t.Payload, t.Priority, t.MaxAttempts, t.VisibilityTimeout, t.IdempotencyKey, nullString(t.ScheduleID), nullTime(t.ExpiresAt), nullString(t.UniqueKey))
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 && t.IdempotencyKey != nil {
+		err = q.QueryRowContext(ctx, "SELECT id FROM tasks WHERE idempotency_key = ?", *t.IdempotencyKey).Scan(&id)
+	}
 	return id, err
 }
 
-func (r *sqliteRepo) LeaseNext(ctx context.Context, now time.Time) (domain.Task, Lease, error) {
+// ValidateUnique checks a uniqueness constraint for EnqueueUnique.
+func ValidateUnique(u domain.Unique) error {
+	if u.Key == "" {
+		return errors.New("unique key is required")
+	}
+	switch u.Mode {
+	case domain.UniqueQueued, domain.UniqueActive:
+		if u.Window != 0 {
+			return fmt.Errorf("unique window only applies to mode %s", domain.UniqueWindow)
+		}
+	case domain.UniqueWindow:
+		if u.Window < time.Second {
+			return errors.New("unique window must be at least 1s")
+		}
+	default:
+		return fmt.Errorf("invalid unique mode %q (want %s, %s or %s)", u.Mode, domain.UniqueQueued, domain.UniqueActive, domain.UniqueWindow)
+	}
+	switch u.OnConflict {
+	case "", domain.UniqueReturnExisting, domain.UniqueReplace, domain.UniqueReject:
+		return nil
+	}
+	return fmt.Errorf("invalid unique conflict action %q (want %s, %s or %s)", u.OnConflict, domain.UniqueReturnExisting, domain.UniqueReplace, domain.UniqueReject)
+}
+
+// EnqueueUnique checks for a conflict and enqueues in one transaction, so
+// concurrent submissions with the same key enqueue one task. Replace only
+// merges into a queued task; a conflict with a running or finished one is
+// ErrDuplicate.
+func (r *sqliteRepo) EnqueueUnique(ctx context.Context, t domain.Task, u domain.Unique) (string, bool, error) {
+	if err := ValidateUnique(u); err != nil {
+		return "", false, err
+	}
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, state FROM tasks WHERE type = ? AND unique_key = ?`
+	args := []any{t.Type, u.Key}
+	switch u.Mode {
+	case domain.UniqueQueued:
+		query += ` AND state = 'queued'`
+	case domain.UniqueActive:
+		query += ` AND state IN ('queued','running')`
+	case domain.UniqueWindow:
+		query += ` AND created_at > datetime(CURRENT_TIMESTAMP, ?)`
+		args = append(args, fmt.Sprintf("-%d seconds", int(u.Window.Seconds())))
+	}
+	query += ` ORDER BY created_at DESC LIMIT 1`
+	var id, state string
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&id, &state)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		t.UniqueKey = u.Key
+		id, err := r.enqueue(ctx, tx, t)
+		if err != nil {
+			return "", false, err
+		}
+		return id, false, tx.Commit()
+	case err != nil:
+		return "", false, err
+	}
+
+	switch u.OnConflict {
+	case domain.UniqueReject:
+		return id, true, fmt.Errorf("%w (%s)", ErrDuplicate, id)
+	case domain.UniqueReplace:
+		if state != "queued" {
+			return id, true, fmt.Errorf("%w (%s is %s)", ErrDuplicate, id, state)
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE tasks SET payload=?, updated_at=CURRENT_TIMESTAMP WHERE id=?`, t.Payload, id); err != nil {
+			return "", false, err
+		}
+	}
+	return id, true, tx.Commit()
+}
+
+// LeaseNext claims the highest priority ready task, skipping excludeTypes
+// (used by the pool for task types at their concurrency limit). Queued
+// tasks past their expiry are moved to expired first, so they never run.
+func (r *sqliteRepo) LeaseNext(ctx context.Context, now time.Time, excludeTypes ...string) (domain.Task, Lease, error) {
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
 	if err != nil {
 		return domain.Task{}, Lease{}, err
@@ -133,29 +577,42 @@ func (r *sqliteRepo) LeaseNext(ctx context.Context, now time.Time) (domain.Task,
 		}
 	}()
 
-	row := tx.QueryRowContext(ctx, `
-SELECT id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,created_at,updated_at
+	if _, err = tx.ExecContext(ctx, `
+UPDATE tasks SET state='expired', status_message='expired before it ran', updated_at=CURRENT_TIMESTAMP
+WHERE state='queued' AND expires_at IS NOT NULL AND expires_at <= ?`, sqliteTime(now)); err != nil {
+		return domain.Task{}, Lease{}, err
+	}
+
+	query := `
+SELECT ` + taskColumns + `
 FROM tasks
-WHERE state='queued' AND next_run_at <= ?
+WHERE state='queued' AND next_run_at <= ?`
+	args := []any{now}
+	if len(excludeTypes) > 0 {
+		query += ` AND type NOT IN (?` + strings.Repeat(",?", len(excludeTypes)-1) + `)`
+		for _, typ := range excludeTypes {
+			args = append(args, typ)
+		}
+	}
+	query += `
 ORDER BY priority DESC, created_at ASC
-LIMIT 1
-`, now)
-	var t domain.Task
-	var idem sql.NullString
-	err = row.Scan(&t.ID, &t.Type, &t.Payload, &t.Priority, &t.Attempts, &t.MaxAttempts, &t.State, &t.NextRunAt, &t.VisibilityTimeout, &idem, &t.CreatedAt, &t.UpdatedAt)
+LIMIT 1`
+	row := tx.QueryRowContext(ctx, query, args...)
+	t, err := scanTask(row)
 	if err == sql.ErrNoRows {
-		return domain.Task{}, Lease{}, tx.Rollback()
+		// Keep the tasks expired above.
+		if err = tx.Commit(); err != nil {
+			return domain.Task{}, Lease{}, err
+		}
+		return domain.Task{}, Lease{}, ErrEmpty
 	}
 	if err != nil {
 		return domain.Task{}, Lease{}, err
 	}
-	if idem.Valid {
-		s := idem.String
-		t.IdempotencyKey = &s
-	}
 
 	leaseUntil := now.Add(time.Duration(t.VisibilityTimeout) * time.Second)
-	_, err = tx.ExecContext(ctx, `UPDATE tasks SET state='running', updated_at=CURRENT_TIMESTAMP WHERE id=?`, t.ID)
+	_, err = tx.ExecContext(ctx, `
+UPDATE tasks SET state='running', progress=NULL, status_message=NULL, updated_at=CURRENT_TIMESTAMP WHERE id=?`, t.ID)
 	if err != nil {
 		return domain.Task{}, Lease{}, err
 	}
@@ -167,31 +624,44 @@ LIMIT 1
 }
 
 func (r *sqliteRepo) Retry(ctx context.Context, id, errStr string, delay time.Duration) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO task_attempts(task_id, success, error, finished_at) VALUES (?,0,?,CURRENT_TIMESTAMP);
+	return r.finishAttempt(ctx, id, false, errStr, `
 UPDATE tasks
 SET attempts = attempts + 1,
     state = CASE WHEN attempts + 1 >= max_attempts THEN 'failed' ELSE 'queued' END,
     next_run_at = datetime(CURRENT_TIMESTAMP, ?),
     updated_at = CURRENT_TIMESTAMP
-WHERE id = ?;
-`, id, errStr, fmt.Sprintf("+%d seconds", int(delay.Seconds())), id)
-	return err
+WHERE id = ? AND state = 'running'`, fmt.Sprintf("+%d seconds", int(delay.Seconds())), id)
 }
 
 func (r *sqliteRepo) Succeed(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO task_attempts(task_id, success, error, finished_at) VALUES (?,1,'',CURRENT_TIMESTAMP);
-UPDATE tasks SET state='succeeded', updated_at=CURRENT_TIMESTAMP WHERE id=?;`, id, id)
-	return err
+	return r.finishAttempt(ctx, id, true, "", `
+UPDATE tasks SET state='succeeded', updated_at=CURRENT_TIMESTAMP WHERE id=? AND state='running'`, id)
 }
 
 func (r *sqliteRepo) Fail(ctx context.Context, id, errStr string, delay time.Duration) error {
 	// Hard fail: move to failed and stop
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO task_attempts(task_id, success, error, finished_at) VALUES (?,0,?,CURRENT_TIMESTAMP);
-UPDATE tasks SET state='failed', updated_at=CURRENT_TIMESTAMP WHERE id=?;`, id, errStr, id)
-	return err
+	return r.finishAttempt(ctx, id, false, errStr, `
+UPDATE tasks SET state='failed', attempts=attempts+1, updated_at=CURRENT_TIMESTAMP WHERE id=? AND state='running'`, id)
+}
+
+// finishAttempt records an attempt row and applies the task update in one
+// transaction. The update only touches running tasks, so a task canceled
+// while its handler was executing stays canceled.
+func (r *sqliteRepo) finishAttempt(ctx context.Context, id string, success bool, errStr, update string, args ...any) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO task_attempts(task_id, success, error, finished_at) VALUES (?,?,?,CURRENT_TIMESTAMP)`, id, success, errStr); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, update, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (r *sqliteRepo) RecoverStale(ctx context.Context, now time.Time) (int, error) {
@@ -208,23 +678,14 @@ WHERE state='running' AND strftime('%s','now') - strftime('%s',updated_at) > vis
 
 func (r *sqliteRepo) Get(ctx context.Context, id string) (domain.Task, error) {
 	row := r.db.QueryRowContext(ctx, `
-SELECT id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,created_at,updated_at
+SELECT `+taskColumns+`
 FROM tasks WHERE id=?`, id)
-	var t domain.Task
-	var idem sql.NullString
-	if err := row.Scan(&t.ID, &t.Type, &t.Payload, &t.Priority, &t.Attempts, &t.MaxAttempts, &t.State, &t.NextRunAt, &t.VisibilityTimeout, &idem, &t.CreatedAt, &t.UpdatedAt); err != nil {
-		return domain.Task{}, err
-	}
-	if idem.Valid {
-		s := idem.String
-		t.IdempotencyKey = &s
-	}
-	return t, nil
+	return scanTask(row)
 }
 
 func (r *sqliteRepo) ListRecentTasks(ctx context.Context, limit int) ([]domain.Task, error) {
 	rows, err := r.db.QueryContext(ctx, `
-SELECT id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,created_at,updated_at
+SELECT `+taskColumns+`
 FROM tasks ORDER BY created_at DESC LIMIT ?`, limit)
 	if err != nil {
 		return nil, err
@@ -233,116 +694,940 @@ FROM tasks ORDER BY created_at DESC LIMIT ?`, limit)
 
 	var tasks []domain.Task
 	for rows.Next() {
-		var t domain.Task
-		var idem sql.NullString
-		if err := rows.Scan(&t.ID, &t.Type, &t.Payload, &t.Priority, &t.Attempts, &t.MaxAttempts, &t.State, &t.NextRunAt, &t.VisibilityTimeout, &idem, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			continue
 		}
-		if idem.Valid {
-			s := idem.String
-			t.IdempotencyKey = &s
-		}
 		tasks = append(tasks, t)
 	}
 	return tasks, rows.Err()
 }
 
-func (r *sqliteRepo) CreateSchedule(ctx context.Context, s domain.Schedule) (string, error) {
-	id := s.ID
-	if id == "" {
-		id = "sch_" + uuid.NewString()
+func (r *sqliteRepo) ListTasks(ctx context.Context, f TaskFilter) ([]domain.Task, error) {
+	if f.Limit <= 0 {
+		f.Limit = 50
 	}
-	if s.Priority == 0 {
-		s.Priority = 5
-	}
-	if s.MaxAttempts == 0 {
-		s.MaxAttempts = 5
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+taskColumns+`
+FROM tasks
+WHERE (? = '' OR state = ?) AND (? = '' OR type = ?) AND (? = '' OR schedule_id = ?)
+ORDER BY created_at DESC LIMIT ?`, f.State, f.State, f.Type, f.Type, f.ScheduleID, f.ScheduleID, f.Limit)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	_, err := r.db.ExecContext(ctx, `
-INSERT INTO schedules (id,name,cron_expr,task_type,payload,priority,max_attempts,enabled,last_run,next_run,created_at,updated_at)
-VALUES (?,?,?,?,?,?,?,?,?,?,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
-`, id, s.Name, s.CronExpr, s.TaskType, s.Payload, s.Priority, s.MaxAttempts, s.Enabled, s.LastRun, s.NextRun)
-	return id, err
+	var tasks []domain.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
 }
 
-func (r *sqliteRepo) GetSchedule(ctx context.Context, id string) (domain.Schedule, error) {
-	row := r.db.QueryRowContext(ctx, `
-SELECT id,name,cron_expr,task_type,payload,priority,max_attempts,enabled,last_run,next_run,created_at,updated_at
-FROM schedules WHERE id=?`, id)
-	var s domain.Schedule
-	var lastRun sql.NullTime
-	if err := row.Scan(&s.ID, &s.Name, &s.CronExpr, &s.TaskType, &s.Payload, &s.Priority, &s.MaxAttempts, &s.Enabled, &lastRun, &s.NextRun, &s.CreatedAt, &s.UpdatedAt); err != nil {
-		return domain.Schedule{}, err
+func (r *sqliteRepo) TasksAfter(ctx context.Context, states []string, afterID string, limit int) ([]domain.Task, error) {
+	if len(states) == 0 {
+		return nil, nil
 	}
-	if lastRun.Valid {
-		s.LastRun = &lastRun.Time
+	args := []any{afterID}
+	for _, s := range states {
+		args = append(args, s)
 	}
-	return s, nil
+	args = append(args, limit)
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+taskColumns+`
+FROM tasks
+WHERE id > ? AND state IN (?`+strings.Repeat(",?", len(states)-1)+`)
+ORDER BY id LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []domain.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
 }
 
-func (r *sqliteRepo) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+func (r *sqliteRepo) ListTaskAttempts(ctx context.Context, taskID string) ([]domain.TaskAttempt, error) {
 	rows, err := r.db.QueryContext(ctx, `
-SELECT id,name,cron_expr,task_type,payload,priority,max_attempts,enabled,last_run,next_run,created_at,updated_at
-FROM schedules ORDER BY name`)
+SELECT id,task_id,started_at,finished_at,success,COALESCE(error,'')
+FROM task_attempts WHERE task_id=? ORDER BY id`, taskID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var schedules []domain.Schedule
+	var attempts []domain.TaskAttempt
 	for rows.Next() {
-		var s domain.Schedule
-		var lastRun sql.NullTime
-		if err := rows.Scan(&s.ID, &s.Name, &s.CronExpr, &s.TaskType, &s.Payload, &s.Priority, &s.MaxAttempts, &s.Enabled, &lastRun, &s.NextRun, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		var a domain.TaskAttempt
+		var finished sql.NullTime
+		if err := rows.Scan(&a.ID, &a.TaskID, &a.StartedAt, &finished, &a.Success, &a.Error); err != nil {
 			return nil, err
 		}
-		if lastRun.Valid {
-			s.LastRun = &lastRun.Time
+		if finished.Valid {
+			a.FinishedAt = &finished.Time
 		}
-		schedules = append(schedules, s)
+		attempts = append(attempts, a)
 	}
-	return schedules, rows.Err()
+	return attempts, rows.Err()
 }
 
-func (r *sqliteRepo) UpdateSchedule(ctx context.Context, s domain.Schedule) error {
-	_, err := r.db.ExecContext(ctx, `
-UPDATE schedules SET name=?,cron_expr=?,task_type=?,payload=?,priority=?,max_attempts=?,enabled=?,next_run=?,updated_at=CURRENT_TIMESTAMP
-WHERE id=?`, s.Name, s.CronExpr, s.TaskType, s.Payload, s.Priority, s.MaxAttempts, s.Enabled, s.NextRun, s.ID)
-	return err
-}
+func (r *sqliteRepo) ImportTask(ctx context.Context, t domain.Task, attempts []domain.TaskAttempt, replace bool) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-func (r *sqliteRepo) DeleteSchedule(ctx context.Context, id string) error {
-	_, err := r.db.ExecContext(ctx, "DELETE FROM schedules WHERE id=?", id)
-	return err
+	if t.IdempotencyKey != nil {
+		var other string
+		err := tx.QueryRowContext(ctx, "SELECT id FROM tasks WHERE idempotency_key=? AND id<>?", *t.IdempotencyKey, t.ID).Scan(&other)
+		if err == nil {
+			return fmt.Errorf("%w (%s)", ErrKeyConflict, other)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+	if replace {
+		for _, q := range []string{"DELETE FROM task_attempts WHERE task_id=?", "DELETE FROM task_logs WHERE task_id=?", "DELETE FROM tasks WHERE id=?"} {
+			if _, err := tx.ExecContext(ctx, q, t.ID); err != nil {
+				return err
+			}
+		}
+	}
+	var progress any
+	if t.Progress != nil {
+		progress = *t.Progress
+	}
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO tasks (`+taskColumns+`)
+VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`, t.ID, t.Type, t.Payload, t.Priority, t.Attempts, t.MaxAttempts, t.State, sqliteTime(t.NextRunAt),
+		t.VisibilityTimeout, t.IdempotencyKey, progress, nullString(t.StatusMessage), nullString(t.ScheduleID), sqliteTime(t.CreatedAt), sqliteTime(t.UpdatedAt), nullTime(t.ExpiresAt), nullString(t.UniqueKey)); err != nil {
+		return err
+	}
+	for _, a := range attempts {
+		var finished any
+		if a.FinishedAt != nil {
+			finished = sqliteTime(*a.FinishedAt)
+		}
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO task_attempts (task_id,started_at,finished_at,success,error) VALUES (?,?,?,?,?)`,
+			t.ID, sqliteTime(a.StartedAt), finished, a.Success, nullString(a.Error)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (r *sqliteRepo) GetDueSchedules(ctx context.Context, now time.Time) ([]domain.Schedule, error) {
-	rows, err := r.db.QueryContext(ctx, `
-SELECT id,name,cron_expr,task_type,payload,priority,max_attempts,enabled,last_run,next_run,created_at,updated_at
-FROM schedules WHERE enabled=1 AND next_run <= ? ORDER BY next_run`, now)
+// PrunableTasks selects on state and updated_at, the time a task
+// finished.
+func (r *sqliteRepo) PrunableTasks(ctx context.Context, f PruneFilter) ([]domain.Task, error) {
+	query := `
+SELECT ` + taskColumns + `
+FROM tasks
+WHERE state = ? AND updated_at < ?`
+	args := []any{f.State, sqliteTime(f.Before)}
+	if f.Type != "" {
+		query += " AND type = ?"
+		args = append(args, f.Type)
+	}
+	if len(f.ExcludeTypes) > 0 {
+		query += " AND type NOT IN (?" + strings.Repeat(",?", len(f.ExcludeTypes)-1) + ")"
+		for _, t := range f.ExcludeTypes {
+			args = append(args, t)
+		}
+	}
+	query += " ORDER BY updated_at LIMIT ?"
+	args = append(args, f.Limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var schedules []domain.Schedule
+	var tasks []domain.Task
 	for rows.Next() {
-		var s domain.Schedule
-		var lastRun sql.NullTime
-		if err := rows.Scan(&s.ID, &s.Name, &s.CronExpr, &s.TaskType, &s.Payload, &s.Priority, &s.MaxAttempts, &s.Enabled, &lastRun, &s.NextRun, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		t, err := scanTask(rows)
+		if err != nil {
 			return nil, err
 		}
-		if lastRun.Valid {
-			s.LastRun = &lastRun.Time
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// DeleteFinishedTasks skips tasks retried since they were selected.
+func (r *sqliteRepo) DeleteFinishedTasks(ctx context.Context, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	finished := `SELECT id FROM tasks WHERE id IN (?` + strings.Repeat(",?", len(ids)-1) + `) AND state IN ('succeeded','failed','canceled','expired')`
+	for _, table := range []string{"task_attempts", "task_logs"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE task_id IN (`+finished+`)`, args...); err != nil {
+			return 0, err
 		}
-		schedules = append(schedules, s)
 	}
-	return schedules, rows.Err()
+	res, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE id IN (`+finished+`)`, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), tx.Commit()
 }
 
-func (r *sqliteRepo) UpdateScheduleLastRun(ctx context.Context, id string, lastRun, nextRun time.Time) error {
-	_, err := r.db.ExecContext(ctx, `
-UPDATE schedules SET last_run=?,next_run=?,updated_at=CURRENT_TIMESTAMP WHERE id=?`, lastRun, nextRun, id)
-	return err
+func (r *sqliteRepo) ActiveScheduleTasks(ctx context.Context, scheduleID string) ([]domain.Task, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+taskColumns+`
+FROM tasks
+WHERE schedule_id = ? AND state IN ('queued','running')
+ORDER BY created_at`, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []domain.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// Cancel moves a queued or running task to canceled. Workers finishing a
+// canceled task leave its state untouched.
+func (r *sqliteRepo) Cancel(ctx context.Context, id string) error {
+	return r.transition(ctx, id, `
+UPDATE tasks SET state='canceled', updated_at=CURRENT_TIMESTAMP
+WHERE id=? AND state IN ('queued','running')`)
+}
+
+// Requeue puts a failed, canceled or expired task back in the queue with a
+// fresh attempt budget and no expiry.
+func (r *sqliteRepo) Requeue(ctx context.Context, id string) error {
+	return r.transition(ctx, id, `
+UPDATE tasks SET state='queued', attempts=0, next_run_at=CURRENT_TIMESTAMP, expires_at=NULL, updated_at=CURRENT_TIMESTAMP
+WHERE id=? AND state IN ('failed','canceled','expired')`)
+}
+
+// transition runs a guarded state update and distinguishes a missing task
+// (sql.ErrNoRows) from one in the wrong state (ErrInvalidState).
+func (r *sqliteRepo) transition(ctx context.Context, id, query string) error {
+	res, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	if _, err := r.Get(ctx, id); err != nil {
+		return err
+	}
+	return ErrInvalidState
+}
+
+// SetTaskProgress records handler-reported progress on a running task. A
+// nil progress keeps the previous value.
+func (r *sqliteRepo) SetTaskProgress(ctx context.Context, id string, progress *float64, message string) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE tasks SET progress=COALESCE(?, progress), status_message=? WHERE id=? AND state='running'`, progress, message, id)
+	return err
+}
+
+func (r *sqliteRepo) CountTasksByState(ctx context.Context) (map[string]int, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT state, COUNT(*) FROM tasks GROUP BY state`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		var state string
+		var n int
+		if err := rows.Scan(&state, &n); err != nil {
+			return nil, err
+		}
+		counts[state] = n
+	}
+	return counts, rows.Err()
+}
+
+// AppendTaskLogs writes a batch of log lines in one transaction.
+func (r *sqliteRepo) AppendTaskLogs(ctx context.Context, lines []domain.TaskLog) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `
+INSERT INTO task_logs (task_id,attempt,stream,line,created_at) VALUES (?,?,?,?,?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, l := range lines {
+		if _, err := stmt.ExecContext(ctx, l.TaskID, l.Attempt, l.Stream, l.Line, l.CreatedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListTaskLogs returns up to limit log lines of a task with id > afterID,
+// oldest first. Callers page through by passing the last id seen.
+func (r *sqliteRepo) ListTaskLogs(ctx context.Context, taskID string, afterID int64, limit int) ([]domain.TaskLog, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id,task_id,attempt,stream,line,created_at FROM task_logs
+WHERE task_id=? AND id>? ORDER BY id LIMIT ?`, taskID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []domain.TaskLog
+	for rows.Next() {
+		var l domain.TaskLog
+		if err := rows.Scan(&l.ID, &l.TaskID, &l.Attempt, &l.Stream, &l.Line, &l.CreatedAt); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// NewScheduleID returns an ID for a schedule that isn't created yet, for
+// callers that need it beforehand, e.g. to compute its jitter offset.
+func NewScheduleID() string {
+	return "sch_" + uuid.NewString()
+}
+
+func (r *sqliteRepo) CreateSchedule(ctx context.Context, s domain.Schedule) (string, error) {
+	return r.createSchedule(ctx, r.db, s)
+}
+
+func (r *sqliteRepo) createSchedule(ctx context.Context, q querier, s domain.Schedule) (string, error) {
+	id := s.ID
+	if id == "" {
+		id = NewScheduleID()
+	}
+	if s.Priority == 0 {
+		s.Priority = r.defaults.Priority
+	}
+	if s.MaxAttempts == 0 {
+		s.MaxAttempts = r.defaults.maxAttempts(s.TaskType)
+	}
+
+	_, err := q.ExecContext(ctx, `
+INSERT INTO schedules (id,name,cron_expr,timezone,task_type,payload,priority,max_attempts,enabled,last_run,next_run,run_count,misfire_policy,misfire_grace,max_catchup,concurrency_policy,start_at,end_at,exclude_calendars,jitter,source,created_at,updated_at)
+VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+`, id, s.Name, s.CronExpr, s.Timezone, s.TaskType, s.Payload, s.Priority, s.MaxAttempts, s.Enabled, utcPtr(s.LastRun), s.NextRun.UTC(), s.RunCount,
+		s.MisfirePolicy, s.MisfireGrace, s.MaxCatchup, s.ConcurrencyPolicy, utcPtr(s.StartAt), utcPtr(s.EndAt), strings.Join(s.ExcludeCalendars, ","), s.Jitter, s.Source)
+	return id, err
+}
+
+func (r *sqliteRepo) GetSchedule(ctx context.Context, id string) (domain.Schedule, error) {
+	row := r.db.QueryRowContext(ctx, `
+SELECT `+scheduleColumns+`
+FROM schedules WHERE id=?`, id)
+	return scanSchedule(row)
+}
+
+func (r *sqliteRepo) ListSchedules(ctx context.Context) ([]domain.Schedule, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+scheduleColumns+`
+FROM schedules ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []domain.Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+func (r *sqliteRepo) UpdateSchedule(ctx context.Context, s domain.Schedule) error {
+	return updateSchedule(ctx, r.db, s)
+}
+
+func updateSchedule(ctx context.Context, q querier, s domain.Schedule) error {
+	_, err := q.ExecContext(ctx, `
+UPDATE schedules SET name=?,cron_expr=?,timezone=?,task_type=?,payload=?,priority=?,max_attempts=?,enabled=?,next_run=?,
+  misfire_policy=?,misfire_grace=?,max_catchup=?,concurrency_policy=?,start_at=?,end_at=?,exclude_calendars=?,jitter=?,source=?,updated_at=CURRENT_TIMESTAMP
+WHERE id=?`, s.Name, s.CronExpr, s.Timezone, s.TaskType, s.Payload, s.Priority, s.MaxAttempts, s.Enabled, s.NextRun.UTC(),
+		s.MisfirePolicy, s.MisfireGrace, s.MaxCatchup, s.ConcurrencyPolicy, utcPtr(s.StartAt), utcPtr(s.EndAt), strings.Join(s.ExcludeCalendars, ","), s.Jitter, s.Source, s.ID)
+	return err
+}
+
+func (r *sqliteRepo) SaveSchedules(ctx context.Context, creates, updates []domain.Schedule) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, s := range creates {
+		if _, err := r.createSchedule(ctx, tx, s); err != nil {
+			return err
+		}
+	}
+	for _, s := range updates {
+		if err := updateSchedule(ctx, tx, s); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqliteRepo) DeleteSchedule(ctx context.Context, id string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM schedule_misfires WHERE schedule_id=?", id); err != nil {
+		return err
+	}
+	_, err := r.db.ExecContext(ctx, "DELETE FROM schedules WHERE id=?", id)
+	return err
+}
+
+func (r *sqliteRepo) GetDueSchedules(ctx context.Context, now time.Time) ([]domain.Schedule, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+scheduleColumns+`
+FROM schedules WHERE enabled=1 AND next_run <= ? ORDER BY next_run`, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schedules []domain.Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+func (r *sqliteRepo) UpdateScheduleLastRun(ctx context.Context, id string, lastRun, nextRun time.Time, newRun bool) error {
+	count := 0
+	if newRun {
+		count = 1
+	}
+	_, err := r.db.ExecContext(ctx, `
+UPDATE schedules SET last_run=?,next_run=?,run_count=run_count+?,updated_at=CURRENT_TIMESTAMP WHERE id=?`, lastRun.UTC(), nextRun.UTC(), count, id)
+	return err
+}
+
+func (r *sqliteRepo) CountScheduleRun(ctx context.Context, id string, lastRun time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+UPDATE schedules SET last_run=?,run_count=run_count+1,updated_at=CURRENT_TIMESTAMP WHERE id=?`, lastRun.UTC(), id)
+	return err
+}
+
+func (r *sqliteRepo) SkipScheduleRuns(ctx context.Context, id string, fires []time.Time, reason string, nextRun time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, f := range fires {
+		if _, err := tx.ExecContext(ctx, `
+INSERT INTO schedule_misfires (schedule_id,fire_time,reason,created_at) VALUES (?,?,?,CURRENT_TIMESTAMP)`, id, f.UTC(), reason); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+UPDATE schedules SET next_run=?,updated_at=CURRENT_TIMESTAMP WHERE id=?`, nextRun.UTC(), id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListScheduleMisfires returns a schedule's most recent misfires first.
+func (r *sqliteRepo) DeleteScheduleMisfires(ctx context.Context, before time.Time, limit int) (int, error) {
+	res, err := r.db.ExecContext(ctx, `
+DELETE FROM schedule_misfires WHERE id IN (
+  SELECT id FROM schedule_misfires WHERE created_at < ? ORDER BY id LIMIT ?
+)`, sqliteTime(before), limit)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+func (r *sqliteRepo) ListScheduleMisfires(ctx context.Context, id string, limit int) ([]domain.ScheduleMisfire, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id,schedule_id,fire_time,reason,created_at
+FROM schedule_misfires WHERE schedule_id=? ORDER BY id DESC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var misfires []domain.ScheduleMisfire
+	for rows.Next() {
+		var m domain.ScheduleMisfire
+		if err := rows.Scan(&m.ID, &m.ScheduleID, &m.FireTime, &m.Reason, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		misfires = append(misfires, m)
+	}
+	return misfires, rows.Err()
+}
+
+// ListScheduleRuns returns the tasks a schedule enqueued, newest first.
+func (r *sqliteRepo) ListScheduleRuns(ctx context.Context, id string, limit int) ([]domain.ScheduleRun, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+taskColumns+`,
+  (SELECT error FROM task_attempts a WHERE a.task_id = tasks.id ORDER BY a.id DESC LIMIT 1)
+FROM tasks WHERE schedule_id=? ORDER BY created_at DESC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []domain.ScheduleRun
+	for rows.Next() {
+		var errStr sql.NullString
+		t, err := scanTask(extraScanner{rows, []any{&errStr}})
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, domain.ScheduleRun{Task: t, Error: errStr.String})
+	}
+	return runs, rows.Err()
+}
+
+func (r *sqliteRepo) RecentScheduleRuns(ctx context.Context, limit int) (map[string][]domain.ScheduleRun, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := r.db.QueryContext(ctx, `
+SELECT `+taskColumns+`,
+  (SELECT error FROM task_attempts a WHERE a.task_id = tasks.id ORDER BY a.id DESC LIMIT 1)
+FROM (
+  SELECT *, ROW_NUMBER() OVER (PARTITION BY schedule_id ORDER BY created_at DESC) AS run
+  FROM tasks WHERE schedule_id IS NOT NULL AND schedule_id != ''
+) tasks
+WHERE run <= ? ORDER BY schedule_id, run`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make(map[string][]domain.ScheduleRun)
+	for rows.Next() {
+		var errStr sql.NullString
+		t, err := scanTask(extraScanner{rows, []any{&errStr}})
+		if err != nil {
+			return nil, err
+		}
+		runs[t.ScheduleID] = append(runs[t.ScheduleID], domain.ScheduleRun{Task: t, Error: errStr.String})
+	}
+	return runs, rows.Err()
+}
+
+func (r *sqliteRepo) PutCalendar(ctx context.Context, c domain.Calendar) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `
+INSERT INTO calendars (name,description,created_at,updated_at) VALUES (?,?,CURRENT_TIMESTAMP,CURRENT_TIMESTAMP)
+ON CONFLICT(name) DO UPDATE SET description=excluded.description, updated_at=CURRENT_TIMESTAMP`, c.Name, c.Description); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM calendar_dates WHERE calendar=?", c.Name); err != nil {
+		return err
+	}
+	for _, d := range c.Dates {
+		if _, err := tx.ExecContext(ctx, "INSERT OR IGNORE INTO calendar_dates (calendar,date) VALUES (?,?)", c.Name, d); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqliteRepo) GetCalendar(ctx context.Context, name string) (domain.Calendar, error) {
+	var c domain.Calendar
+	if err := r.db.QueryRowContext(ctx, `
+SELECT name,description,created_at,updated_at FROM calendars WHERE name=?`, name).Scan(&c.Name, &c.Description, &c.CreatedAt, &c.UpdatedAt); err != nil {
+		return domain.Calendar{}, err
+	}
+	rows, err := r.db.QueryContext(ctx, "SELECT date FROM calendar_dates WHERE calendar=? ORDER BY date", name)
+	if err != nil {
+		return domain.Calendar{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return domain.Calendar{}, err
+		}
+		c.Dates = append(c.Dates, d)
+	}
+	return c, rows.Err()
+}
+
+// ListCalendars returns all calendars without their dates.
+func (r *sqliteRepo) ListCalendars(ctx context.Context) ([]domain.Calendar, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT name,description,created_at,updated_at FROM calendars ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calendars []domain.Calendar
+	for rows.Next() {
+		var c domain.Calendar
+		if err := rows.Scan(&c.Name, &c.Description, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, c)
+	}
+	return calendars, rows.Err()
+}
+
+// DeleteCalendar removes a calendar and its dates, returning sql.ErrNoRows
+// if it doesn't exist.
+func (r *sqliteRepo) DeleteCalendar(ctx context.Context, name string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM calendar_dates WHERE calendar=?", name); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "DELETE FROM calendars WHERE name=?", name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// AcquireLease takes the named lease for owner, or renews it if owner
+// already holds it, until now+ttl. It reports false while another owner
+// holds an unexpired lease.
+func (r *sqliteRepo) AcquireLease(ctx context.Context, name, owner string, ttl time.Duration, now time.Time) (bool, error) {
+	now = now.UTC()
+	res, err := r.db.ExecContext(ctx, `
+INSERT INTO leases (name,owner,expires_at) VALUES (?,?,?)
+ON CONFLICT(name) DO UPDATE SET owner=excluded.owner, expires_at=excluded.expires_at
+WHERE leases.owner=excluded.owner OR leases.expires_at < ?`, name, owner, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseLease gives up the named lease if owner holds it, so another
+// process can take over without waiting for it to expire.
+func (r *sqliteRepo) ReleaseLease(ctx context.Context, name, owner string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM leases WHERE name=? AND owner=?", name, owner)
+	return err
+}
+
+func (r *sqliteRepo) CreateAPIKey(ctx context.Context, k domain.APIKey) (string, error) {
+	id := k.ID
+	if id == "" {
+		id = "key_" + uuid.NewString()
+	}
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO api_keys (id,name,key_hash,scopes,created_at) VALUES (?,?,?,?,CURRENT_TIMESTAMP)`,
+		id, k.Name, k.KeyHash, strings.Join(k.Scopes, ","))
+	return id, err
+}
+
+// GetAPIKeyByHash returns the key with the given hash, including revoked keys;
+// callers check RevokedAt.
+func (r *sqliteRepo) GetAPIKeyByHash(ctx context.Context, hash string) (domain.APIKey, error) {
+	row := r.db.QueryRowContext(ctx, `
+SELECT id,name,key_hash,scopes,created_at,revoked_at FROM api_keys WHERE key_hash=?`, hash)
+	return scanAPIKey(row)
+}
+
+func (r *sqliteRepo) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, `
+SELECT id,name,key_hash,scopes,created_at,revoked_at FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []domain.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks a key revoked and drops its dashboard sessions.
+func (r *sqliteRepo) RevokeAPIKey(ctx context.Context, id string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+UPDATE api_keys SET revoked_at=CURRENT_TIMESTAMP WHERE id=? AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM sessions WHERE key_id=?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqliteRepo) CreateSession(ctx context.Context, tokenHash, keyID string, expiresAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO sessions (token_hash,key_id,expires_at,created_at) VALUES (?,?,?,CURRENT_TIMESTAMP)`,
+		tokenHash, keyID, expiresAt.UTC())
+	return err
+}
+
+// GetSessionKey resolves an unexpired session to its (unrevoked) API key.
+func (r *sqliteRepo) GetSessionKey(ctx context.Context, tokenHash string, now time.Time) (domain.APIKey, error) {
+	row := r.db.QueryRowContext(ctx, `
+SELECT k.id,k.name,k.key_hash,k.scopes,k.created_at,k.revoked_at
+FROM sessions s JOIN api_keys k ON k.id = s.key_id
+WHERE s.token_hash=? AND s.expires_at > ? AND k.revoked_at IS NULL`, tokenHash, now.UTC())
+	return scanAPIKey(row)
+}
+
+func (r *sqliteRepo) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash=?`, tokenHash)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (r *sqliteRepo) PutSecret(ctx context.Context, name string, value []byte) error {
+	_, err := r.db.ExecContext(ctx, `
+INSERT INTO secrets(name, value) VALUES (?,?)
+ON CONFLICT(name) DO UPDATE SET value=excluded.value, updated_at=CURRENT_TIMESTAMP`, name, value)
+	return err
+}
+
+func (r *sqliteRepo) GetSecret(ctx context.Context, name string) (domain.Secret, error) {
+	var s domain.Secret
+	err := r.db.QueryRowContext(ctx, `SELECT name,value,created_at,updated_at FROM secrets WHERE name=?`, name).
+		Scan(&s.Name, &s.Value, &s.CreatedAt, &s.UpdatedAt)
+	return s, err
+}
+
+// ListSecrets returns secret metadata; values are not loaded.
+func (r *sqliteRepo) ListSecrets(ctx context.Context) ([]domain.Secret, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT name,created_at,updated_at FROM secrets ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var list []domain.Secret
+	for rows.Next() {
+		var s domain.Secret
+		if err := rows.Scan(&s.Name, &s.CreatedAt, &s.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+func (r *sqliteRepo) DeleteSecret(ctx context.Context, name string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM secrets WHERE name=?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// vacuumPages is how many free pages one incremental_vacuum statement
+// returns, about 4MB with the default page size.
+const vacuumPages = 1000
+
+// Optimize avoids VACUUM, which would hold the pool's only connection for
+// as long as rewriting the whole file takes. With incremental auto-vacuum
+// it returns free pages a chunk at a time, so other queries run in
+// between; databases created before that was the default keep their free
+// pages for reuse. PRAGMA optimize then analyzes only the tables whose
+// statistics are stale.
+func (r *sqliteRepo) Optimize(ctx context.Context) error {
+	var mode int
+	if err := r.db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	for mode == 2 { // incremental
+		var free int
+		if err := r.db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil {
+			return err
+		}
+		if free == 0 {
+			break
+		}
+		if _, err := r.db.ExecContext(ctx, fmt.Sprintf("PRAGMA incremental_vacuum(%d)", vacuumPages)); err != nil {
+			return err
+		}
+	}
+	_, err := r.db.ExecContext(ctx, "PRAGMA optimize")
+	return err
+}
+
+// Backup uses VACUUM INTO, which copies a read snapshot and so never
+// produces a torn file. It runs on a read-only connection of its own, as
+// the pool's only one would otherwise be held for the whole copy; in WAL
+// mode the snapshot doesn't block writers. The connection has a private
+// cache, since a shared one contends for table locks with the pool.
+// In-memory databases have no file to open again and are copied on the
+// pool's connection.
+func (r *sqliteRepo) Backup(ctx context.Context, path string) error {
+	if r.file == "" {
+		_, err := r.db.ExecContext(ctx, "VACUUM INTO ?", path)
+		return err
+	}
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", r.file))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// extraScanner scans columns selected after a standard column list.
+type extraScanner struct {
+	row   rowScanner
+	extra []any
+}
+
+func (e extraScanner) Scan(dest ...any) error {
+	return e.row.Scan(append(dest, e.extra...)...)
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// sqliteTime formats t like CURRENT_TIMESTAMP, the format task times are
+// stored in and that SQLite's date functions parse.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// nullTime formats an optional task time, nil as NULL.
+func nullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return sqliteTime(*t)
+}
+
+// Schedule times are stored in UTC so that next_run compares correctly as
+// text regardless of the server's zone.
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+const scheduleColumns = `id,name,cron_expr,timezone,task_type,payload,priority,max_attempts,enabled,last_run,next_run,run_count,misfire_policy,misfire_grace,max_catchup,concurrency_policy,start_at,end_at,exclude_calendars,jitter,source,created_at,updated_at`
+
+func scanSchedule(row rowScanner) (domain.Schedule, error) {
+	var s domain.Schedule
+	var lastRun, startAt, endAt sql.NullTime
+	var calendars string
+	if err := row.Scan(&s.ID, &s.Name, &s.CronExpr, &s.Timezone, &s.TaskType, &s.Payload, &s.Priority, &s.MaxAttempts, &s.Enabled, &lastRun, &s.NextRun, &s.RunCount,
+		&s.MisfirePolicy, &s.MisfireGrace, &s.MaxCatchup, &s.ConcurrencyPolicy, &startAt, &endAt, &calendars, &s.Jitter, &s.Source, &s.CreatedAt, &s.UpdatedAt); err != nil {
+		return domain.Schedule{}, err
+	}
+	if lastRun.Valid {
+		s.LastRun = &lastRun.Time
+	}
+	if startAt.Valid {
+		s.StartAt = &startAt.Time
+	}
+	if endAt.Valid {
+		s.EndAt = &endAt.Time
+	}
+	if calendars != "" {
+		s.ExcludeCalendars = strings.Split(calendars, ",")
+	}
+	return s, nil
+}
+
+func scanAPIKey(row rowScanner) (domain.APIKey, error) {
+	var k domain.APIKey
+	var scopes string
+	var revokedAt sql.NullTime
+	if err := row.Scan(&k.ID, &k.Name, &k.KeyHash, &scopes, &k.CreatedAt, &revokedAt); err != nil {
+		return domain.APIKey{}, err
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return k, nil
 }
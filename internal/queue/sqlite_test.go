@@ -0,0 +1,420 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+	_ "modernc.org/sqlite"
+)
+
+// newTestRepo returns a repository on a fresh database file.
+func newTestRepo(t *testing.T) (*sqliteRepo, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=rwc&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return NewSQLiteRepo(db).(*sqliteRepo), db
+}
+
+// setCreated backdates a task so tests don't depend on CURRENT_TIMESTAMP's
+// one second resolution.
+func setCreated(t *testing.T, db *sql.DB, id string, created time.Time) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE tasks SET created_at=? WHERE id=?", sqliteTime(created), id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecentScheduleRuns(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestRepo(t)
+	base := time.Now().Add(-time.Hour).UTC()
+
+	ids := map[string][]string{}
+	for i, schedule := range []string{"a", "b", "a", "a", "", "b", "a"} {
+		id, err := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`), ScheduleID: schedule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		setCreated(t, db, id, base.Add(time.Duration(i)*time.Minute))
+		ids[schedule] = append([]string{id}, ids[schedule]...) // newest first
+	}
+
+	runs, err := repo.RecentScheduleRuns(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("runs for %d schedules, want 2 (ad-hoc tasks excluded)", len(runs))
+	}
+	for schedule, want := range map[string][]string{"a": ids["a"][:3], "b": ids["b"]} {
+		got := runs[schedule]
+		if len(got) != len(want) {
+			t.Fatalf("schedule %s: %d runs, want %d", schedule, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Task.ID != want[i] {
+				t.Errorf("schedule %s run %d = %s, want %s", schedule, i, got[i].Task.ID, want[i])
+			}
+		}
+		// The grouped query returns what the per-schedule one does.
+		single, err := repo.ListScheduleRuns(ctx, schedule, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range single {
+			if single[i].Task.ID != got[i].Task.ID {
+				t.Errorf("schedule %s run %d differs from ListScheduleRuns", schedule, i)
+			}
+		}
+	}
+}
+
+func TestSaveSchedulesRollsBack(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRepo(t)
+	existing := domain.Schedule{ID: "sch_1", Name: "a", CronExpr: "* * * * *", TaskType: "shell", Payload: []byte(`{}`)}
+	if _, err := repo.CreateSchedule(ctx, existing); err != nil {
+		t.Fatal(err)
+	}
+	renamed := existing
+	renamed.Name = "renamed"
+	created := domain.Schedule{ID: "sch_2", Name: "b", CronExpr: "* * * * *", TaskType: "shell", Payload: []byte(`{}`)}
+
+	// The second create reuses an ID, so nothing may be written.
+	err := repo.SaveSchedules(ctx, []domain.Schedule{created, created}, []domain.Schedule{renamed})
+	if err == nil {
+		t.Fatal("SaveSchedules succeeded with a duplicate ID")
+	}
+	all, err := repo.ListSchedules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Name != "a" {
+		t.Errorf("schedules after a failed save: %+v", all)
+	}
+
+	if err := repo.SaveSchedules(ctx, []domain.Schedule{created}, []domain.Schedule{renamed}); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ = repo.ListSchedules(ctx); len(all) != 2 {
+		t.Errorf("%d schedules after save, want 2", len(all))
+	}
+	if s, _ := repo.GetSchedule(ctx, "sch_1"); s.Name != "renamed" {
+		t.Errorf("update not saved: %+v", s)
+	}
+}
+
+func TestDeleteScheduleMisfires(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestRepo(t)
+	fires := make([]time.Time, 5)
+	for i := range fires {
+		fires[i] = time.Now().Add(time.Duration(-i) * time.Minute)
+	}
+	if err := repo.SkipScheduleRuns(ctx, "sch_1", fires, "misfire", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// The first three are old, the rest recent.
+	old := sqliteTime(time.Now().Add(-48 * time.Hour))
+	if _, err := db.Exec("UPDATE schedule_misfires SET created_at=? WHERE id<=3", old); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now().Add(-24 * time.Hour)
+	if n, err := repo.DeleteScheduleMisfires(ctx, before, 2); err != nil || n != 2 {
+		t.Fatalf("first batch deleted %d, %v; want 2", n, err)
+	}
+	if n, err := repo.DeleteScheduleMisfires(ctx, before, 2); err != nil || n != 1 {
+		t.Fatalf("second batch deleted %d, %v; want 1", n, err)
+	}
+	left, err := repo.ListScheduleMisfires(ctx, "sch_1", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 2 {
+		t.Errorf("%d misfires left, want the 2 recent ones", len(left))
+	}
+}
+
+// TestBackupOwnConnection takes a snapshot while a transaction holds the
+// pool's only connection, as a busy worker might.
+func TestBackupOwnConnection(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestRepo(t)
+	committed, err := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("UPDATE tasks SET priority=9 WHERE id=?", committed); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	timeout, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := repo.Backup(timeout, path); err != nil {
+		t.Fatalf("Backup while the connection is held: %v", err)
+	}
+	tx.Rollback()
+
+	snap, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Close()
+	var priority int
+	if err := snap.QueryRow("SELECT priority FROM tasks WHERE id=?", committed).Scan(&priority); err != nil {
+		t.Fatal(err)
+	}
+	if priority == 9 {
+		t.Error("snapshot holds an uncommitted write")
+	}
+}
+
+func TestOptimizeFreesPages(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newTestRepo(t)
+	var mode int
+	if err := db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil || mode != 2 {
+		t.Fatalf("auto_vacuum = %d, %v; want incremental", mode, err)
+	}
+	payload := []byte(fmt.Sprintf(`{"pad":%q}`, make([]byte, 8192)))
+	for i := 0; i < 500; i++ {
+		if _, err := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: payload}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("DELETE FROM tasks"); err != nil {
+		t.Fatal(err)
+	}
+	free := func() int {
+		var n int
+		if err := db.QueryRow("PRAGMA freelist_count").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// More than one chunk's worth.
+	if n := free(); n <= vacuumPages {
+		t.Fatalf("%d free pages after delete, want more than %d", n, vacuumPages)
+	}
+	if err := repo.Optimize(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := free(); n != 0 {
+		t.Errorf("%d free pages after Optimize, want 0", n)
+	}
+}
+
+func TestLeaseNextExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		want      string // state after LeaseNext at now
+	}{
+		{"no expiry", nil, "running"},
+		{"expires later", at(time.Minute), "running"},
+		{"expires now", at(0), "expired"},
+		{"expired", at(-time.Minute), "expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, _ := newTestRepo(t)
+			id, err := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`), ExpiresAt: tt.expiresAt})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leased, _, err := repo.LeaseNext(ctx, now)
+			if tt.want == "expired" {
+				if !errors.Is(err, ErrEmpty) {
+					t.Fatalf("LeaseNext = %s, %v; want ErrEmpty", leased.ID, err)
+				}
+			} else if err != nil || leased.ID != id {
+				t.Fatalf("LeaseNext = %s, %v; want %s", leased.ID, err, id)
+			}
+			// The expiry is kept although nothing was leased.
+			task, err := repo.Get(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if task.State != tt.want {
+				t.Errorf("state = %s, want %s", task.State, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaseNextExpiresOnlyQueued(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newTestRepo(t)
+	now := time.Now().UTC().Truncate(time.Second).Add(time.Hour)
+	deadline := now.Add(time.Minute)
+
+	running, _ := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`), Priority: 9, ExpiresAt: &deadline})
+	queued, _ := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`), Priority: 1, ExpiresAt: &deadline})
+	if task, _, err := repo.LeaseNext(ctx, now); err != nil || task.ID != running {
+		t.Fatalf("LeaseNext = %s, %v; want %s", task.ID, err, running)
+	}
+
+	// Past the deadline the queued task expires, the running one is left
+	// to finish.
+	later := deadline.Add(time.Second)
+	if _, _, err := repo.LeaseNext(ctx, later); !errors.Is(err, ErrEmpty) {
+		t.Fatalf("LeaseNext = %v, want ErrEmpty", err)
+	}
+	for id, want := range map[string]string{running: "running", queued: "expired"} {
+		if task, _ := repo.Get(ctx, id); task.State != want {
+			t.Errorf("task %s state = %s, want %s", id, task.State, want)
+		}
+	}
+
+	// Requeueing an expired task drops its deadline so it can run.
+	if err := repo.Requeue(ctx, queued); err != nil {
+		t.Fatal(err)
+	}
+	task, _, err := repo.LeaseNext(ctx, later)
+	if err != nil || task.ID != queued || task.ExpiresAt != nil {
+		t.Errorf("LeaseNext after requeue = %+v, %v", task, err)
+	}
+}
+
+func TestEnqueueUnique(t *testing.T) {
+	ctx := context.Background()
+	// prior describes a task submitted earlier with unique key "k".
+	type prior struct {
+		typ   string
+		state string
+		age   time.Duration
+	}
+	queued := &prior{"report", "queued", 0}
+	tests := []struct {
+		name      string
+		prior     *prior
+		unique    domain.Unique
+		existing  bool
+		duplicate bool   // ErrDuplicate
+		payload   string // of the prior task afterwards, if set
+	}{
+		{name: "no prior task", unique: domain.Unique{Key: "k", Mode: domain.UniqueActive}},
+
+		{name: "queued mode, queued", prior: queued,
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueQueued}, existing: true},
+		{name: "queued mode, running", prior: &prior{"report", "running", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueQueued}},
+		{name: "queued mode, succeeded", prior: &prior{"report", "succeeded", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueQueued}},
+
+		{name: "active mode, queued", prior: queued,
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive}, existing: true},
+		{name: "active mode, running", prior: &prior{"report", "running", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive}, existing: true},
+		{name: "active mode, failed", prior: &prior{"report", "failed", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive}},
+		{name: "active mode, other type", prior: &prior{"cleanup", "queued", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive}},
+		{name: "active mode, other key", prior: queued,
+			unique: domain.Unique{Key: "other", Mode: domain.UniqueActive}},
+
+		{name: "window mode, inside", prior: &prior{"report", "succeeded", 30 * time.Second},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueWindow, Window: time.Minute}, existing: true},
+		{name: "window mode, outside", prior: &prior{"report", "succeeded", 2 * time.Minute},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueWindow, Window: time.Minute}},
+
+		{name: "reject", prior: queued,
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive, OnConflict: domain.UniqueReject}, existing: true, duplicate: true,
+			payload: `{"n":1}`},
+		{name: "replace queued", prior: queued,
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive, OnConflict: domain.UniqueReplace}, existing: true,
+			payload: `{"n":2}`},
+		{name: "replace running", prior: &prior{"report", "running", 0},
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive, OnConflict: domain.UniqueReplace}, existing: true, duplicate: true,
+			payload: `{"n":1}`},
+		{name: "return existing keeps payload", prior: queued,
+			unique: domain.Unique{Key: "k", Mode: domain.UniqueActive, OnConflict: domain.UniqueReturnExisting}, existing: true,
+			payload: `{"n":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, db := newTestRepo(t)
+			var priorID string
+			if tt.prior != nil {
+				var err error
+				priorID, _, err = repo.EnqueueUnique(ctx, domain.Task{Type: tt.prior.typ, Payload: []byte(`{"n":1}`)},
+					domain.Unique{Key: "k", Mode: domain.UniqueActive})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec("UPDATE tasks SET state=? WHERE id=?", tt.prior.state, priorID); err != nil {
+					t.Fatal(err)
+				}
+				setCreated(t, db, priorID, time.Now().Add(-tt.prior.age))
+			}
+
+			id, existing, err := repo.EnqueueUnique(ctx, domain.Task{Type: "report", Payload: []byte(`{"n":2}`)}, tt.unique)
+			if tt.duplicate != errors.Is(err, ErrDuplicate) || (!tt.duplicate && err != nil) {
+				t.Fatalf("EnqueueUnique error = %v, want duplicate %v", err, tt.duplicate)
+			}
+			if existing != tt.existing {
+				t.Errorf("existing = %v, want %v", existing, tt.existing)
+			}
+			if tt.existing && id != priorID {
+				t.Errorf("returned %s, want the prior task %s", id, priorID)
+			}
+			if !tt.existing {
+				task, err := repo.Get(ctx, id)
+				if err != nil || id == priorID || task.UniqueKey != tt.unique.Key || task.State != "queued" {
+					t.Errorf("new task %+v, %v", task, err)
+				}
+			}
+			if tt.payload != "" {
+				if task, _ := repo.Get(ctx, priorID); string(task.Payload) != tt.payload {
+					t.Errorf("prior payload = %s, want %s", task.Payload, tt.payload)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUnique(t *testing.T) {
+	for _, tc := range []struct {
+		u  domain.Unique
+		ok bool
+	}{
+		{domain.Unique{Key: "k", Mode: domain.UniqueActive}, true},
+		{domain.Unique{Key: "k", Mode: domain.UniqueWindow, Window: time.Hour, OnConflict: domain.UniqueReject}, true},
+		{domain.Unique{Mode: domain.UniqueActive}, false},
+		{domain.Unique{Key: "k"}, false},
+		{domain.Unique{Key: "k", Mode: domain.UniqueQueued, Window: time.Hour}, false},
+		{domain.Unique{Key: "k", Mode: domain.UniqueWindow}, false},
+		{domain.Unique{Key: "k", Mode: domain.UniqueActive, OnConflict: "merge"}, false},
+	} {
+		if err := ValidateUnique(tc.u); (err == nil) != tc.ok {
+			t.Errorf("ValidateUnique(%+v) = %v", tc.u, err)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Package retention deletes finished tasks once they are older than a
+// configured period, optionally archiving them first, and old schedule
+// misfire records.
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	"localflow/internal/transfer"
+)
+
+// leaseName is the lease that picks which of the processes sharing a
+// database prunes it.
+const leaseName = "retention"
+
+// batchPause is the wait between batches, which gives workers the single
+// connection back.
+const batchPause = 50 * time.Millisecond
+
+// States are the finished states retention applies to.
+var States = []string{"succeeded", "failed", "canceled", "expired"}
+
+// Rule deletes tasks that have been in State longer than MaxAge. Type
+// limits it to one task type; ExcludeTypes leaves types that have their
+// own rule alone.
+type Rule struct {
+	State        string
+	Type         string
+	ExcludeTypes []string
+	MaxAge       time.Duration
+}
+
+// Rules expands periods by state and per type overrides, both keyed by
+// state, into rules. A period of 0 keeps tasks forever; an override of 0
+// keeps that type's tasks even when the state has a period.
+func Rules(periods map[string]time.Duration, types map[string]map[string]time.Duration) []Rule {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rules []Rule
+	for _, state := range States {
+		var overridden []string
+		for _, name := range names {
+			if _, ok := types[name][state]; ok {
+				overridden = append(overridden, name)
+			}
+		}
+		if age := periods[state]; age > 0 {
+			rules = append(rules, Rule{State: state, ExcludeTypes: overridden, MaxAge: age})
+		}
+		for _, name := range overridden {
+			if age := types[name][state]; age > 0 {
+				rules = append(rules, Rule{State: state, Type: name, MaxAge: age})
+			}
+		}
+	}
+	return rules
+}
+
+// Service applies the rules periodically.
+type Service struct {
+	repo      queue.Repository
+	rules     []Rule
+	interval  time.Duration
+	batchSize int
+	stop      chan struct{}
+	owner     string // identifies this process in the retention lease
+
+	archiveDir string
+	misfireAge time.Duration // 0 keeps misfire records forever
+
+	vacuumEvery time.Duration // 0 never
+	lastVacuum  time.Time
+	sinceVacuum int // tasks deleted since the last vacuum
+}
+
+func NewService(repo queue.Repository, rules []Rule, interval time.Duration) *Service {
+	host, _ := os.Hostname()
+	return &Service{
+		repo:       repo,
+		rules:      rules,
+		interval:   interval,
+		batchSize:  500,
+		stop:       make(chan struct{}),
+		owner:      fmt.Sprintf("%s/%d/%s", host, os.Getpid(), uuid.NewString()[:8]),
+		lastVacuum: time.Now(),
+	}
+}
+
+// WithBatchSize sets how many tasks are deleted per transaction.
+func (s *Service) WithBatchSize(n int) *Service {
+	if n > 0 {
+		s.batchSize = n
+	}
+	return s
+}
+
+// WithArchive writes deleted tasks with their attempts to a gzipped NDJSON
+// file in dir per run, in the export format, so they can be imported
+// again. Logs are not archived.
+func (s *Service) WithArchive(dir string) *Service {
+	s.archiveDir = dir
+	return s
+}
+
+// WithMisfires deletes schedule misfire records older than d. d <= 0 keeps
+// them forever.
+func (s *Service) WithMisfires(d time.Duration) *Service {
+	s.misfireAge = max(d, 0)
+	return s
+}
+
+// WithVacuum optimizes the database at most every d, after a run that
+// left deleted rows behind since the last one. d <= 0 never does.
+func (s *Service) WithVacuum(d time.Duration) *Service {
+	s.vacuumEvery = max(d, 0)
+	return s
+}
+
+func (s *Service) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	log.Info().Dur("interval", s.interval).Int("rules", len(s.rules)).Str("archive_dir", s.archiveDir).Msg("retention service started")
+	defer s.release()
+
+	now := time.Now()
+	for {
+		if s.acquire(ctx, now) {
+			s.run(ctx, now)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case now = <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) Stop() {
+	close(s.stop)
+}
+
+// acquire takes or renews the lease, so one process prunes at a time.
+func (s *Service) acquire(ctx context.Context, now time.Time) bool {
+	ok, err := s.repo.AcquireLease(ctx, leaseName, s.owner, 2*s.interval, now)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to acquire retention lease")
+		return false
+	}
+	return ok
+}
+
+func (s *Service) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.repo.ReleaseLease(ctx, leaseName, s.owner); err != nil {
+		log.Error().Err(err).Msg("failed to release retention lease")
+	}
+}
+
+func (s *Service) run(ctx context.Context, now time.Time) {
+	var arc *archive
+	defer func() {
+		if arc == nil {
+			return
+		}
+		if err := arc.Close(); err != nil {
+			log.Error().Err(err).Str("path", arc.path).Msg("failed to close task archive")
+		}
+	}()
+
+	deleted := 0
+	for _, rule := range s.rules {
+		n, err := s.apply(ctx, rule, now, &arc)
+		deleted += n
+		if err != nil {
+			log.Error().Err(err).Str("state", rule.State).Str("type", rule.Type).Msg("pruning tasks failed")
+			break
+		}
+	}
+	if deleted > 0 {
+		ev := log.Info().Int("deleted", deleted)
+		if arc != nil {
+			ev = ev.Str("archive", arc.path)
+		}
+		ev.Msg("finished tasks pruned")
+	}
+
+	if s.misfireAge > 0 {
+		n, err := s.pruneMisfires(ctx, now)
+		if err != nil {
+			log.Error().Err(err).Msg("pruning schedule misfires failed")
+		}
+		if n > 0 {
+			log.Info().Int("deleted", n).Msg("schedule misfires pruned")
+		}
+		deleted += n
+	}
+
+	s.sinceVacuum += deleted
+	if s.vacuumEvery > 0 && s.sinceVacuum > 0 && now.Sub(s.lastVacuum) >= s.vacuumEvery {
+		start := time.Now()
+		if err := s.repo.Optimize(ctx); err != nil {
+			log.Error().Err(err).Dur("took", time.Since(start)).Msg("optimizing database failed")
+			return
+		}
+		log.Info().Dur("took", time.Since(start)).Msg("database optimized")
+		s.lastVacuum, s.sinceVacuum = now, 0
+	}
+}
+
+// apply deletes the tasks one rule selects, a batch at a time, archiving
+// each batch before it is deleted.
+func (s *Service) apply(ctx context.Context, rule Rule, now time.Time, arc **archive) (int, error) {
+	deleted := 0
+	for {
+		tasks, err := s.repo.PrunableTasks(ctx, queue.PruneFilter{
+			State:        rule.State,
+			Type:         rule.Type,
+			ExcludeTypes: rule.ExcludeTypes,
+			Before:       now.Add(-rule.MaxAge),
+			Limit:        s.batchSize,
+		})
+		if err != nil || len(tasks) == 0 {
+			return deleted, err
+		}
+		if s.archiveDir != "" {
+			if *arc == nil {
+				if *arc, err = openArchive(s.archiveDir, now); err != nil {
+					return deleted, err
+				}
+			}
+			if err := (*arc).write(ctx, s.repo, tasks); err != nil {
+				return deleted, err
+			}
+		}
+		ids := make([]string, len(tasks))
+		for i, t := range tasks {
+			ids[i] = t.ID
+		}
+		n, err := s.repo.DeleteFinishedTasks(ctx, ids)
+		deleted += n
+		if err != nil || n == 0 || len(tasks) < s.batchSize {
+			return deleted, err
+		}
+		select {
+		case <-ctx.Done():
+			return deleted, ctx.Err()
+		case <-time.After(batchPause):
+		}
+	}
+}
+
+// pruneMisfires deletes misfire records older than misfireAge, a batch at
+// a time.
+func (s *Service) pruneMisfires(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+	for {
+		n, err := s.repo.DeleteScheduleMisfires(ctx, now.Add(-s.misfireAge), s.batchSize)
+		deleted += n
+		if err != nil || n < s.batchSize {
+			return deleted, err
+		}
+		select {
+		case <-ctx.Done():
+			return deleted, ctx.Err()
+		case <-time.After(batchPause):
+		}
+	}
+}
+
+// archive is the file one run archives deleted tasks to.
+type archive struct {
+	path string
+	f    *os.File
+	gz   *gzip.Writer
+	w    *transfer.Writer
+}
+
+func openArchive(dir string, now time.Time) (*archive, error) {
+	path := filepath.Join(dir, "tasks-"+now.UTC().Format("20060102T150405Z")+".ndjson.gz")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	w, err := transfer.NewWriter(gz)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &archive{path: path, f: f, gz: gz, w: w}, nil
+}
+
+// write archives tasks with their attempts and flushes them to the file,
+// so they are stored before they are deleted.
+func (a *archive) write(ctx context.Context, repo queue.Repository, tasks []domain.Task) error {
+	for _, t := range tasks {
+		attempts, err := repo.ListTaskAttempts(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		if err := a.w.Task(t, attempts); err != nil {
+			return err
+		}
+	}
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *archive) Close() error {
+	if err := a.gz.Close(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
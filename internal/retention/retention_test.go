@@ -0,0 +1,180 @@
+package retention
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	"localflow/internal/transfer"
+	_ "modernc.org/sqlite"
+)
+
+func newTestRepo(t *testing.T) queue.Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := queue.EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return queue.NewSQLiteRepo(db)
+}
+
+func TestRules(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name    string
+		periods map[string]time.Duration
+		types   map[string]map[string]time.Duration
+		want    []Rule
+	}{
+		{name: "nothing set"},
+		{
+			name:    "states only",
+			periods: map[string]time.Duration{"succeeded": day, "failed": 0},
+			want:    []Rule{{State: "succeeded", MaxAge: day}},
+		},
+		{
+			name:    "type override",
+			periods: map[string]time.Duration{"succeeded": 7 * day},
+			types:   map[string]map[string]time.Duration{"http": {"succeeded": day}},
+			want: []Rule{
+				{State: "succeeded", ExcludeTypes: []string{"http"}, MaxAge: 7 * day},
+				{State: "succeeded", Type: "http", MaxAge: day},
+			},
+		},
+		{
+			name:    "zero override keeps the type",
+			periods: map[string]time.Duration{"failed": 30 * day},
+			types:   map[string]map[string]time.Duration{"billing": {"failed": 0}},
+			want:    []Rule{{State: "failed", ExcludeTypes: []string{"billing"}, MaxAge: 30 * day}},
+		},
+		{
+			name:  "override without a state period",
+			types: map[string]map[string]time.Duration{"http": {"canceled": day}},
+			want:  []Rule{{State: "canceled", Type: "http", MaxAge: day}},
+		},
+		{
+			name:    "overrides sorted by type",
+			periods: map[string]time.Duration{"expired": day},
+			types: map[string]map[string]time.Duration{
+				"shell": {"expired": 2 * day},
+				"http":  {"expired": 3 * day, "succeeded": 0},
+			},
+			want: []Rule{
+				{State: "expired", ExcludeTypes: []string{"http", "shell"}, MaxAge: day},
+				{State: "expired", Type: "http", MaxAge: 3 * day},
+				{State: "expired", Type: "shell", MaxAge: 2 * day},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Rules(tt.periods, tt.types); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rules() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// seed stores a finished task last updated at updated, with one attempt.
+func seed(t *testing.T, repo queue.Repository, id, state string, updated time.Time) {
+	t.Helper()
+	task := domain.Task{
+		ID: id, Type: "shell", Payload: []byte(`{"command":"true"}`), State: state, Attempts: 1,
+		MaxAttempts: 3, NextRunAt: updated, CreatedAt: updated, UpdatedAt: updated,
+	}
+	attempts := []domain.TaskAttempt{{StartedAt: updated, FinishedAt: &updated, Success: state == "succeeded"}}
+	if err := repo.ImportTask(context.Background(), task, attempts, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunArchivesThenDeletes(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	old := now.Add(-48 * time.Hour)
+	for _, id := range []string{"tsk_old1", "tsk_old2", "tsk_old3"} {
+		seed(t, repo, id, "succeeded", old)
+	}
+	seed(t, repo, "tsk_recent", "succeeded", now.Add(-time.Hour))
+	seed(t, repo, "tsk_failed", "failed", old)
+
+	dir := t.TempDir()
+	rules := Rules(map[string]time.Duration{"succeeded": 24 * time.Hour}, nil)
+	// A batch size below the task count makes it take several batches.
+	s := NewService(repo, rules, time.Minute).WithBatchSize(2).WithArchive(dir)
+	s.run(ctx, now)
+
+	var left []string
+	tasks, err := repo.ListTasks(ctx, queue.TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, task := range tasks {
+		left = append(left, task.ID)
+	}
+	slices.Sort(left)
+	if want := []string{"tsk_failed", "tsk_recent"}; !slices.Equal(left, want) {
+		t.Errorf("tasks left %v, want %v", left, want)
+	}
+	if attempts, _ := repo.ListTaskAttempts(ctx, "tsk_old1"); len(attempts) != 0 {
+		t.Errorf("%d attempts left for a deleted task", len(attempts))
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "tasks-*.ndjson.gz"))
+	if len(files) != 1 {
+		t.Fatalf("archives %v, want one", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := transfer.Read(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archived []string
+	for _, task := range doc.Tasks {
+		archived = append(archived, task.ID)
+		if len(task.AttemptLog) != 1 {
+			t.Errorf("task %s archived with %d attempts, want 1", task.ID, len(task.AttemptLog))
+		}
+	}
+	slices.Sort(archived)
+	if want := []string{"tsk_old1", "tsk_old2", "tsk_old3"}; !slices.Equal(archived, want) {
+		t.Errorf("archived %v, want %v", archived, want)
+	}
+}
+
+func TestRunKeepsTasksWhenArchiveFails(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	seed(t, repo, "tsk_old", "succeeded", now.Add(-48*time.Hour))
+
+	rules := Rules(map[string]time.Duration{"succeeded": 24 * time.Hour}, nil)
+	missing := filepath.Join(t.TempDir(), "missing")
+	NewService(repo, rules, time.Minute).WithArchive(missing).run(ctx, now)
+
+	if _, err := repo.Get(ctx, "tsk_old"); err != nil {
+		t.Errorf("task deleted without being archived: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+)
+
+// ErrNoFutureRun is returned for schedules that will never fire again,
+// e.g. an @at time or end date in the past.
+var ErrNoFutureRun = errors.New("schedule has no future run")
+
+var validCalendarName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidCalendarName reports whether name can be used as a calendar name.
+func ValidCalendarName(name string) bool {
+	return validCalendarName.MatchString(name)
+}
+
+// UsesCalendar reports whether the schedule excludes the named calendar.
+func UsesCalendar(schedule domain.Schedule, name string) bool {
+	return slices.Contains(schedule.ExcludeCalendars, name)
+}
+
+// ScheduleSpec parses a schedule's expression and applies its window,
+// exclusion calendars and jitter.
+func ScheduleSpec(ctx context.Context, repo queue.Repository, schedule domain.Schedule) (*Spec, error) {
+	spec, err := Parse(schedule.CronExpr, schedule.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	spec.Window(schedule.StartAt, schedule.EndAt).Delay(JitterOffset(schedule))
+	for _, name := range schedule.ExcludeCalendars {
+		cal, err := repo.GetCalendar(ctx, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("unknown calendar %q", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		spec.Exclude(cal.Dates)
+	}
+	return spec, nil
+}
+
+// NextRun returns the schedule's first fire after from, or ErrNoFutureRun.
+func NextRun(ctx context.Context, repo queue.Repository, schedule domain.Schedule, from time.Time) (time.Time, error) {
+	if schedule.StartAt != nil && schedule.EndAt != nil && schedule.EndAt.Before(*schedule.StartAt) {
+		return time.Time{}, fmt.Errorf("end time is before start time")
+	}
+	spec, err := ScheduleSpec(ctx, repo, schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := spec.Next(from)
+	if next.IsZero() {
+		return time.Time{}, ErrNoFutureRun
+	}
+	return next, nil
+}
+
+// NormalizeDates validates YYYY-MM-DD dates and returns them sorted without
+// duplicates.
+func NormalizeDates(dates []string) ([]string, error) {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(dates))
+	for _, d := range dates {
+		t, err := time.Parse(dateLayout, d)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q (want YYYY-MM-DD)", d)
+		}
+		d = t.Format(dateLayout)
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+)
+
+// ValidateConcurrency checks a schedule's concurrency policy.
+func ValidateConcurrency(policy string) error {
+	switch policy {
+	case "", domain.ConcurrencyAllow, domain.ConcurrencyForbid, domain.ConcurrencyReplace:
+		return nil
+	}
+	return fmt.Errorf("invalid concurrency policy %q (want %s, %s or %s)", policy, domain.ConcurrencyAllow, domain.ConcurrencyForbid, domain.ConcurrencyReplace)
+}
+
+// Admit applies the schedule's concurrency policy before a new run is
+// enqueued. It returns false when the run must be skipped because an
+// earlier one is still active; under ConcurrencyReplace the earlier runs
+// are canceled instead.
+func Admit(ctx context.Context, repo queue.Repository, schedule domain.Schedule) (bool, error) {
+	if schedule.ConcurrencyPolicy == "" || schedule.ConcurrencyPolicy == domain.ConcurrencyAllow {
+		return true, nil
+	}
+	active, err := repo.ActiveScheduleTasks(ctx, schedule.ID)
+	if err != nil || len(active) == 0 {
+		return err == nil, err
+	}
+	if schedule.ConcurrencyPolicy == domain.ConcurrencyForbid {
+		return false, nil
+	}
+	for _, t := range active {
+		// The task may have finished since it was listed.
+		if err := repo.Cancel(ctx, t.ID); err != nil && !errors.Is(err, queue.ErrInvalidState) {
+			return false, err
+		}
+	}
+	return true, nil
+}
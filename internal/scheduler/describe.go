@@ -0,0 +1,169 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Upcoming returns up to n fire times after from.
+func Upcoming(spec *Spec, from time.Time, n int) []time.Time {
+	var out []time.Time
+	for t := spec.Next(from); !t.IsZero() && len(out) < n; t = spec.Next(t) {
+		out = append(out, t)
+	}
+	return out
+}
+
+var (
+	monthNames = []string{"", "January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+	dayNames   = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+)
+
+// Describe renders a schedule expression in English, e.g. "at 09:00 on
+// Monday through Friday". Expressions it can't describe, such as ones
+// using L or W, fall back to the expression itself.
+func Describe(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		_, expr, _ = strings.Cut(expr, " ")
+		expr = strings.TrimSpace(expr)
+	}
+	switch {
+	case strings.HasPrefix(expr, "@at "):
+		return "once at " + strings.TrimSpace(strings.TrimPrefix(expr, "@at "))
+	case strings.HasPrefix(expr, "@every "):
+		return "every " + strings.TrimSpace(strings.TrimPrefix(expr, "@every "))
+	}
+	switch expr {
+	case "@hourly":
+		return "every hour"
+	case "@daily", "@midnight":
+		return "every day at 00:00"
+	case "@weekly":
+		return "every Sunday at 00:00"
+	case "@monthly":
+		return "at 00:00 on day 1 of the month"
+	case "@yearly", "@annually":
+		return "at 00:00 on January 1"
+	}
+
+	fields := strings.Fields(expr)
+	sec := "0"
+	switch len(fields) {
+	case 5:
+	case 6:
+		sec, fields = fields[0], fields[1:]
+	default:
+		return expr
+	}
+	min, hour, dom, month, dow := fields[0], fields[1], fields[2], fields[3], fields[4]
+	for _, f := range fields {
+		if strings.ContainsAny(f, "LW#") {
+			return expr
+		}
+	}
+
+	var parts []string
+	if isNum(sec) && isNum(min) && isNum(hour) {
+		at := fmt.Sprintf("at %02s:%02s", hour, min)
+		if sec != "0" {
+			at += fmt.Sprintf(":%02s", sec)
+		}
+		parts = append(parts, at)
+	} else if min == "0" && sec == "0" {
+		switch {
+		case hour == "*":
+			parts = append(parts, "every hour")
+		case strings.Contains(hour, "/"):
+			parts = append(parts, describeField(hour, "hour", nil))
+		default:
+			parts = append(parts, "at the start of "+describeField(hour, "hour", nil))
+		}
+	} else {
+		if sec != "0" {
+			parts = append(parts, atField(sec, "second"))
+		}
+		if min != "*" {
+			parts = append(parts, atField(min, "minute"))
+		} else if sec == "0" {
+			parts = append(parts, "every minute")
+		}
+		if hour != "*" {
+			parts = append(parts, "past "+describeField(hour, "hour", nil))
+		}
+	}
+	if dom != "*" && dom != "?" {
+		parts = append(parts, "on "+describeField(dom, "day", nil)+" of the month")
+	}
+	if month != "*" {
+		parts = append(parts, "in "+describeField(month, "month", monthNames))
+	}
+	if dow != "*" && dow != "?" {
+		parts = append(parts, "on "+describeField(dow, "weekday", dayNames))
+	}
+	return strings.Join(parts, " ")
+}
+
+// describeField renders one cron field. names maps values to words for
+// months and weekdays; nil prints numbers with the unit.
+func describeField(f, unit string, names []string) string {
+	name := func(v string) string {
+		if names == nil {
+			return v
+		}
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n < len(names) {
+			return names[n]
+		}
+		for _, full := range names {
+			if len(full) >= 3 && strings.EqualFold(full[:3], v) {
+				return full
+			}
+		}
+		return v
+	}
+	if base, step, ok := strings.Cut(f, "/"); ok {
+		s := fmt.Sprintf("every %s %ss", step, unit)
+		if step == "1" {
+			s = "every " + unit
+		}
+		if base != "*" {
+			s += " from " + describeField(base, unit, names)
+		}
+		return s
+	}
+	if f == "*" {
+		return "every " + unit
+	}
+	var items []string
+	for _, item := range strings.Split(f, ",") {
+		if lo, hi, ok := strings.Cut(item, "-"); ok {
+			items = append(items, name(lo)+" through "+name(hi))
+		} else {
+			items = append(items, name(item))
+		}
+	}
+	list := strings.Join(items, ", ")
+	if names != nil {
+		return list
+	}
+	if len(items) == 1 && !strings.Contains(list, "through") {
+		return unit + " " + list
+	}
+	return unit + "s " + list
+}
+
+// atField is describeField with "at" before plain values, so "5,35" reads
+// "at minutes 5, 35" while "*/5" stays "every 5 minutes".
+func atField(f, unit string) string {
+	if f == "*" || strings.Contains(f, "/") {
+		return describeField(f, unit, nil)
+	}
+	return "at " + describeField(f, unit, nil)
+}
+
+func isNum(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
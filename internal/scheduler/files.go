@@ -0,0 +1,313 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+)
+
+// Definition is a schedule declared in a YAML file. Unset priority and
+// max_attempts keep the server defaults; enabled defaults to true.
+type Definition struct {
+	Name              string        `yaml:"name"`
+	Cron              string        `yaml:"cron"`
+	Timezone          string        `yaml:"timezone"`
+	TaskType          string        `yaml:"task_type"`
+	Payload           any           `yaml:"payload"`
+	Priority          int           `yaml:"priority"`
+	MaxAttempts       int           `yaml:"max_attempts"`
+	Enabled           *bool         `yaml:"enabled"`
+	MisfirePolicy     string        `yaml:"misfire_policy"`
+	MisfireGrace      time.Duration `yaml:"misfire_grace"`
+	MaxCatchup        int           `yaml:"max_catchup"`
+	ConcurrencyPolicy string        `yaml:"concurrency_policy"`
+	StartAt           *time.Time    `yaml:"start_at"`
+	EndAt             *time.Time    `yaml:"end_at"`
+	ExcludeCalendars  []string      `yaml:"exclude_calendars"`
+	Jitter            time.Duration `yaml:"jitter"`
+
+	// File is the definition's file relative to the schedule directory.
+	File string `yaml:"-"`
+}
+
+// Schedule validates the definition and converts it to a schedule without
+// an ID or next run.
+func (d Definition) Schedule() (domain.Schedule, error) {
+	if d.Name == "" {
+		return domain.Schedule{}, errors.New("name is required")
+	}
+	if d.Cron == "" || d.TaskType == "" {
+		return domain.Schedule{}, errors.New("cron and task_type are required")
+	}
+	if err := ValidateCronExpression(d.Cron, d.Timezone); err != nil {
+		return domain.Schedule{}, fmt.Errorf("invalid cron expression: %w", err)
+	}
+	payload := []byte("{}")
+	if d.Payload != nil {
+		var err error
+		if payload, err = json.Marshal(d.Payload); err != nil {
+			return domain.Schedule{}, fmt.Errorf("payload: %w", err)
+		}
+	}
+	if err := ValidatePayload(payload); err != nil {
+		return domain.Schedule{}, err
+	}
+	grace := int(d.MisfireGrace / time.Second)
+	if err := ValidateMisfire(d.MisfirePolicy, grace, d.MaxCatchup); err != nil {
+		return domain.Schedule{}, err
+	}
+	if err := ValidateConcurrency(d.ConcurrencyPolicy); err != nil {
+		return domain.Schedule{}, err
+	}
+	jitter := int(d.Jitter / time.Second)
+	if err := ValidateJitter(jitter); err != nil {
+		return domain.Schedule{}, err
+	}
+	if d.StartAt != nil && d.EndAt != nil && d.EndAt.Before(*d.StartAt) {
+		return domain.Schedule{}, errors.New("end_at is before start_at")
+	}
+	for _, name := range d.ExcludeCalendars {
+		if !ValidCalendarName(name) {
+			return domain.Schedule{}, fmt.Errorf("invalid calendar name %q", name)
+		}
+	}
+	return domain.Schedule{
+		Name:              d.Name,
+		CronExpr:          d.Cron,
+		Timezone:          d.Timezone,
+		TaskType:          d.TaskType,
+		Payload:           payload,
+		Priority:          d.Priority,
+		MaxAttempts:       d.MaxAttempts,
+		Enabled:           d.Enabled == nil || *d.Enabled,
+		MisfirePolicy:     d.MisfirePolicy,
+		MisfireGrace:      grace,
+		MaxCatchup:        d.MaxCatchup,
+		ConcurrencyPolicy: d.ConcurrencyPolicy,
+		StartAt:           utcPtr(d.StartAt),
+		EndAt:             utcPtr(d.EndAt),
+		ExcludeCalendars:  d.ExcludeCalendars,
+		Jitter:            jitter,
+		Source:            d.File,
+	}, nil
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// LoadDefinitions reads the *.yaml and *.yml files in dir. A file holds one
+// schedule per YAML document, separated by "---". Any invalid definition
+// fails the whole load so a bad commit doesn't half apply.
+func LoadDefinitions(dir string) ([]Definition, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		m, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, m...)
+	}
+	sort.Strings(files)
+
+	var defs []Definition
+	seen := map[string]string{}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		for {
+			var d Definition
+			err := dec.Decode(&d)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", rel, err)
+			}
+			d.File = rel
+			if _, err := d.Schedule(); err != nil {
+				return nil, fmt.Errorf("%s: schedule %q: %w", rel, d.Name, err)
+			}
+			if other, ok := seen[d.Name]; ok {
+				return nil, fmt.Errorf("%s: schedule %q is also defined in %s", rel, d.Name, other)
+			}
+			seen[d.Name] = rel
+			defs = append(defs, d)
+		}
+	}
+	return defs, nil
+}
+
+// ReconcileResult counts the changes Reconcile made.
+type ReconcileResult struct {
+	Created, Updated, Disabled int
+}
+
+// Reconcile makes the schedules table match defs. File-managed schedules
+// are matched by name; a definition whose name belongs to exactly one
+// schedule created through the API adopts it. Managed schedules no longer
+// defined are disabled and released to the API, keeping their history.
+// Every change is worked out first and then written in one transaction, so
+// a definition that fails, e.g. on an unknown calendar, or a failed write
+// leaves the table untouched.
+func Reconcile(ctx context.Context, repo queue.Repository, defs []Definition, now time.Time) (ReconcileResult, error) {
+	var res ReconcileResult
+	all, err := repo.ListSchedules(ctx)
+	if err != nil {
+		return res, err
+	}
+	managed := map[string]domain.Schedule{}
+	unmanaged := map[string][]domain.Schedule{}
+	for _, s := range all {
+		if s.Source != "" {
+			managed[s.Name] = s
+		} else {
+			unmanaged[s.Name] = append(unmanaged[s.Name], s)
+		}
+	}
+
+	var creates, updates []domain.Schedule
+	defined := map[string]bool{}
+	for _, d := range defs {
+		defined[d.Name] = true
+		fail := func(err error) (ReconcileResult, error) {
+			return ReconcileResult{}, fmt.Errorf("%s: schedule %q: %w", d.File, d.Name, err)
+		}
+		want, err := d.Schedule()
+		if err != nil {
+			return fail(err)
+		}
+		have, ok := managed[d.Name]
+		if !ok {
+			switch matches := unmanaged[d.Name]; len(matches) {
+			case 0:
+			case 1:
+				have, ok = matches[0], true
+				log.Info().Str("schedule_id", have.ID).Str("file", d.File).Msg("schedule adopted by definition file")
+			default:
+				return fail(fmt.Errorf("%d existing schedules have this name", len(matches)))
+			}
+		}
+		if !ok {
+			want.ID = queue.NewScheduleID()
+			if err := setNextRun(ctx, repo, &want, now); err != nil {
+				return fail(err)
+			}
+			creates = append(creates, want)
+			continue
+		}
+
+		updated := merge(have, want)
+		if timingChanged(have, updated) || (updated.Enabled && !have.Enabled) {
+			if err := setNextRun(ctx, repo, &updated, now); err != nil {
+				return fail(err)
+			}
+		}
+		if changed(have, updated) || !updated.NextRun.Equal(have.NextRun) {
+			updates = append(updates, updated)
+		}
+	}
+	for name, s := range managed {
+		if !defined[name] {
+			s.Enabled = false
+			s.Source = ""
+			updates = append(updates, s)
+			res.Disabled++
+		}
+	}
+
+	if err := repo.SaveSchedules(ctx, creates, updates); err != nil {
+		return ReconcileResult{}, err
+	}
+	res.Created = len(creates)
+	res.Updated = len(updates) - res.Disabled
+	return res, nil
+}
+
+// merge overlays a definition on the stored schedule, keeping its ID, run
+// state and, for fields the definition leaves unset, server defaults.
+func merge(have, want domain.Schedule) domain.Schedule {
+	out := have
+	out.CronExpr = want.CronExpr
+	out.Timezone = want.Timezone
+	out.TaskType = want.TaskType
+	out.Payload = want.Payload
+	if want.Priority != 0 {
+		out.Priority = want.Priority
+	}
+	if want.MaxAttempts != 0 {
+		out.MaxAttempts = want.MaxAttempts
+	}
+	out.Enabled = want.Enabled
+	out.MisfirePolicy = want.MisfirePolicy
+	out.MisfireGrace = want.MisfireGrace
+	out.MaxCatchup = want.MaxCatchup
+	out.ConcurrencyPolicy = want.ConcurrencyPolicy
+	out.StartAt = want.StartAt
+	out.EndAt = want.EndAt
+	out.ExcludeCalendars = want.ExcludeCalendars
+	out.Jitter = want.Jitter
+	out.Source = want.Source
+	return out
+}
+
+func changed(a, b domain.Schedule) bool {
+	return timingChanged(a, b) || a.TaskType != b.TaskType || !bytes.Equal(a.Payload, b.Payload) ||
+		a.Priority != b.Priority || a.MaxAttempts != b.MaxAttempts || a.Enabled != b.Enabled ||
+		a.MisfirePolicy != b.MisfirePolicy || a.MisfireGrace != b.MisfireGrace || a.MaxCatchup != b.MaxCatchup ||
+		a.ConcurrencyPolicy != b.ConcurrencyPolicy || a.Source != b.Source
+}
+
+// timingChanged reports whether b fires at different times than a. Only
+// then is the next run recomputed, so a reload doesn't drop a fire that is
+// due but not yet processed.
+func timingChanged(a, b domain.Schedule) bool {
+	return a.CronExpr != b.CronExpr || a.Timezone != b.Timezone || a.Jitter != b.Jitter ||
+		!timePtrEqual(a.StartAt, b.StartAt) || !timePtrEqual(a.EndAt, b.EndAt) ||
+		!slices.Equal(a.ExcludeCalendars, b.ExcludeCalendars)
+}
+
+func timePtrEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// setNextRun computes the schedule's next run. A schedule that will never
+// fire again, e.g. an @at time that has passed, is stored disabled.
+func setNextRun(ctx context.Context, repo queue.Repository, s *domain.Schedule, now time.Time) error {
+	next, err := NextRun(ctx, repo, *s, now)
+	if errors.Is(err, ErrNoFutureRun) {
+		s.Enabled = false
+		s.NextRun = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.NextRun = next
+	return nil
+}
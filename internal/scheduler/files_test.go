@@ -0,0 +1,163 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	create := func(name string) string {
+		t.Helper()
+		id, err := repo.CreateSchedule(ctx, domain.Schedule{
+			Name: name, CronExpr: "0 1 * * *", TaskType: "shell", Payload: []byte(`{}`),
+			Enabled: true, NextRun: now.Add(13 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	byName := func(name string) domain.Schedule {
+		t.Helper()
+		all, err := repo.ListSchedules(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range all {
+			if s.Name == name {
+				return s
+			}
+		}
+		t.Fatalf("no schedule %q", name)
+		return domain.Schedule{}
+	}
+	legacyID := create("legacy")
+	var nightlyNext time.Time
+	create("dup")
+	create("dup")
+
+	nightly := Definition{Name: "nightly", Cron: "0 2 * * *", TaskType: "shell", Payload: map[string]any{"command": "backup"}, File: "a.yaml"}
+	legacy := Definition{Name: "legacy", Cron: "0 1 * * *", TaskType: "shell", File: "b.yaml"}
+
+	steps := []struct {
+		name  string
+		defs  []Definition
+		want  ReconcileResult
+		fails bool
+		check func(t *testing.T)
+	}{
+		{
+			name: "create and adopt",
+			defs: []Definition{nightly, legacy},
+			want: ReconcileResult{Created: 1, Updated: 1},
+			check: func(t *testing.T) {
+				s := byName("nightly")
+				if s.Source != "a.yaml" || !s.Enabled || !s.NextRun.Equal(time.Date(2026, 5, 2, 2, 0, 0, 0, time.Local)) {
+					t.Errorf("created %+v", s)
+				}
+				nightlyNext = s.NextRun
+				if s := byName("legacy"); s.ID != legacyID || s.Source != "b.yaml" {
+					t.Errorf("adopted %+v", s)
+				}
+			},
+		},
+		{
+			name: "unchanged",
+			defs: []Definition{nightly, legacy},
+		},
+		{
+			name: "payload change keeps the next run",
+			defs: []Definition{func() Definition {
+				d := nightly
+				d.Payload = map[string]any{"command": "backup", "args": []string{"-v"}}
+				return d
+			}(), legacy},
+			want: ReconcileResult{Updated: 1},
+			check: func(t *testing.T) {
+				if s := byName("nightly"); string(s.Payload) != `{"args":["-v"],"command":"backup"}` || !s.NextRun.Equal(nightlyNext) {
+					t.Errorf("updated %+v", s)
+				}
+			},
+		},
+		{
+			name: "cron change moves the next run",
+			defs: []Definition{func() Definition {
+				d := nightly
+				d.Cron, d.Timezone = "30 3 * * *", "UTC"
+				return d
+			}(), legacy},
+			want: ReconcileResult{Updated: 1},
+			check: func(t *testing.T) {
+				if s := byName("nightly"); !s.NextRun.Equal(time.Date(2026, 5, 2, 3, 30, 0, 0, time.UTC)) {
+					t.Errorf("next run %s", s.NextRun)
+				}
+			},
+		},
+		{
+			name: "removed definition is disabled and released",
+			defs: []Definition{legacy},
+			want: ReconcileResult{Disabled: 1},
+			check: func(t *testing.T) {
+				if s := byName("nightly"); s.Enabled || s.Source != "" {
+					t.Errorf("removed %+v", s)
+				}
+			},
+		},
+		{
+			name: "unknown calendar changes nothing",
+			defs: []Definition{nightly, func() Definition {
+				d := legacy
+				d.ExcludeCalendars = []string{"holidays"}
+				return d
+			}()},
+			fails: true,
+			check: func(t *testing.T) {
+				if s := byName("nightly"); s.Enabled || s.Source != "" {
+					t.Errorf("failed reconcile re-adopted %+v", s)
+				}
+			},
+		},
+		{
+			name:  "ambiguous name",
+			defs:  []Definition{legacy, {Name: "dup", Cron: "0 1 * * *", TaskType: "shell", File: "c.yaml"}},
+			fails: true,
+		},
+		{
+			name: "disabled definition",
+			defs: []Definition{func() Definition {
+				d := legacy
+				off := false
+				d.Enabled = &off
+				return d
+			}()},
+			want: ReconcileResult{Updated: 1},
+			check: func(t *testing.T) {
+				if s := byName("legacy"); s.Enabled || s.Source != "b.yaml" {
+					t.Errorf("disabled %+v", s)
+				}
+			},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			res, err := Reconcile(ctx, repo, step.defs, now)
+			if step.fails {
+				if err == nil {
+					t.Fatalf("Reconcile succeeded with %+v", res)
+				}
+			} else if err != nil || res != step.want {
+				t.Fatalf("Reconcile = %+v, %v; want %+v", res, err, step.want)
+			}
+			if step.check != nil {
+				step.check(t)
+			}
+		})
+	}
+}
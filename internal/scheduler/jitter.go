@@ -0,0 +1,33 @@
+package scheduler
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+// MaxJitter bounds a schedule's jitter window.
+const MaxJitter = 24 * 60 * 60
+
+// ValidateJitter checks a schedule's jitter window in seconds.
+func ValidateJitter(jitter int) error {
+	if jitter < 0 || jitter > MaxJitter {
+		return fmt.Errorf("jitter must be between 0 and %d seconds", MaxJitter)
+	}
+	return nil
+}
+
+// JitterOffset returns how long each of the schedule's fires is delayed: a
+// whole number of seconds below its Jitter window, hashed from the schedule
+// ID so that it is the same on every tick and every instance while
+// schedules sharing an expression spread across the window.
+func JitterOffset(schedule domain.Schedule) time.Duration {
+	if schedule.Jitter <= 0 || schedule.ID == "" {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(schedule.ID))
+	return time.Duration(h.Sum64()%uint64(schedule.Jitter)) * time.Second
+}
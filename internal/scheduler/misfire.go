@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"fmt"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+const (
+	// DefaultMisfireGrace is how late a fire may be and still count as on
+	// time when the schedule doesn't set MisfireGrace.
+	DefaultMisfireGrace = time.Minute
+	// DefaultMaxCatchup caps the missed fires MisfireRunAll enqueues when
+	// the schedule doesn't set MaxCatchup.
+	DefaultMaxCatchup = 100
+	// maxDueFires bounds how many fires one tick enumerates, so a schedule
+	// that fires every minute doesn't stall the scheduler after a long
+	// outage. Fires beyond it are dropped without being recorded.
+	maxDueFires = 10000
+)
+
+// ValidateMisfire checks a schedule's misfire settings.
+func ValidateMisfire(policy string, grace, maxCatchup int) error {
+	switch policy {
+	case "", domain.MisfireRunOnce, domain.MisfireSkip, domain.MisfireRunAll:
+	default:
+		return fmt.Errorf("invalid misfire policy %q (want %s, %s or %s)", policy, domain.MisfireRunOnce, domain.MisfireSkip, domain.MisfireRunAll)
+	}
+	if grace < 0 {
+		return fmt.Errorf("misfire grace must not be negative")
+	}
+	if maxCatchup < 0 {
+		return fmt.Errorf("max catchup must not be negative")
+	}
+	return nil
+}
+
+// dueFires lists the fires from first up to and including now. The bool is
+// true when the list was cut off at maxDueFires.
+func dueFires(spec *Spec, first, now time.Time) ([]time.Time, bool) {
+	var fires []time.Time
+	for f := first; !f.IsZero() && !f.After(now); f = spec.Next(f) {
+		if len(fires) == maxDueFires {
+			return fires, true
+		}
+		// first was computed before the schedule's calendars last changed.
+		if spec.Excluded(f) {
+			continue
+		}
+		fires = append(fires, f)
+	}
+	return fires, false
+}
+
+// planFires splits due fires into those to enqueue and those to record as
+// skipped. Fires later than grace are misfires and handled per the
+// schedule's policy; when several fires are still on time only the latest
+// runs, as a tick never enqueues the same schedule twice for on-time work.
+// The on-time fires passed over are not misfires and are dropped without a
+// record, so a schedule firing more often than the scheduler ticks doesn't
+// add rows on every tick.
+func planFires(schedule domain.Schedule, fires []time.Time, now time.Time, grace time.Duration) (run, skip []time.Time) {
+	if len(fires) == 0 {
+		return nil, nil
+	}
+	latest := fires[len(fires)-1]
+	late := func(fires []time.Time) []time.Time {
+		var out []time.Time
+		for _, f := range fires {
+			if now.Sub(f) > grace {
+				out = append(out, f)
+			}
+		}
+		return out
+	}
+	switch schedule.MisfirePolicy {
+	case domain.MisfireSkip:
+		if now.Sub(latest) > grace {
+			return nil, fires
+		}
+		return []time.Time{latest}, late(fires[:len(fires)-1])
+	case domain.MisfireRunAll:
+		limit := schedule.MaxCatchup
+		if limit <= 0 {
+			limit = DefaultMaxCatchup
+		}
+		if len(fires) <= limit {
+			return fires, nil
+		}
+		// Keep the most recent fires; the oldest are the ones dropped.
+		cut := len(fires) - limit
+		return fires[cut:], fires[:cut]
+	default:
+		return []time.Time{latest}, late(fires[:len(fires)-1])
+	}
+}
+
+// misfireGrace returns the schedule's grace period, never shorter than the
+// scheduler's check interval so a fire caught by the next tick isn't late.
+func (s *Service) misfireGrace(schedule domain.Schedule) time.Duration {
+	grace := time.Duration(schedule.MisfireGrace) * time.Second
+	if grace <= 0 {
+		grace = DefaultMisfireGrace
+	}
+	return max(grace, s.interval)
+}
+
+// skipReason describes why fires were skipped under the schedule's policy.
+func skipReason(schedule domain.Schedule) string {
+	switch schedule.MisfirePolicy {
+	case domain.MisfireSkip:
+		return "missed (policy skip)"
+	case domain.MisfireRunAll:
+		return "missed (catch-up limit reached)"
+	default:
+		return "missed (policy run_once)"
+	}
+}
@@ -0,0 +1,147 @@
+package scheduler
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+func TestPlanFires(t *testing.T) {
+	now := utc("2026-05-01T12:00:00Z")
+	// minutes returns fires the given numbers of minutes before now.
+	minutes := func(ago ...int) []time.Time {
+		var fires []time.Time
+		for _, m := range ago {
+			fires = append(fires, now.Add(-time.Duration(m)*time.Minute))
+		}
+		return fires
+	}
+	seconds := func(ago ...int) []time.Time {
+		var fires []time.Time
+		for _, sec := range ago {
+			fires = append(fires, now.Add(-time.Duration(sec)*time.Second))
+		}
+		return fires
+	}
+	grace := time.Minute
+	tests := []struct {
+		name       string
+		policy     string
+		maxCatchup int
+		fires      []time.Time
+		run, skip  []time.Time
+	}{
+		{name: "nothing due", policy: domain.MisfireRunOnce},
+		{name: "run_once on time", policy: domain.MisfireRunOnce,
+			fires: minutes(0), run: minutes(0)},
+		{name: "run_once late", policy: domain.MisfireRunOnce,
+			fires: minutes(30), run: minutes(30)},
+		{name: "run_once runs the latest", policy: domain.MisfireRunOnce,
+			fires: minutes(30, 20, 10), run: minutes(10), skip: minutes(30, 20)},
+		{name: "run_once drops on-time fires unrecorded", policy: domain.MisfireRunOnce,
+			fires: seconds(50, 30, 10, 0), run: seconds(0)},
+		{name: "run_once records only late fires", policy: domain.MisfireRunOnce,
+			fires: seconds(300, 90, 30, 0), run: seconds(0), skip: seconds(300, 90)},
+		{name: "skip drops on-time fires unrecorded", policy: domain.MisfireSkip,
+			fires: seconds(40, 20, 0), run: seconds(0)},
+		{name: "default policy is run_once", policy: "",
+			fires: minutes(30, 20, 10), run: minutes(10), skip: minutes(30, 20)},
+		{name: "skip on time", policy: domain.MisfireSkip,
+			fires: minutes(0), run: minutes(0)},
+		{name: "skip within grace", policy: domain.MisfireSkip,
+			fires: minutes(10, 1), run: minutes(1), skip: minutes(10)},
+		{name: "skip late", policy: domain.MisfireSkip,
+			fires: minutes(30, 20, 10), skip: minutes(30, 20, 10)},
+		{name: "skip just past grace", policy: domain.MisfireSkip,
+			fires: []time.Time{now.Add(-grace - time.Second)}, skip: []time.Time{now.Add(-grace - time.Second)}},
+		{name: "run_all runs every fire", policy: domain.MisfireRunAll,
+			fires: minutes(30, 20, 10), run: minutes(30, 20, 10)},
+		{name: "run_all keeps the newest", policy: domain.MisfireRunAll, maxCatchup: 2,
+			fires: minutes(40, 30, 20, 10), run: minutes(20, 10), skip: minutes(40, 30)},
+		{name: "run_all at the limit", policy: domain.MisfireRunAll, maxCatchup: 3,
+			fires: minutes(30, 20, 10), run: minutes(30, 20, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := domain.Schedule{MisfirePolicy: tt.policy, MaxCatchup: tt.maxCatchup}
+			run, skip := planFires(schedule, tt.fires, now, grace)
+			if !slices.Equal(run, tt.run) || !slices.Equal(skip, tt.skip) {
+				t.Errorf("planFires = run %v skip %v, want run %v skip %v", run, skip, tt.run, tt.skip)
+			}
+		})
+	}
+}
+
+func TestPlanFiresDefaultMaxCatchup(t *testing.T) {
+	now := utc("2026-05-01T12:00:00Z")
+	var fires []time.Time
+	for i := DefaultMaxCatchup + 5; i > 0; i-- {
+		fires = append(fires, now.Add(-time.Duration(i)*time.Minute))
+	}
+	run, skip := planFires(domain.Schedule{MisfirePolicy: domain.MisfireRunAll}, fires, now, time.Minute)
+	if len(run) != DefaultMaxCatchup || len(skip) != 5 || !run[len(run)-1].Equal(fires[len(fires)-1]) {
+		t.Errorf("planFires ran %d and skipped %d fires", len(run), len(skip))
+	}
+}
+
+func TestDueFires(t *testing.T) {
+	spec, err := Parse("0 * * * *", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := utc("2026-05-01T09:00:00Z")
+	fires, cut := dueFires(spec, first, utc("2026-05-01T12:00:00Z"))
+	if cut || len(fires) != 4 || !fires[3].Equal(utc("2026-05-01T12:00:00Z")) {
+		t.Errorf("dueFires = %v, %v", fires, cut)
+	}
+	if fires, _ := dueFires(spec, first, first.Add(-time.Second)); len(fires) != 0 {
+		t.Errorf("dueFires before first = %v", fires)
+	}
+
+	spec.Exclude([]string{"2026-05-01"})
+	if fires, _ := dueFires(spec, first, utc("2026-05-02T01:00:00Z")); len(fires) != 2 {
+		t.Errorf("dueFires with an excluded day = %v", fires)
+	}
+
+	every, _ := Parse("@every 1s", "UTC")
+	fires, cut = dueFires(every, first, first.Add(24*time.Hour))
+	if !cut || len(fires) != maxDueFires {
+		t.Errorf("dueFires returned %d fires, cut %v", len(fires), cut)
+	}
+}
+
+func TestMisfireGrace(t *testing.T) {
+	s := &Service{interval: 10 * time.Second}
+	for _, tc := range []struct {
+		grace int
+		want  time.Duration
+	}{
+		{0, DefaultMisfireGrace},
+		{30, 30 * time.Second},
+		{5, 10 * time.Second},
+	} {
+		if got := s.misfireGrace(domain.Schedule{MisfireGrace: tc.grace}); got != tc.want {
+			t.Errorf("misfireGrace(%d) = %s, want %s", tc.grace, got, tc.want)
+		}
+	}
+}
+
+func TestValidateMisfire(t *testing.T) {
+	for _, tc := range []struct {
+		policy            string
+		grace, maxCatchup int
+		ok                bool
+	}{
+		{"", 0, 0, true},
+		{domain.MisfireRunAll, 60, 10, true},
+		{"sometimes", 0, 0, false},
+		{domain.MisfireSkip, -1, 0, false},
+		{domain.MisfireRunAll, 0, -1, false},
+	} {
+		if err := ValidateMisfire(tc.policy, tc.grace, tc.maxCatchup); (err == nil) != tc.ok {
+			t.Errorf("ValidateMisfire(%q, %d, %d) = %v", tc.policy, tc.grace, tc.maxCatchup, err)
+		}
+	}
+}
@@ -2,35 +2,98 @@ package scheduler
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"time"
 
-	"github.com/robfig/cron/v3"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"localflow/internal/domain"
 	"localflow/internal/queue"
 )
 
+// leaseName is the lease that elects which of the processes sharing a
+// database runs the scheduler.
+const leaseName = "scheduler"
+
 type Service struct {
 	repo     queue.Repository
-	cron     *cron.Cron
 	stop     chan struct{}
 	interval time.Duration
+	owner    string // identifies this process in the leader lease
+	leader   bool
+
+	maxFires int // tasks enqueued per tick before the rest wait, 0 for no limit
+	// deferred holds due schedules left for a later tick by maxFires, with
+	// the tick that first deferred them.
+	deferred map[string]time.Time
 }
 
 func NewService(repo queue.Repository, checkInterval time.Duration) *Service {
+	host, _ := os.Hostname()
 	return &Service{
 		repo:     repo,
-		cron:     cron.New(),
 		stop:     make(chan struct{}),
 		interval: checkInterval,
+		owner:    fmt.Sprintf("%s/%d/%s", host, os.Getpid(), uuid.NewString()[:8]),
+	}
+}
+
+// WithMaxFiresPerTick spreads fires over several ticks: once a tick has
+// enqueued n tasks the remaining due schedules wait for the next one,
+// oldest first. A schedule's catch-up fires are never split. n <= 0
+// removes the limit.
+func (s *Service) WithMaxFiresPerTick(n int) *Service {
+	s.maxFires = max(n, 0)
+	return s
+}
+
+// leaseTTL is how long the leader's lease lasts without renewal, and so
+// how long a standby waits before taking over from a leader that died.
+func (s *Service) leaseTTL() time.Duration {
+	return max(3*s.interval, 15*time.Second)
+}
+
+// acquireLeadership takes or renews the scheduler lease and reports
+// whether this process is the leader.
+func (s *Service) acquireLeadership(ctx context.Context, now time.Time) bool {
+	ok, err := s.repo.AcquireLease(ctx, leaseName, s.owner, s.leaseTTL(), now)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to acquire scheduler lease")
+		ok = false
+	}
+	if ok != s.leader {
+		if ok {
+			log.Info().Str("owner", s.owner).Msg("acquired scheduler lease, running schedules")
+		} else {
+			log.Warn().Str("owner", s.owner).Msg("lost scheduler lease, standing by")
+		}
+		s.leader = ok
+		s.deferred = nil
+	}
+	return ok
+}
+
+// releaseLeadership hands the lease over on shutdown.
+func (s *Service) releaseLeadership() {
+	if !s.leader {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.repo.ReleaseLease(ctx, leaseName, s.owner); err != nil {
+		log.Error().Err(err).Msg("failed to release scheduler lease")
 	}
+	s.leader = false
 }
 
 func (s *Service) Start(ctx context.Context) {
 	ticker := time.NewTicker(s.interval)
 	defer ticker.Stop()
 
-	log.Info().Dur("interval", s.interval).Msg("schedule service started")
+	log.Info().Dur("interval", s.interval).Str("owner", s.owner).Msg("schedule service started")
+	defer s.releaseLeadership()
+	s.checkPayloads(ctx)
 
 	for {
 		select {
@@ -39,7 +102,32 @@ func (s *Service) Start(ctx context.Context) {
 		case <-s.stop:
 			return
 		case now := <-ticker.C:
-			s.processDueSchedules(ctx, now)
+			if s.acquireLeadership(ctx, now) {
+				s.processDueSchedules(ctx, now)
+			}
+		}
+	}
+}
+
+// checkPayloads warns about enabled schedules whose payload doesn't render.
+// Payloads are validated when a schedule is saved, but schedules saved
+// before payload templates existed may contain a literal "{{" and would
+// otherwise be skipped on every fire with only a per-run error.
+func (s *Service) checkPayloads(ctx context.Context) {
+	schedules, err := s.repo.ListSchedules(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list schedules for payload check")
+		return
+	}
+	for _, schedule := range schedules {
+		if !schedule.Enabled {
+			continue
+		}
+		if err := ValidatePayload(schedule.Payload); err != nil {
+			log.Warn().Err(err).
+				Str("schedule_id", schedule.ID).
+				Str("schedule_name", schedule.Name).
+				Msg("schedule payload is not a valid template, every run will be skipped until it is fixed")
 		}
 	}
 }
@@ -55,65 +143,215 @@ func (s *Service) processDueSchedules(ctx context.Context, now time.Time) {
 		return
 	}
 
-	for _, schedule := range schedules {
-		if err := s.processSchedule(ctx, schedule, now); err != nil {
+	fired := 0
+	for i, schedule := range schedules {
+		if s.maxFires > 0 && fired >= s.maxFires {
+			s.deferRest(schedules[i:], now)
+			log.Info().Int("fired", fired).Int("deferred", len(schedules)-i).
+				Msg("max fires per tick reached, deferring due schedules")
+			return
+		}
+		n, err := s.processSchedule(ctx, schedule, now)
+		if err != nil {
 			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to process schedule")
 		}
+		fired += n
+	}
+	s.deferred = nil
+}
+
+// deferRest remembers the schedules a tick left due, keeping the time of
+// their first deferral across ticks.
+func (s *Service) deferRest(rest []domain.Schedule, now time.Time) {
+	deferred := make(map[string]time.Time, len(rest))
+	for _, schedule := range rest {
+		since, ok := s.deferred[schedule.ID]
+		if !ok {
+			since = now
+		}
+		deferred[schedule.ID] = since
 	}
+	s.deferred = deferred
 }
 
-func (s *Service) processSchedule(ctx context.Context, schedule domain.Schedule, now time.Time) error {
+// processSchedule handles one due schedule and returns how many tasks it
+// enqueued.
+func (s *Service) processSchedule(ctx context.Context, schedule domain.Schedule, now time.Time) (int, error) {
 	// Parse cron expression to get next run time
-	cronSchedule, err := cron.ParseStandard(schedule.CronExpr)
+	cronSchedule, err := ScheduleSpec(ctx, s.repo, schedule)
 	if err != nil {
 		log.Error().Err(err).Str("cron_expr", schedule.CronExpr).Msg("invalid cron expression")
+		return 0, err
+	}
+
+	// Calculate next run time
+	nextRun := cronSchedule.Next(now)
+
+	fires, truncated := dueFires(cronSchedule, schedule.NextRun, now)
+	if truncated {
+		log.Warn().Str("schedule_id", schedule.ID).Int("recorded", len(fires)).
+			Msg("too many missed fires, later ones dropped without record")
+	}
+	// Time spent waiting for a tick with room under maxFires doesn't make
+	// a fire late.
+	asOf := now
+	if since, ok := s.deferred[schedule.ID]; ok {
+		asOf = since
+	}
+	run, skip := planFires(schedule, fires, asOf, s.misfireGrace(schedule))
+
+	if len(skip) > 0 {
+		// Hold next_run at the first fire still to run so a crash before
+		// it is enqueued doesn't lose it.
+		next := nextRun
+		if len(run) > 0 {
+			next = run[0]
+		}
+		if err := s.repo.SkipScheduleRuns(ctx, schedule.ID, skip, skipReason(schedule), next); err != nil {
+			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to record skipped runs")
+			return 0, err
+		}
+		log.Warn().
+			Str("schedule_id", schedule.ID).
+			Str("schedule_name", schedule.Name).
+			Int("skipped", len(skip)).
+			Time("first", skip[0]).
+			Time("last", skip[len(skip)-1]).
+			Msg("schedule misfired")
+	}
+	if len(run) == 0 && len(skip) == 0 && !nextRun.IsZero() {
+		// Every due fire was excluded or before start_at; move next_run on
+		// so the schedule doesn't stay due.
+		if err := s.repo.SkipScheduleRuns(ctx, schedule.ID, nil, "", nextRun); err != nil {
+			log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to update next run")
+			return 0, err
+		}
+	}
+
+	fired := 0
+	for i, fireTime := range run {
+		next := nextRun
+		if i+1 < len(run) {
+			next = run[i+1]
+		}
+		enqueued, err := s.fire(ctx, schedule, fireTime, now, next)
+		if err != nil {
+			return fired, err
+		}
+		if enqueued {
+			fired++
+			schedule.RunCount++
+			schedule.LastRun = &now
+		}
+	}
+	if nextRun.IsZero() {
+		return fired, s.finish(ctx, schedule.ID)
+	}
+	return fired, nil
+}
+
+// finish disables a schedule that will not fire again: a one-shot @at
+// schedule that ran or one past its end time.
+func (s *Service) finish(ctx context.Context, id string) error {
+	schedule, err := s.repo.GetSchedule(ctx, id)
+	if err != nil {
+		return err
+	}
+	schedule.Enabled = false
+	if err := s.repo.UpdateSchedule(ctx, schedule); err != nil {
 		return err
 	}
+	log.Info().Str("schedule_id", id).Str("schedule_name", schedule.Name).Msg("schedule has no future runs, disabled")
+	return nil
+}
 
-	// Enqueue the task
-	task := domain.Task{
-		Type:        schedule.TaskType,
-		Payload:     schedule.Payload,
-		Priority:    schedule.Priority,
-		MaxAttempts: schedule.MaxAttempts,
+// fire enqueues the task for one fire and moves the schedule on to next.
+// It reports whether the fire enqueued a new task: false when it was
+// skipped and recorded as a misfire, or when another scheduler had already
+// enqueued its task.
+func (s *Service) fire(ctx context.Context, schedule domain.Schedule, fireTime, now, next time.Time) (bool, error) {
+	skip := func(reason string) (bool, error) {
+		return false, s.repo.SkipScheduleRuns(ctx, schedule.ID, []time.Time{fireTime}, reason, next)
+	}
+
+	ok, err := Admit(ctx, s.repo, schedule)
+	if err != nil {
+		log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to check previous runs")
+		return false, err
+	}
+	if !ok {
+		log.Info().Str("schedule_id", schedule.ID).Time("fire_time", fireTime).Msg("previous run still active, skipping fire")
+		return skip("previous run still active (policy forbid)")
+	}
+
+	// Templates see the cron time, not the time jitter delayed it to.
+	task, err := TaskFromSchedule(schedule, fireTime.Add(-JitterOffset(schedule)), now)
+	if err != nil {
+		// A payload that can't be rendered would fail on every tick, so
+		// skip this run and move on to the next one.
+		log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to render schedule payload")
+		return skip("payload render failed: " + err.Error())
 	}
+	// A leader that stalled past its lease may fire alongside its
+	// successor; the key makes the second enqueue return the first task.
+	key := fireKey(schedule.ID, fireTime)
+	task.IdempotencyKey = &key
 
+	// Enqueue the task
+	task.ID = queue.NewTaskID()
 	taskID, err := s.repo.Enqueue(ctx, task)
 	if err != nil {
 		log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to enqueue scheduled task")
-		return err
+		return false, err
 	}
-
-	// Calculate next run time
-	nextRun := cronSchedule.Next(now)
+	// A different ID is the task the other scheduler enqueued for this
+	// fire, which it already counted.
+	newRun := taskID == task.ID
 
 	// Update schedule's last run and next run
-	if err := s.repo.UpdateScheduleLastRun(ctx, schedule.ID, now, nextRun); err != nil {
+	if err := s.repo.UpdateScheduleLastRun(ctx, schedule.ID, now, next, newRun); err != nil {
 		log.Error().Err(err).Str("schedule_id", schedule.ID).Msg("failed to update schedule run times")
-		return err
+		return false, err
 	}
 
+	msg := "scheduled task enqueued"
+	if !newRun {
+		msg = "scheduled task already enqueued for this fire"
+	}
 	log.Info().
 		Str("schedule_id", schedule.ID).
 		Str("schedule_name", schedule.Name).
 		Str("task_id", taskID).
-		Time("next_run", nextRun).
-		Msg("scheduled task enqueued")
+		Time("fire_time", fireTime).
+		Time("next_run", next).
+		Msg(msg)
 
-	return nil
+	return newRun, nil
 }
 
-// ValidateCronExpression validates a cron expression
-func ValidateCronExpression(expr string) error {
-	_, err := cron.ParseStandard(expr)
-	return err
+// fireKey is the idempotency key of the task for one schedule fire.
+func fireKey(scheduleID string, fireTime time.Time) string {
+	return fmt.Sprintf("schedule:%s:%d", scheduleID, fireTime.Unix())
 }
 
-// NextRunTime calculates the next run time for a cron expression
-func NextRunTime(expr string, from time.Time) (time.Time, error) {
-	cronSchedule, err := cron.ParseStandard(expr)
+// TaskFromSchedule builds the task a schedule enqueues for the run due at
+// fireTime, rendering payload templates.
+func TaskFromSchedule(schedule domain.Schedule, fireTime, now time.Time) (domain.Task, error) {
+	payload, err := RenderPayload(schedule.Payload, payloadData(schedule, fireTime, now))
 	if err != nil {
-		return time.Time{}, err
+		return domain.Task{}, err
 	}
-	return cronSchedule.Next(from), nil
+	return domain.Task{
+		Type:        schedule.TaskType,
+		Payload:     payload,
+		Priority:    schedule.Priority,
+		MaxAttempts: schedule.MaxAttempts,
+		ScheduleID:  schedule.ID,
+	}, nil
+}
+
+// ValidateCronExpression validates a cron expression and time zone
+func ValidateCronExpression(expr, tz string) error {
+	_, err := Parse(expr, tz)
+	return err
 }
@@ -0,0 +1,146 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	_ "modernc.org/sqlite"
+)
+
+func newTestRepo(t *testing.T) queue.Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := queue.EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return queue.NewSQLiteRepo(db)
+}
+
+// TestFireCountsRunOnce fires the same schedule time twice, as two
+// schedulers sharing a database may, and checks only one run is counted.
+func TestFireCountsRunOnce(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	id, err := repo.CreateSchedule(ctx, domain.Schedule{
+		Name: "report", CronExpr: "* * * * *", TaskType: "shell",
+		Payload: []byte(`{"command":"true"}`), Enabled: true, NextRun: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, err := repo.GetSchedule(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(repo, time.Second)
+	next := now.Add(time.Minute)
+	for i, want := range []bool{true, false} {
+		if ok, err := s.fire(ctx, schedule, now, now, next); ok != want || err != nil {
+			t.Fatalf("fire %d = %v, %v; want %v", i, ok, err, want)
+		}
+	}
+	tasks, err := repo.ListTasks(ctx, queue.TaskFilter{ScheduleID: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Errorf("%d tasks enqueued, want 1", len(tasks))
+	}
+	if schedule, _ = repo.GetSchedule(ctx, id); schedule.RunCount != 1 {
+		t.Errorf("run count = %d, want 1", schedule.RunCount)
+	}
+
+	// The next fire is a new run.
+	if _, err := s.fire(ctx, schedule, next, next, next.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if schedule, _ = repo.GetSchedule(ctx, id); schedule.RunCount != 2 {
+		t.Errorf("run count = %d, want 2", schedule.RunCount)
+	}
+}
+
+// TestMaxFiresIgnoresDuplicates checks that a fire whose task another
+// scheduler already enqueued doesn't use up the tick's fire budget.
+func TestMaxFiresIgnoresDuplicates(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Now().UTC().Truncate(time.Second)
+	ids := make([]string, 2)
+	for i := range ids {
+		due := now.Add(time.Duration(i-2) * time.Second)
+		id, err := repo.CreateSchedule(ctx, domain.Schedule{
+			Name: fmt.Sprintf("s%d", i), CronExpr: "@every 1h", TaskType: "shell",
+			Payload: []byte(`{}`), Enabled: true, NextRun: due,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+	}
+	// The first schedule's fire was enqueued by another scheduler.
+	first, _ := repo.GetSchedule(ctx, ids[0])
+	key := fireKey(first.ID, first.NextRun)
+	if _, err := repo.Enqueue(ctx, domain.Task{Type: "shell", Payload: []byte(`{}`), ScheduleID: first.ID, IdempotencyKey: &key}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(repo, time.Second).WithMaxFiresPerTick(1)
+	s.processDueSchedules(ctx, now)
+	for i, id := range ids {
+		tasks, err := repo.ListTasks(ctx, queue.TaskFilter{ScheduleID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		schedule, _ := repo.GetSchedule(ctx, id)
+		if len(tasks) != 1 || schedule.RunCount != i {
+			t.Errorf("schedule %d: %d tasks, run count %d", i, len(tasks), schedule.RunCount)
+		}
+	}
+	if len(s.deferred) != 0 {
+		t.Errorf("deferred %v, want nothing", s.deferred)
+	}
+}
+
+// TestProcessScheduleAdvancesExcluded checks that a schedule whose due
+// fires were all excluded still moves its next run past now.
+func TestProcessScheduleAdvancesExcluded(t *testing.T) {
+	ctx := context.Background()
+	repo := newTestRepo(t)
+	now := time.Date(2026, 10, 18, 10, 1, 0, 0, time.UTC)
+	if err := repo.PutCalendar(ctx, domain.Calendar{Name: "holidays", Dates: []string{"2026-10-18"}}); err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.CreateSchedule(ctx, domain.Schedule{
+		Name: "hourly", CronExpr: "0 * * * *", TaskType: "shell", Payload: []byte(`{}`),
+		Enabled: true, NextRun: now.Add(-time.Minute), ExcludeCalendars: []string{"holidays"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule, _ := repo.GetSchedule(ctx, id)
+
+	s := NewService(repo, time.Second)
+	if fired, err := s.processSchedule(ctx, schedule, now); fired != 0 || err != nil {
+		t.Fatalf("processSchedule = %d, %v; want 0, nil", fired, err)
+	}
+	schedule, _ = repo.GetSchedule(ctx, id)
+	if want := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC); !schedule.NextRun.Equal(want) {
+		t.Errorf("next run = %v, want %v", schedule.NextRun, want)
+	}
+	if misfires, _ := repo.ListScheduleMisfires(ctx, id, 10); len(misfires) != 0 {
+		t.Errorf("%d misfires recorded, want none", len(misfires))
+	}
+}
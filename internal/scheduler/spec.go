@@ -0,0 +1,227 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// parser accepts standard 5-field expressions, an optional leading seconds
+// field and descriptors such as @daily and @every 90s.
+var parser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// atLayouts are the accepted times of one-shot "@at" expressions. Layouts
+// without an offset are read in the schedule's zone.
+var atLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// dateLayout is the format of calendar dates.
+const dateLayout = "2006-01-02"
+
+// Spec is a parsed schedule expression bound to the time zone it is
+// evaluated in, optionally limited to a window and excluding dates.
+type Spec struct {
+	loc   *time.Location
+	sched cron.Schedule
+	// wall evaluates the expression on wall clock time (see Next); nil for
+	// schedules that fire every hour and for @every intervals.
+	wall *cron.SpecSchedule
+	at   time.Time // fire time of a one-shot "@at" expression
+
+	start, end time.Time // zero when unbounded
+	exclude    map[string]bool
+	offset     time.Duration // added to every fire, see Delay
+}
+
+// Parse parses a schedule expression evaluated in the IANA zone tz: a
+// cron expression with 5 fields or 6 with leading seconds, a descriptor
+// such as @hourly or @every 90s, or "@at <time>" to fire once. The
+// expression may carry its own CRON_TZ= (or TZ=) prefix instead; an empty
+// zone means the server's local zone.
+func Parse(expr, tz string) (*Spec, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		prefix, rest, _ := strings.Cut(expr, " ")
+		_, exprTZ, _ := strings.Cut(prefix, "=")
+		if tz != "" && tz != exprTZ {
+			return nil, fmt.Errorf("timezone %q conflicts with %s in the cron expression", tz, prefix)
+		}
+		tz, expr = exprTZ, strings.TrimSpace(rest)
+	}
+	loc, err := LoadLocation(tz)
+	if err != nil {
+		return nil, err
+	}
+	if rest, ok := strings.CutPrefix(expr, "@at "); ok {
+		at, err := parseAt(strings.TrimSpace(rest), loc)
+		if err != nil {
+			return nil, err
+		}
+		return &Spec{loc: loc, at: at}, nil
+	}
+	sched, err := parser.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{loc: loc, sched: sched}
+	if spec, ok := sched.(*cron.SpecSchedule); ok {
+		spec.Location = loc
+		const allHours = 1<<24 - 1
+		if spec.Hour&allHours != allHours {
+			wall := *spec
+			wall.Location = time.UTC
+			s.wall = &wall
+		}
+	}
+	return s, nil
+}
+
+func parseAt(v string, loc *time.Location) (time.Time, error) {
+	for _, layout := range atLayouts {
+		if t, err := time.ParseInLocation(layout, v, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid @at time %q (want e.g. 2006-01-02T15:04)", v)
+}
+
+// Window limits fires to the range from start to end, both inclusive; nil
+// leaves that side open.
+func (s *Spec) Window(start, end *time.Time) *Spec {
+	if start != nil {
+		s.start = *start
+	}
+	if end != nil {
+		s.end = *end
+	}
+	return s
+}
+
+// Exclude drops fires on the given dates (YYYY-MM-DD in the schedule's
+// zone).
+func (s *Spec) Exclude(dates []string) *Spec {
+	if s.exclude == nil {
+		s.exclude = map[string]bool{}
+	}
+	for _, d := range dates {
+		s.exclude[d] = true
+	}
+	return s
+}
+
+// Delay shifts every fire d later. The window and excluded dates apply to
+// the times before the shift.
+func (s *Spec) Delay(d time.Duration) *Spec {
+	s.offset = d
+	return s
+}
+
+// LoadLocation resolves an IANA zone name; empty means the local zone.
+func LoadLocation(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", tz)
+	}
+	return loc, nil
+}
+
+// Location returns the zone the schedule is evaluated in.
+func (s *Spec) Location() *time.Location { return s.loc }
+
+// maxExcludedDays bounds how many excluded days Next skips in a row.
+const maxExcludedDays = 5 * 366
+
+// Next returns the first fire time after from, or the zero time when the
+// schedule will not fire again.
+func (s *Spec) Next(from time.Time) time.Time {
+	t := s.scheduled(from.Add(-s.offset))
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(s.offset)
+}
+
+// scheduled is Next without the Delay offset.
+func (s *Spec) scheduled(from time.Time) time.Time {
+	if !s.start.IsZero() && from.Before(s.start) {
+		from = s.start.Add(-time.Nanosecond)
+	}
+	for i := 0; i <= maxExcludedDays; i++ {
+		t := s.next(from)
+		if t.IsZero() || (!s.end.IsZero() && t.After(s.end)) {
+			return time.Time{}
+		}
+		if !s.exclude[t.In(s.loc).Format(dateLayout)] {
+			return t
+		}
+		// Skip the rest of the excluded day.
+		y, m, d := t.In(s.loc).Date()
+		from = time.Date(y, m, d+1, 0, 0, 0, 0, s.loc).Add(-time.Nanosecond)
+	}
+	return time.Time{}
+}
+
+// Excluded reports whether the fire at t falls on an excluded date.
+func (s *Spec) Excluded(t time.Time) bool {
+	return s.exclude[t.Add(-s.offset).In(s.loc).Format(dateLayout)]
+}
+
+// next returns the expression's first fire time after from.
+//
+// Schedules restricted to certain hours follow wall clock time across DST
+// changes: a time skipped when clocks spring forward fires once the gap is
+// over (02:30 becomes 03:30), and a time repeated when clocks fall back
+// fires only once. Schedules that run every hour fire on every real hour.
+func (s *Spec) next(from time.Time) time.Time {
+	if s.sched == nil {
+		if s.at.After(from) {
+			return s.at
+		}
+		return time.Time{}
+	}
+	if s.wall == nil {
+		return s.sched.Next(from)
+	}
+	w := wallClock(from.In(s.loc))
+	// Shortly after clocks sprang forward, times in the gap are shifted
+	// onto wall times at or after from's; search from before the gap so
+	// none of them is lost.
+	_, now := from.Zone()
+	if _, before := from.Add(-12 * time.Hour).Zone(); now > before {
+		w = w.Add(-time.Duration(now-before) * time.Second)
+	}
+	// Mapped times can be at or before from: a repeated wall time maps to
+	// its first occurrence and skipped times are shifted onto later ones.
+	// Move on to the next match then.
+	for i := 0; i < 2*60*60; i++ {
+		w = s.wall.Next(w)
+		if w.IsZero() {
+			return w
+		}
+		t := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, s.loc)
+		// The offset in effect before a DST change around t.
+		_, before := t.Add(-12 * time.Hour).Zone()
+		early := w.Add(-time.Duration(before) * time.Second).In(s.loc)
+		switch {
+		case !wallClock(t).Equal(w):
+			// w falls in a DST gap: apply the offset in effect before it.
+			t = early
+		case early.Before(t) && wallClock(early).Equal(w):
+			// w is repeated and time.Date picked the second occurrence.
+			t = early
+		}
+		if t.After(from) {
+			return t
+		}
+	}
+	return s.sched.Next(from)
+}
+
+// wallClock reinterprets t's local date and time in UTC, which has no DST.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+}
@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func utc(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// TestNextDST covers the DST changes of 2026: Europe/Berlin springs
+// forward at 02:00 on March 29 and falls back at 03:00 on October 25,
+// America/New_York at 02:00 on March 8 and November 1.
+func TestNextDST(t *testing.T) {
+	tests := []struct {
+		name, expr, tz, from string
+		want                 []string
+	}{
+		{
+			name: "gap fires after the gap",
+			expr: "30 2 * * *", tz: "Europe/Berlin", from: "2026-03-28T12:00:00Z",
+			// 03:30 CEST, then 02:30 CEST
+			want: []string{"2026-03-29T01:30:00Z", "2026-03-30T00:30:00Z"},
+		},
+		{
+			name: "gap keeps minutes apart",
+			expr: "0,30 2 * * *", tz: "Europe/Berlin", from: "2026-03-28T12:00:00Z",
+			want: []string{"2026-03-29T01:00:00Z", "2026-03-29T01:30:00Z", "2026-03-30T00:00:00Z"},
+		},
+		{
+			name: "overlap fires once",
+			expr: "30 2 * * *", tz: "Europe/Berlin", from: "2026-10-24T12:00:00Z",
+			// 02:30 CEST, the repeated 02:30 CET is skipped
+			want: []string{"2026-10-25T00:30:00Z", "2026-10-26T01:30:00Z"},
+		},
+		{
+			name: "overlap fires once from inside the overlap",
+			expr: "30 2 * * *", tz: "Europe/Berlin", from: "2026-10-25T01:10:00Z",
+			want: []string{"2026-10-26T01:30:00Z"},
+		},
+		{
+			name: "overlap fires each wall time once",
+			expr: "*/30 2 * * *", tz: "Europe/Berlin", from: "2026-10-24T23:45:00Z",
+			want: []string{"2026-10-25T00:00:00Z", "2026-10-25T00:30:00Z", "2026-10-26T01:00:00Z"},
+		},
+		{
+			name: "seconds in the gap",
+			expr: "0 0 2 * * *", tz: "Europe/Berlin", from: "2026-03-29T00:59:59Z",
+			want: []string{"2026-03-29T01:00:00Z", "2026-03-30T00:00:00Z"},
+		},
+		{
+			name: "hourly fires every real hour across the overlap",
+			expr: "0 * * * *", tz: "Europe/Berlin", from: "2026-10-24T23:30:00Z",
+			want: []string{"2026-10-25T00:00:00Z", "2026-10-25T01:00:00Z", "2026-10-25T02:00:00Z"},
+		},
+		{
+			name: "hourly skips nothing across the gap",
+			expr: "0 * * * *", tz: "Europe/Berlin", from: "2026-03-29T00:30:00Z",
+			want: []string{"2026-03-29T01:00:00Z", "2026-03-29T02:00:00Z"},
+		},
+		{
+			name: "daily keeps the wall clock",
+			expr: "0 9 * * *", tz: "America/New_York", from: "2026-03-07T00:00:00Z",
+			want: []string{"2026-03-07T14:00:00Z", "2026-03-08T13:00:00Z"},
+		},
+		{
+			name: "new york gap",
+			expr: "15 2 * * *", tz: "America/New_York", from: "2026-03-08T00:00:00Z",
+			// 03:15 EDT
+			want: []string{"2026-03-08T07:15:00Z", "2026-03-09T06:15:00Z"},
+		},
+		{
+			name: "new york overlap",
+			expr: "30 1 * * *", tz: "America/New_York", from: "2026-11-01T00:00:00Z",
+			// 01:30 EDT, then 01:30 EST the next day
+			want: []string{"2026-11-01T05:30:00Z", "2026-11-02T06:30:00Z"},
+		},
+		{
+			name: "CRON_TZ prefix",
+			expr: "CRON_TZ=Europe/Berlin 30 2 * * *", from: "2026-03-28T12:00:00Z",
+			want: []string{"2026-03-29T01:30:00Z"},
+		},
+		{
+			name: "utc has no gap",
+			expr: "30 2 * * *", tz: "UTC", from: "2026-03-28T12:00:00Z",
+			want: []string{"2026-03-29T02:30:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, err := Parse(tt.expr, tt.tz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := utc(tt.from)
+			for _, want := range tt.want {
+				got := spec.Next(from)
+				if !got.Equal(utc(want)) {
+					t.Fatalf("Next(%s) = %s, want %s", from.UTC().Format(time.RFC3339), got.UTC().Format(time.RFC3339), want)
+				}
+				from = got
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct{ expr, tz string }{
+		{"* * *", ""},
+		{"0 9 * * *", "Mars/Olympus"},
+		{"@at yesterday", ""},
+	} {
+		if _, err := Parse(tc.expr, tc.tz); err == nil {
+			t.Errorf("Parse(%q, %q) succeeded", tc.expr, tc.tz)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+// PayloadData is available to templates in schedule payloads, e.g.
+//
+//	{"command":"report","args":["{{(.FireTime.AddDate 0 0 -1).Format \"2006-01-02\"}}"]}
+type PayloadData struct {
+	// FireTime is the time the schedule was due; Now is when the task was
+	// actually enqueued.
+	FireTime time.Time
+	Now      time.Time
+	// LastRun is the previous fire time, zero if the schedule never ran.
+	LastRun      time.Time
+	ScheduleID   string
+	ScheduleName string
+	// RunCount numbers this run, starting at 1.
+	RunCount int
+}
+
+var payloadFuncs = template.FuncMap{
+	// date formats t with a Go reference layout.
+	"date": func(layout string, t time.Time) string { return t.Format(layout) },
+	// addDays shifts t by n days.
+	"addDays": func(n int, t time.Time) time.Time { return t.AddDate(0, 0, n) },
+	"unix":    func(t time.Time) int64 { return t.Unix() },
+	// secret references are left for the handler to resolve at run time,
+	// so secret values are never written into task payloads.
+	"secret": func(name string) string { return `{{secret ` + strconv.Quote(name) + `}}` },
+}
+
+// RenderPayload evaluates templates in the string values of a JSON payload.
+// Payloads without templates are returned unchanged; rendered strings are
+// re-encoded so the result is always valid JSON.
+func RenderPayload(payload []byte, data PayloadData) ([]byte, error) {
+	if !bytes.Contains(payload, []byte("{{")) {
+		return payload, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	doc, err := renderValue(doc, data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func renderValue(v any, data PayloadData) (any, error) {
+	switch v := v.(type) {
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		t, err := template.New("payload").Option("missingkey=error").Funcs(payloadFuncs).Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("payload template: %w", err)
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("payload template: %w", err)
+		}
+		return b.String(), nil
+	case map[string]any:
+		for k, item := range v {
+			r, err := renderValue(item, data)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = r
+		}
+	case []any:
+		for i, item := range v {
+			r, err := renderValue(item, data)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = r
+		}
+	}
+	return v, nil
+}
+
+// ValidatePayload renders payload with sample data so template errors are
+// reported when a schedule is saved rather than when it fires.
+func ValidatePayload(payload []byte) error {
+	now := time.Now()
+	_, err := RenderPayload(payload, PayloadData{
+		FireTime: now, Now: now, LastRun: now.Add(-time.Hour),
+		ScheduleID: "sch_validate", ScheduleName: "validate", RunCount: 1,
+	})
+	return err
+}
+
+// payloadData describes a run of schedule due at fireTime.
+func payloadData(schedule domain.Schedule, fireTime, now time.Time) PayloadData {
+	d := PayloadData{
+		FireTime:     fireTime,
+		Now:          now,
+		ScheduleID:   schedule.ID,
+		ScheduleName: schedule.Name,
+		RunCount:     schedule.RunCount + 1,
+	}
+	if schedule.LastRun != nil {
+		d.LastRun = *schedule.LastRun
+	}
+	// Render times in the schedule's zone so date formatting matches the
+	// wall clock the cron expression was written for.
+	if spec, err := Parse(schedule.CronExpr, schedule.Timezone); err == nil {
+		loc := spec.Location()
+		d.FireTime, d.Now = d.FireTime.In(loc), d.Now.In(loc)
+		if !d.LastRun.IsZero() {
+			d.LastRun = d.LastRun.In(loc)
+		}
+	}
+	return d
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned when no resolver knows a secret.
+var ErrNotFound = errors.New("secret not found")
+
+// Resolver looks up secret values by name at task execution time, so the
+// values never need to be stored in task payloads.
+type Resolver interface {
+	Resolve(ctx context.Context, name string) (string, error)
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// ValidName reports whether name can be used as a secret name.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// EnvPrefix is the prefix of environment variables holding secrets.
+const EnvPrefix = "LOCALFLOW_SECRET_"
+
+// Env resolves a secret from LOCALFLOW_SECRET_<NAME>, with the name upper
+// cased and '.' and '-' replaced by '_'.
+type Env struct{}
+
+func (Env) Resolve(_ context.Context, name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	key := EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+	if v, ok := os.LookupEnv(key); ok {
+		return v, nil
+	}
+	return "", ErrNotFound
+}
+
+// Dir resolves a secret from the file <dir>/<name>, as mounted by Docker or
+// Kubernetes. A single trailing newline is removed.
+type Dir string
+
+func (d Dir) Resolve(_ context.Context, name string) (string, error) {
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	b, err := os.ReadFile(filepath.Join(string(d), name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(b), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// Chain tries each resolver in order and returns the first value found.
+type Chain []Resolver
+
+func (c Chain) Resolve(ctx context.Context, name string) (string, error) {
+	for _, r := range c {
+		v, err := r.Resolve(ctx, name)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		return v, err
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+}
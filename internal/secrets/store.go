@@ -0,0 +1,131 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"localflow/internal/domain"
+)
+
+// MasterKeyEnv holds the base64 encoded 32 byte master key. It takes
+// precedence over a configured key file.
+const MasterKeyEnv = "LOCALFLOW_MASTER_KEY"
+
+// Repo persists encrypted secrets.
+type Repo interface {
+	PutSecret(ctx context.Context, name string, value []byte) error
+	GetSecret(ctx context.Context, name string) (domain.Secret, error)
+	ListSecrets(ctx context.Context) ([]domain.Secret, error)
+	DeleteSecret(ctx context.Context, name string) error
+}
+
+// Store keeps secrets in the database encrypted with AES-256-GCM under the
+// master key. Values can be written and resolved but are never listed.
+type Store struct {
+	repo Repo
+	aead cipher.AEAD
+}
+
+// NewStore returns a store encrypting with key, which must be 32 bytes.
+func NewStore(repo Repo, key []byte) (*Store, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{repo: repo, aead: aead}, nil
+}
+
+// LoadMasterKey reads the master key from LOCALFLOW_MASTER_KEY or, if that
+// is unset, from file. It returns nil when neither is configured.
+func LoadMasterKey(file string) ([]byte, error) {
+	encoded, ok := os.LookupEnv(MasterKeyEnv)
+	if !ok && file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read master key: %w", err)
+		}
+		encoded = string(b)
+	} else if !ok {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must decode to 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+// NewMasterKey returns a fresh base64 encoded master key.
+func NewMasterKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Put encrypts and stores value under name, replacing any previous value.
+func (s *Store) Put(ctx context.Context, name, value string) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// The name is authenticated data so ciphertexts can't be swapped
+	// between secrets in the database.
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return s.repo.PutSecret(ctx, name, sealed)
+}
+
+// Resolve decrypts the named secret.
+func (s *Store) Resolve(ctx context.Context, name string) (string, error) {
+	sec, err := s.repo.GetSecret(ctx, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	n := s.aead.NonceSize()
+	if len(sec.Value) < n {
+		return "", fmt.Errorf("secret %s: corrupt value", name)
+	}
+	plain, err := s.aead.Open(nil, sec.Value[:n], sec.Value[n:], []byte(name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: decrypt failed (wrong master key?)", name)
+	}
+	return string(plain), nil
+}
+
+// List returns secret metadata without values.
+func (s *Store) List(ctx context.Context) ([]domain.Secret, error) {
+	list, err := s.repo.ListSecrets(ctx)
+	for i := range list {
+		list[i].Value = nil
+	}
+	return list, err
+}
+
+func (s *Store) Delete(ctx context.Context, name string) error {
+	return s.repo.DeleteSecret(ctx, name)
+}
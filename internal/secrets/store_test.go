@@ -0,0 +1,118 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"localflow/internal/domain"
+)
+
+// memRepo is an in-memory Repo.
+type memRepo map[string][]byte
+
+func (m memRepo) PutSecret(_ context.Context, name string, value []byte) error {
+	m[name] = value
+	return nil
+}
+
+func (m memRepo) GetSecret(_ context.Context, name string) (domain.Secret, error) {
+	v, ok := m[name]
+	if !ok {
+		return domain.Secret{}, sql.ErrNoRows
+	}
+	return domain.Secret{Name: name, Value: v}, nil
+}
+
+func (m memRepo) ListSecrets(_ context.Context) ([]domain.Secret, error) {
+	var list []domain.Secret
+	for name, v := range m {
+		list = append(list, domain.Secret{Name: name, Value: v})
+	}
+	return list, nil
+}
+
+func (m memRepo) DeleteSecret(_ context.Context, name string) error {
+	delete(m, name)
+	return nil
+}
+
+func newTestStore(t *testing.T, repo Repo) *Store {
+	t.Helper()
+	encoded, err := NewMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := base64.StdEncoding.DecodeString(encoded)
+	s, err := NewStore(repo, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := memRepo{}
+	s := newTestStore(t, repo)
+	for _, value := range []string{"hunter2", "", "multi\nline ✓", string(bytes.Repeat([]byte("x"), 4096))} {
+		if err := s.Put(ctx, "api.key", value); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(repo["api.key"], []byte(value)) && value != "" {
+			t.Fatalf("value stored in plain text")
+		}
+		got, err := s.Resolve(ctx, "api.key")
+		if err != nil || got != value {
+			t.Errorf("Resolve = %q, %v; want %q", got, err, value)
+		}
+	}
+
+	// Each Put uses a fresh nonce.
+	s.Put(ctx, "a", "same")
+	first := append([]byte(nil), repo["a"]...)
+	s.Put(ctx, "a", "same")
+	if bytes.Equal(first, repo["a"]) {
+		t.Error("two encryptions of the same value are identical")
+	}
+
+	list, _ := s.List(ctx)
+	for _, sec := range list {
+		if sec.Value != nil {
+			t.Errorf("List returned the value of %s", sec.Name)
+		}
+	}
+}
+
+func TestStoreRejects(t *testing.T) {
+	ctx := context.Background()
+	repo := memRepo{}
+	s := newTestStore(t, repo)
+	if _, err := s.Resolve(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing secret: err = %v", err)
+	}
+	if err := s.Put(ctx, "../bad", "x"); err == nil {
+		t.Error("invalid name accepted")
+	}
+
+	// A ciphertext moved to another name fails authentication.
+	s.Put(ctx, "a", "value")
+	repo["b"] = repo["a"]
+	if _, err := s.Resolve(ctx, "b"); err == nil {
+		t.Error("swapped ciphertext decrypted")
+	}
+	// So does one decrypted with another key.
+	if _, err := newTestStore(t, repo).Resolve(ctx, "a"); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	repo["c"] = []byte("short")
+	if _, err := s.Resolve(ctx, "c"); err == nil {
+		t.Error("corrupt value decrypted")
+	}
+	if _, err := NewStore(repo, make([]byte, 16)); err == nil {
+		t.Error("16 byte key accepted")
+	}
+}
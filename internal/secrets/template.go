@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Expander replaces {{secret "name"}} references in payload strings when a
+// task runs. It remembers the values it substituted so they can be redacted
+// from error messages.
+type Expander struct {
+	ctx      context.Context
+	resolver Resolver
+	values   []string
+}
+
+// NewExpander returns an expander resolving through r, which may be nil if
+// no secrets are configured.
+func NewExpander(ctx context.Context, r Resolver) *Expander {
+	return &Expander{ctx: ctx, resolver: r}
+}
+
+// secretRef matches a {{secret "name"}} reference.
+var secretRef = regexp.MustCompile(`\{\{\s*secret\s+"([^"]*)"\s*\}\}`)
+
+// Expand replaces secret references in s. Everything else, including other
+// {{...}} text such as docker --format templates, is left as it is.
+func (e *Expander) Expand(s string) (string, error) {
+	var err error
+	out := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		var v string
+		v, err = e.secret(secretRef.FindStringSubmatch(ref)[1])
+		return v
+	})
+	if err != nil {
+		return "", fmt.Errorf("secret reference: %w", err)
+	}
+	return out, nil
+}
+
+func (e *Expander) secret(name string) (string, error) {
+	if e.resolver == nil {
+		return "", fmt.Errorf("secret %q: no secret store configured", name)
+	}
+	v, err := e.resolver.Resolve(e.ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if v != "" {
+		e.values = append(e.values, v)
+	}
+	return v, nil
+}
+
+// Add records secret values used outside Expand, e.g. credentials derived
+// from a secret, so they are redacted too.
+func (e *Expander) Add(values ...string) {
+	for _, v := range values {
+		if v != "" {
+			e.values = append(e.values, v)
+		}
+	}
+}
+
+// Values returns the secret values substituted so far.
+func (e *Expander) Values() []string {
+	return e.values
+}
+
+// Redact replaces every substituted secret value in s with "***".
+func (e *Expander) Redact(s string) string {
+	for _, v := range e.values {
+		s = strings.ReplaceAll(s, v, "***")
+	}
+	return s
+}
+
+// RedactError redacts substituted secrets from err's message. The original
+// error stays reachable through errors.Is and errors.As.
+func (e *Expander) RedactError(err error) error {
+	if err == nil || len(e.values) == 0 {
+		return err
+	}
+	return &redactedError{msg: e.Redact(err.Error()), err: err}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (r *redactedError) Error() string { return r.msg }
+func (r *redactedError) Unwrap() error { return r.err }
@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type mapResolver map[string]string
+
+func (m mapResolver) Resolve(_ context.Context, name string) (string, error) {
+	v, ok := m[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return v, nil
+}
+
+func TestExpand(t *testing.T) {
+	r := mapResolver{"token": "t0k", "db.pass": "p@ss"}
+	tests := []struct {
+		in, want string
+	}{
+		{`plain`, `plain`},
+		{`{{secret "token"}}`, `t0k`},
+		{`Bearer {{ secret  "token" }}`, `Bearer t0k`},
+		{`{{secret "token"}}:{{secret "db.pass"}}`, `t0k:p@ss`},
+		// Other template syntax passes through untouched.
+		{`{{.Names}}`, `{{.Names}}`},
+		{`{{json .}}`, `{{json .}}`},
+		{`{{.ID}} {{secret "token"}} {{.Status}}`, `{{.ID}} t0k {{.Status}}`},
+		{`{{secret token}}`, `{{secret token}}`},
+		{`{{ secrets "token" }}`, `{{ secrets "token" }}`},
+		{`{{`, `{{`},
+	}
+	for _, tt := range tests {
+		exp := NewExpander(context.Background(), r)
+		got, err := exp.Expand(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	exp := NewExpander(context.Background(), mapResolver{})
+	if _, err := exp.Expand(`x {{secret "missing"}}`); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing secret: err = %v, want ErrNotFound", err)
+	}
+	exp = NewExpander(context.Background(), nil)
+	if _, err := exp.Expand(`{{secret "token"}}`); err == nil {
+		t.Error("expanding without a resolver succeeded")
+	}
+	if got, err := exp.Expand(`{{.Names}}`); err != nil || got != `{{.Names}}` {
+		t.Errorf("Expand without a resolver = %q, %v", got, err)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	exp := NewExpander(context.Background(), mapResolver{"token": "t0k"})
+	if _, err := exp.Expand(`{{secret "token"}}`); err != nil {
+		t.Fatal(err)
+	}
+	exp.Add("derived", "")
+	err := exp.RedactError(errors.New("server said t0k and derived"))
+	if got := err.Error(); got != "server said *** and ***" {
+		t.Errorf("redacted error = %q", got)
+	}
+	if strings.Join(exp.Values(), ",") != "t0k,derived" {
+		t.Errorf("Values() = %q", exp.Values())
+	}
+	if exp.RedactError(nil) != nil {
+		t.Error("RedactError(nil) != nil")
+	}
+}
@@ -0,0 +1,211 @@
+package tasklog
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"localflow/internal/domain"
+)
+
+// Streams a log line can belong to. Handlers write to StreamLog through
+// Printf; process output uses stdout/stderr.
+const (
+	StreamLog    = "log"
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Store persists task log lines.
+type Store interface {
+	AppendTaskLogs(ctx context.Context, lines []domain.TaskLog) error
+}
+
+const (
+	flushEvery    = 500 * time.Millisecond
+	flushLines    = 200
+	maxLineLength = 4096
+)
+
+// Logger collects the log lines of one task attempt and writes them to the
+// store in batches, so handlers can stream output without a write per line.
+// A nil *Logger discards everything.
+type Logger struct {
+	store   Store
+	taskID  string
+	attempt int
+
+	mu      sync.Mutex
+	pending []domain.TaskLog
+	redact  []string
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New starts a logger for one attempt. Close must be called when the
+// attempt finishes to flush remaining lines.
+func New(store Store, taskID string, attempt int) *Logger {
+	l := &Logger{store: store, taskID: taskID, attempt: attempt, done: make(chan struct{})}
+	l.wg.Add(1)
+	go l.loop()
+	return l
+}
+
+func (l *Logger) loop() {
+	defer l.wg.Done()
+	t := time.NewTicker(flushEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-t.C:
+			l.flush()
+		}
+	}
+}
+
+// Append records a single line on the given stream.
+func (l *Logger) Append(stream, line string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	for _, v := range l.redact {
+		line = strings.ReplaceAll(line, v, "***")
+	}
+	l.pending = append(l.pending, domain.TaskLog{
+		TaskID: l.taskID, Attempt: l.attempt, Stream: stream, Line: line, CreatedAt: time.Now(),
+	})
+	full := len(l.pending) >= flushLines
+	l.mu.Unlock()
+	if full {
+		l.flush()
+	}
+}
+
+// Redact masks values, typically resolved secrets, in every line appended
+// from now on.
+func (l *Logger) Redact(values ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	for _, v := range values {
+		if v != "" {
+			l.redact = append(l.redact, v)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// Printf records a formatted message on StreamLog, one entry per line.
+func (l *Logger) Printf(format string, args ...any) {
+	if l == nil {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimRight(fmt.Sprintf(format, args...), "\n"), "\n") {
+		l.Append(StreamLog, line)
+	}
+}
+
+func (l *Logger) flush() {
+	l.mu.Lock()
+	lines := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(lines) == 0 {
+		return
+	}
+	// Logging must never fail a task, so store errors are dropped.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = l.store.AppendTaskLogs(ctx, lines)
+}
+
+// Close stops the background flusher and writes any pending lines.
+func (l *Logger) Close() {
+	if l == nil {
+		return
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.flush()
+}
+
+// Stream returns a writer that splits its input into lines on stream,
+// keeping at most maxBytes (0 for unlimited) before noting the truncation.
+// The writer's Close emits a trailing partial line.
+func (l *Logger) Stream(stream string, maxBytes int) *LineWriter {
+	return &LineWriter{l: l, stream: stream, max: maxBytes}
+}
+
+// LineWriter is an io.WriteCloser feeding a Logger stream line by line.
+type LineWriter struct {
+	l       *Logger
+	stream  string
+	max     int
+	written int
+	dropped int
+	buf     bytes.Buffer
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.max > 0 {
+		room := w.max - w.written
+		if room <= 0 {
+			w.dropped += n
+			return n, nil
+		}
+		if len(p) > room {
+			w.dropped += len(p) - room
+			p = p[:room]
+		}
+		w.written += len(p)
+	}
+	w.buf.Write(p)
+	for {
+		i := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf.Next(i + 1)
+		w.l.Append(w.stream, string(bytes.TrimRight(line, "\r\n")))
+	}
+	for w.buf.Len() >= maxLineLength {
+		w.l.Append(w.stream, string(w.buf.Next(maxLineLength)))
+	}
+	return n, nil
+}
+
+func (w *LineWriter) Close() error {
+	if w.buf.Len() > 0 {
+		w.l.Append(w.stream, w.buf.String())
+		w.buf.Reset()
+	}
+	if w.dropped > 0 {
+		w.l.Append(w.stream, fmt.Sprintf("[output truncated: %d bytes dropped]", w.dropped))
+		w.dropped = 0
+	}
+	return nil
+}
+
+type ctxKey struct{}
+
+// WithLogger attaches a logger to a handler context.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// FromContext returns the attempt logger, or nil if there is none. All
+// Logger methods are safe to call on nil, so handlers can simply do
+//
+//	tasklog.FromContext(ctx).Printf("processed %d rows", n)
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(ctxKey{}).(*Logger)
+	return l
+}
@@ -0,0 +1,386 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	"localflow/internal/scheduler"
+)
+
+// Conflict policies for records whose calendar name, schedule ID or name,
+// or task ID already exists.
+const (
+	ConflictSkip      = "skip"      // keep what is there
+	ConflictOverwrite = "overwrite" // replace it with the imported record
+	ConflictRename    = "rename"    // import the record under a new name or ID
+)
+
+// ErrInvalid wraps problems with an import document. They are found
+// before anything is written.
+var ErrInvalid = errors.New("invalid export")
+
+// errLookup marks database errors met while validating.
+var errLookup = errors.New("validating export")
+
+// ValidateConflict checks a conflict policy.
+func ValidateConflict(policy string) error {
+	switch policy {
+	case ConflictSkip, ConflictOverwrite, ConflictRename:
+		return nil
+	}
+	return fmt.Errorf("invalid conflict policy %q (want skip, overwrite or rename)", policy)
+}
+
+// Counts tallies what happened to the records of one kind.
+type Counts struct {
+	Created     int `json:"created"`
+	Overwritten int `json:"overwritten"`
+	Renamed     int `json:"renamed"`
+	Skipped     int `json:"skipped"`
+}
+
+// Result summarizes an import. Warnings explain records skipped for
+// reasons other than the conflict policy.
+type Result struct {
+	Calendars Counts   `json:"calendars"`
+	Schedules Counts   `json:"schedules"`
+	Tasks     Counts   `json:"tasks"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func (r *Result) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// Import writes doc's records to repo, calendars first so schedules can
+// refer to renamed ones, then schedules, then tasks. The document is
+// validated before the first write.
+//
+// Schedules keep their exported next run, so fires missed between export
+// and import are handled by their misfire policy. Imported schedules are
+// never file-managed, and a file-managed schedule is not overwritten.
+// Running tasks are imported as queued, since no worker holds them; a
+// running task is not overwritten.
+func Import(ctx context.Context, repo queue.Repository, doc Document, conflict string) (Result, error) {
+	var res Result
+	if err := ValidateConflict(conflict); err != nil {
+		return res, err
+	}
+	if err := validate(ctx, repo, doc); err != nil {
+		if !errors.Is(err, errLookup) {
+			err = fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+		return res, err
+	}
+
+	calendars, err := importCalendars(ctx, repo, doc.Calendars, conflict, &res)
+	if err != nil {
+		return res, err
+	}
+	schedules, err := importSchedules(ctx, repo, doc.Schedules, calendars, conflict, &res)
+	if err != nil {
+		return res, err
+	}
+	if err := importTasks(ctx, repo, doc.Tasks, schedules, conflict, &res); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func validate(ctx context.Context, repo queue.Repository, doc Document) error {
+	calendars := map[string]bool{}
+	for _, c := range doc.Calendars {
+		if !scheduler.ValidCalendarName(c.Name) {
+			return fmt.Errorf("calendar %q: invalid name", c.Name)
+		}
+		if _, err := scheduler.NormalizeDates(c.Dates); err != nil {
+			return fmt.Errorf("calendar %q: %w", c.Name, err)
+		}
+		calendars[c.Name] = true
+	}
+	for _, s := range doc.Schedules {
+		fail := func(err error) error {
+			return fmt.Errorf("schedule %q (%s): %w", s.Name, s.ID, err)
+		}
+		if s.ID == "" || s.Name == "" || s.TaskType == "" {
+			return fail(errors.New("id, name and task_type are required"))
+		}
+		if err := scheduler.ValidateCronExpression(s.CronExpr, s.Timezone); err != nil {
+			return fail(fmt.Errorf("invalid cron expression: %w", err))
+		}
+		if err := scheduler.ValidatePayload(s.Payload); err != nil {
+			return fail(err)
+		}
+		if err := scheduler.ValidateMisfire(s.MisfirePolicy, s.MisfireGrace, s.MaxCatchup); err != nil {
+			return fail(err)
+		}
+		if err := scheduler.ValidateConcurrency(s.ConcurrencyPolicy); err != nil {
+			return fail(err)
+		}
+		if err := scheduler.ValidateJitter(s.Jitter); err != nil {
+			return fail(err)
+		}
+		for _, name := range s.ExcludeCalendars {
+			if calendars[name] {
+				continue
+			}
+			_, err := repo.GetCalendar(ctx, name)
+			if errors.Is(err, sql.ErrNoRows) {
+				return fail(fmt.Errorf("unknown calendar %q", name))
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %w", errLookup, err)
+			}
+		}
+	}
+	for _, t := range doc.Tasks {
+		if t.ID == "" || t.Type == "" {
+			return fmt.Errorf("task %q: id and type are required", t.ID)
+		}
+		if !slices.Contains(TaskStates, t.State) {
+			return fmt.Errorf("task %s: unknown state %q", t.ID, t.State)
+		}
+	}
+	return nil
+}
+
+// importCalendars returns the name each imported calendar ended up under.
+func importCalendars(ctx context.Context, repo queue.Repository, calendars []Calendar, conflict string, res *Result) (map[string]string, error) {
+	existing, err := repo.ListCalendars(ctx)
+	if err != nil {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, c := range existing {
+		taken[c.Name] = true
+	}
+
+	names := map[string]string{}
+	for _, c := range calendars {
+		dates, _ := scheduler.NormalizeDates(c.Dates)
+		cal := domain.Calendar{Name: c.Name, Description: c.Description, Dates: dates}
+		names[c.Name] = c.Name
+		switch {
+		case !taken[c.Name]:
+			res.Calendars.Created++
+		case conflict == ConflictSkip:
+			res.Calendars.Skipped++
+			continue
+		case conflict == ConflictOverwrite:
+			res.Calendars.Overwritten++
+		case conflict == ConflictRename:
+			cal.Name = uniqueName(c.Name, func(n string) bool { return taken[n] })
+			names[c.Name] = cal.Name
+			res.Calendars.Renamed++
+		}
+		if err := repo.PutCalendar(ctx, cal); err != nil {
+			return nil, err
+		}
+		taken[cal.Name] = true
+	}
+	return names, nil
+}
+
+// importSchedules returns the ID each imported schedule ended up under, or
+// the ID of the existing schedule that took its place.
+func importSchedules(ctx context.Context, repo queue.Repository, schedules []Schedule, calendars map[string]string, conflict string, res *Result) (map[string]string, error) {
+	existing, err := repo.ListSchedules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[string]domain.Schedule{}
+	byName := map[string][]domain.Schedule{}
+	for _, s := range existing {
+		byID[s.ID] = s
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+
+	ids := map[string]string{}
+	for _, in := range schedules {
+		s := importSchedule(in, calendars)
+		ids[in.ID] = in.ID
+
+		have, found := byID[s.ID]
+		ambiguous := false
+		if !found {
+			switch matches := byName[s.Name]; len(matches) {
+			case 0:
+			case 1:
+				have, found = matches[0], true
+			default:
+				found, ambiguous = true, true
+			}
+		}
+
+		switch {
+		case !found:
+			res.Schedules.Created++
+		case conflict == ConflictSkip:
+			if !ambiguous {
+				ids[in.ID] = have.ID
+			}
+			res.Schedules.Skipped++
+			continue
+		case conflict == ConflictOverwrite && ambiguous:
+			res.warn("schedule %q (%s) skipped: %d existing schedules have this name", s.Name, in.ID, len(byName[s.Name]))
+			res.Schedules.Skipped++
+			continue
+		case conflict == ConflictOverwrite && have.Source != "":
+			res.warn("schedule %q (%s) skipped: managed by %s", s.Name, in.ID, have.Source)
+			ids[in.ID] = have.ID
+			res.Schedules.Skipped++
+			continue
+		case conflict == ConflictOverwrite:
+			// Keep the existing row's identity and run history.
+			s.ID, s.LastRun, s.RunCount = have.ID, have.LastRun, have.RunCount
+			if err := repo.UpdateSchedule(ctx, s); err != nil {
+				return nil, err
+			}
+			ids[in.ID] = have.ID
+			res.Schedules.Overwritten++
+			continue
+		case conflict == ConflictRename:
+			s.ID = queue.NewScheduleID()
+			s.Name = uniqueName(s.Name, func(n string) bool { return len(byName[n]) > 0 })
+			ids[in.ID] = s.ID
+			res.Schedules.Renamed++
+		}
+		if _, err := repo.CreateSchedule(ctx, s); err != nil {
+			return nil, err
+		}
+		byID[s.ID] = s
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	return ids, nil
+}
+
+func importSchedule(in Schedule, calendars map[string]string) domain.Schedule {
+	exclude := make([]string, 0, len(in.ExcludeCalendars))
+	for _, name := range in.ExcludeCalendars {
+		if renamed, ok := calendars[name]; ok {
+			name = renamed
+		}
+		exclude = append(exclude, name)
+	}
+	return domain.Schedule{
+		ID:                in.ID,
+		Name:              in.Name,
+		CronExpr:          in.CronExpr,
+		Timezone:          in.Timezone,
+		TaskType:          in.TaskType,
+		Payload:           in.Payload,
+		Priority:          in.Priority,
+		MaxAttempts:       in.MaxAttempts,
+		Enabled:           in.Enabled,
+		LastRun:           in.LastRun,
+		NextRun:           in.NextRun,
+		RunCount:          in.RunCount,
+		MisfirePolicy:     in.MisfirePolicy,
+		MisfireGrace:      in.MisfireGrace,
+		MaxCatchup:        in.MaxCatchup,
+		ConcurrencyPolicy: in.ConcurrencyPolicy,
+		StartAt:           in.StartAt,
+		EndAt:             in.EndAt,
+		ExcludeCalendars:  exclude,
+		Jitter:            in.Jitter,
+	}
+}
+
+func importTasks(ctx context.Context, repo queue.Repository, tasks []Task, schedules map[string]string, conflict string, res *Result) error {
+	for _, in := range tasks {
+		t, attempts := importTask(in, schedules)
+
+		have, err := repo.Get(ctx, t.ID)
+		found := err == nil
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		counter := &res.Tasks.Created
+		replace := false
+		switch {
+		case !found:
+		case conflict == ConflictSkip:
+			res.Tasks.Skipped++
+			continue
+		case conflict == ConflictOverwrite && have.State == "running":
+			res.warn("task %s skipped: running", t.ID)
+			res.Tasks.Skipped++
+			continue
+		case conflict == ConflictOverwrite:
+			counter, replace = &res.Tasks.Overwritten, true
+		case conflict == ConflictRename:
+			// The copy gets a new ID and no key, which names the original.
+			t.ID, t.IdempotencyKey = queue.NewTaskID(), nil
+			counter = &res.Tasks.Renamed
+		}
+
+		err = repo.ImportTask(ctx, t, attempts, replace)
+		if errors.Is(err, queue.ErrKeyConflict) && conflict == ConflictRename {
+			// Another task holds the key; import this one without it.
+			t.IdempotencyKey = nil
+			err = repo.ImportTask(ctx, t, attempts, replace)
+		}
+		if errors.Is(err, queue.ErrKeyConflict) {
+			res.warn("task %s skipped: %v", in.ID, err)
+			res.Tasks.Skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		*counter++
+	}
+	return nil
+}
+
+func importTask(in Task, schedules map[string]string) (domain.Task, []domain.TaskAttempt) {
+	t := domain.Task{
+		ID:                in.ID,
+		Type:              in.Type,
+		Payload:           []byte(in.PayloadText),
+		Priority:          in.Priority,
+		Attempts:          in.Attempts,
+		MaxAttempts:       in.MaxAttempts,
+		State:             in.State,
+		NextRunAt:         in.NextRunAt,
+		VisibilityTimeout: in.VisibilityTimeout,
+		IdempotencyKey:    in.IdempotencyKey,
+		Progress:          in.Progress,
+		StatusMessage:     in.StatusMessage,
+		ScheduleID:        in.ScheduleID,
+		CreatedAt:         in.CreatedAt,
+		UpdatedAt:         in.UpdatedAt,
+		ExpiresAt:         in.ExpiresAt,
+		UniqueKey:         in.UniqueKey,
+	}
+	if in.Payload != nil {
+		t.Payload = []byte(in.Payload)
+	}
+	if id, ok := schedules[t.ScheduleID]; ok {
+		t.ScheduleID = id
+	}
+	if t.State == "running" {
+		t.State = "queued"
+	}
+	attempts := make([]domain.TaskAttempt, 0, len(in.AttemptLog))
+	for _, a := range in.AttemptLog {
+		attempts = append(attempts, domain.TaskAttempt{StartedAt: a.StartedAt, FinishedAt: a.FinishedAt, Success: a.Success, Error: a.Error})
+	}
+	return t, attempts
+}
+
+// uniqueName returns name with an "-imported" suffix, numbered if needed,
+// that taken reports as free.
+func uniqueName(name string, taken func(string) bool) string {
+	out := name + "-imported"
+	for i := 2; taken(out); i++ {
+		out = fmt.Sprintf("%s-imported-%d", name, i)
+	}
+	return out
+}
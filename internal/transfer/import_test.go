@@ -0,0 +1,274 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+	_ "modernc.org/sqlite"
+)
+
+func newTestRepo(t *testing.T) queue.Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?mode=rwc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := queue.EnsureSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return queue.NewSQLiteRepo(db)
+}
+
+func testTask(id, state string, key *string) domain.Task {
+	now := time.Now().UTC().Truncate(time.Second)
+	return domain.Task{
+		ID: id, Type: "shell", Payload: []byte(`{"command":"true"}`), State: state,
+		MaxAttempts: 3, NextRunAt: now, CreatedAt: now, UpdatedAt: now, IdempotencyKey: key,
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRepo(t)
+	if err := src.PutCalendar(ctx, domain.Calendar{Name: "holidays", Dates: []string{"2026-12-25"}}); err != nil {
+		t.Fatal(err)
+	}
+	next := time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC)
+	schedule := domain.Schedule{
+		ID: "sch_1", Name: "nightly", CronExpr: "0 1 * * *", TaskType: "shell",
+		Payload: []byte(`{"command":"true"}`), MaxAttempts: 3, Enabled: true, NextRun: next,
+		RunCount: 4, MisfirePolicy: domain.MisfireSkip, MisfireGrace: 30,
+		ExcludeCalendars: []string{"holidays"},
+	}
+	if _, err := src.CreateSchedule(ctx, schedule); err != nil {
+		t.Fatal(err)
+	}
+	done := testTask("tsk_done", "succeeded", nil)
+	done.ScheduleID, done.Attempts = "sch_1", 1
+	finished := done.CreatedAt.Add(time.Second)
+	attempts := []domain.TaskAttempt{{StartedAt: done.CreatedAt, FinishedAt: &finished, Success: true}}
+	if err := src.ImportTask(ctx, done, attempts, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.ImportTask(ctx, testTask("tsk_running", "running", nil), nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, document := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := Export(ctx, src, &buf, ExportOptions{TaskStates: TaskStates, Document: document}); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := Read(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := newTestRepo(t)
+		res, err := Import(ctx, dst, doc, ConflictSkip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Calendars.Created != 1 || res.Schedules.Created != 1 || res.Tasks.Created != 2 || len(res.Warnings) != 0 {
+			t.Errorf("document=%v: result %+v", document, res)
+		}
+
+		cal, err := dst.GetCalendar(ctx, "holidays")
+		if err != nil || !slices.Equal(cal.Dates, []string{"2026-12-25"}) {
+			t.Errorf("document=%v: calendar %+v, %v", document, cal, err)
+		}
+		got, err := dst.GetSchedule(ctx, "sch_1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "nightly" || got.CronExpr != schedule.CronExpr || !got.NextRun.Equal(next) ||
+			got.RunCount != 4 || got.MisfirePolicy != domain.MisfireSkip || got.MisfireGrace != 30 ||
+			!slices.Equal(got.ExcludeCalendars, []string{"holidays"}) {
+			t.Errorf("document=%v: schedule %+v", document, got)
+		}
+		task, err := dst.Get(ctx, "tsk_done")
+		if err != nil || task.State != "succeeded" || task.ScheduleID != "sch_1" {
+			t.Errorf("document=%v: task %+v, %v", document, task, err)
+		}
+		if log, _ := dst.ListTaskAttempts(ctx, "tsk_done"); len(log) != 1 || !log[0].Success {
+			t.Errorf("document=%v: attempts %+v", document, log)
+		}
+		// No worker holds it on the new instance.
+		if task, _ := dst.Get(ctx, "tsk_running"); task.State != "queued" {
+			t.Errorf("document=%v: running task imported as %s, want queued", document, task.State)
+		}
+	}
+}
+
+func TestImportConflicts(t *testing.T) {
+	key := "report-2026-10-18"
+	tests := []struct {
+		policy    string
+		want      Result
+		dates     []string // of the existing calendar afterwards
+		cron      string   // of the existing schedule afterwards
+		payload   string   // of the existing task afterwards
+		schedules []string // names afterwards
+	}{
+		{
+			policy: ConflictSkip,
+			want: Result{
+				Calendars: Counts{Skipped: 1}, Schedules: Counts{Skipped: 1}, Tasks: Counts{Skipped: 2},
+			},
+			dates: []string{"2026-01-01"}, cron: "0 1 * * *", payload: `{"command":"old"}`,
+			schedules: []string{"nightly"},
+		},
+		{
+			policy: ConflictOverwrite,
+			want: Result{
+				Calendars: Counts{Overwritten: 1}, Schedules: Counts{Overwritten: 1},
+				// The second task's key is held by the first.
+				Tasks: Counts{Overwritten: 1, Skipped: 1},
+			},
+			dates: []string{"2026-12-25"}, cron: "0 2 * * *", payload: `{"command":"new"}`,
+			schedules: []string{"nightly"},
+		},
+		{
+			policy: ConflictRename,
+			want: Result{
+				Calendars: Counts{Renamed: 1}, Schedules: Counts{Renamed: 1},
+				// The second task is imported without the key it can't have.
+				Tasks: Counts{Created: 1, Renamed: 1},
+			},
+			dates: []string{"2026-01-01"}, cron: "0 1 * * *", payload: `{"command":"old"}`,
+			schedules: []string{"nightly", "nightly-imported"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepo(t)
+			if err := repo.PutCalendar(ctx, domain.Calendar{Name: "holidays", Dates: []string{"2026-01-01"}}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := repo.CreateSchedule(ctx, domain.Schedule{
+				ID: "sch_1", Name: "nightly", CronExpr: "0 1 * * *", TaskType: "shell", Payload: []byte(`{}`), Enabled: true,
+			}); err != nil {
+				t.Fatal(err)
+			}
+			old := testTask("tsk_1", "succeeded", &key)
+			old.Payload = []byte(`{"command":"old"}`)
+			if err := repo.ImportTask(ctx, old, nil, false); err != nil {
+				t.Fatal(err)
+			}
+
+			doc := Document{
+				Calendars: []Calendar{{Name: "holidays", Dates: []string{"2026-12-25"}}},
+				Schedules: []Schedule{{
+					ID: "sch_1", Name: "nightly", CronExpr: "0 2 * * *", TaskType: "shell",
+					Payload: []byte(`{}`), Enabled: true, ExcludeCalendars: []string{"holidays"},
+				}},
+				Tasks: []Task{
+					{ID: "tsk_1", Type: "shell", Payload: []byte(`{"command":"new"}`), State: "succeeded", IdempotencyKey: &key, ScheduleID: "sch_1"},
+					{ID: "tsk_2", Type: "shell", Payload: []byte(`{}`), State: "queued", IdempotencyKey: &key},
+				},
+			}
+			res, err := Import(ctx, repo, doc, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Calendars != tt.want.Calendars || res.Schedules != tt.want.Schedules || res.Tasks != tt.want.Tasks {
+				t.Errorf("result %+v, want %+v", res, tt.want)
+			}
+
+			if cal, _ := repo.GetCalendar(ctx, "holidays"); !slices.Equal(cal.Dates, tt.dates) {
+				t.Errorf("calendar dates %v, want %v", cal.Dates, tt.dates)
+			}
+			if s, _ := repo.GetSchedule(ctx, "sch_1"); s.CronExpr != tt.cron {
+				t.Errorf("schedule cron %q, want %q", s.CronExpr, tt.cron)
+			}
+			if task, _ := repo.Get(ctx, "tsk_1"); string(task.Payload) != tt.payload {
+				t.Errorf("task payload %s, want %s", task.Payload, tt.payload)
+			}
+			all, err := repo.ListSchedules(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, s := range all {
+				names = append(names, s.Name)
+				// A renamed calendar is what the renamed schedule excludes.
+				if s.Name == "nightly-imported" && !slices.Equal(s.ExcludeCalendars, []string{"holidays-imported"}) {
+					t.Errorf("renamed schedule excludes %v, want [holidays-imported]", s.ExcludeCalendars)
+				}
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, tt.schedules) {
+				t.Errorf("schedules %v, want %v", names, tt.schedules)
+			}
+
+			if tt.policy == ConflictRename {
+				tasks, err := repo.ListTasks(ctx, queue.TaskFilter{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(tasks) != 3 {
+					t.Errorf("%d tasks, want the original and two copies", len(tasks))
+				}
+				task, err := repo.Get(ctx, "tsk_2")
+				if err != nil || task.IdempotencyKey != nil {
+					t.Errorf("tsk_2 %+v, %v; want it imported without its key", task, err)
+				}
+			}
+		})
+	}
+}
+
+func TestImportAmbiguousScheduleName(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   Counts
+		warn   bool
+		total  int
+	}{
+		{ConflictSkip, Counts{Skipped: 1}, false, 2},
+		{ConflictOverwrite, Counts{Skipped: 1}, true, 2},
+		{ConflictRename, Counts{Renamed: 1}, false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newTestRepo(t)
+			for _, id := range []string{"sch_a", "sch_b"} {
+				if _, err := repo.CreateSchedule(ctx, domain.Schedule{
+					ID: id, Name: "nightly", CronExpr: "0 1 * * *", TaskType: "shell", Payload: []byte(`{}`),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			doc := Document{Schedules: []Schedule{{
+				ID: "sch_new", Name: "nightly", CronExpr: "0 2 * * *", TaskType: "shell", Payload: []byte(`{}`),
+			}}}
+			res, err := Import(ctx, repo, doc, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Schedules != tt.want || (len(res.Warnings) > 0) != tt.warn {
+				t.Errorf("result %+v, want schedules %+v and warning %v", res, tt.want, tt.warn)
+			}
+			// Neither existing schedule was picked to overwrite.
+			all, _ := repo.ListSchedules(ctx)
+			if len(all) != tt.total {
+				t.Errorf("%d schedules, want %d", len(all), tt.total)
+			}
+			for _, s := range all {
+				if s.Name == "nightly" && s.CronExpr != "0 1 * * *" {
+					t.Errorf("schedule %s overwritten", s.ID)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+// Package transfer moves schedules, calendars and tasks between localflow
+// databases in a versioned JSON format.
+//
+// An export is NDJSON: a header line, then one record per line, calendars
+// first, then schedules, then tasks. The same data may also be written as
+// a single JSON document, the header holding every record in its lists.
+// Import reads either form.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"localflow/internal/domain"
+	"localflow/internal/queue"
+)
+
+// Kind identifies a localflow export in its header.
+const Kind = "localflow-export"
+
+// Version is the format version written by Export. Import rejects newer
+// versions.
+const Version = 1
+
+// batchSize is how many tasks Export reads per query.
+const batchSize = 500
+
+// Document is an export's header. In the single document form it also
+// holds every record; in NDJSON its lists are empty and records follow.
+type Document struct {
+	Kind       string     `json:"kind"`
+	Version    int        `json:"version"`
+	ExportedAt time.Time  `json:"exported_at"`
+	Calendars  []Calendar `json:"calendars,omitempty"`
+	Schedules  []Schedule `json:"schedules,omitempty"`
+	Tasks      []Task     `json:"tasks,omitempty"`
+}
+
+// Record is one NDJSON line after the header. Kind says which of the
+// other fields is set.
+type Record struct {
+	Kind     string    `json:"kind"` // calendar, schedule or task
+	Calendar *Calendar `json:"calendar,omitempty"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+	Task     *Task     `json:"task,omitempty"`
+}
+
+type Calendar struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Dates       []string `json:"dates"`
+}
+
+type Schedule struct {
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	CronExpr          string          `json:"cron_expr"`
+	Timezone          string          `json:"timezone,omitempty"`
+	TaskType          string          `json:"task_type"`
+	Payload           json.RawMessage `json:"payload"`
+	Priority          int             `json:"priority"`
+	MaxAttempts       int             `json:"max_attempts"`
+	Enabled           bool            `json:"enabled"`
+	LastRun           *time.Time      `json:"last_run,omitempty"`
+	NextRun           time.Time       `json:"next_run"`
+	RunCount          int             `json:"run_count"`
+	MisfirePolicy     string          `json:"misfire_policy,omitempty"`
+	MisfireGrace      int             `json:"misfire_grace,omitempty"`
+	MaxCatchup        int             `json:"max_catchup,omitempty"`
+	ConcurrencyPolicy string          `json:"concurrency_policy,omitempty"`
+	StartAt           *time.Time      `json:"start_at,omitempty"`
+	EndAt             *time.Time      `json:"end_at,omitempty"`
+	ExcludeCalendars  []string        `json:"exclude_calendars,omitempty"`
+	Jitter            int             `json:"jitter,omitempty"`
+}
+
+// Task is a task with its attempts. Payloads that aren't JSON are carried
+// in PayloadText.
+type Task struct {
+	ID                string          `json:"id"`
+	Type              string          `json:"type"`
+	Payload           json.RawMessage `json:"payload,omitempty"`
+	PayloadText       string          `json:"payload_text,omitempty"`
+	Priority          int             `json:"priority"`
+	Attempts          int             `json:"attempts"`
+	MaxAttempts       int             `json:"max_attempts"`
+	State             string          `json:"state"`
+	NextRunAt         time.Time       `json:"next_run_at"`
+	VisibilityTimeout int             `json:"visibility_timeout"`
+	IdempotencyKey    *string         `json:"idempotency_key,omitempty"`
+	Progress          *float64        `json:"progress,omitempty"`
+	StatusMessage     string          `json:"status_message,omitempty"`
+	ScheduleID        string          `json:"schedule_id,omitempty"`
+	CreatedAt         time.Time       `json:"created_at"`
+	UpdatedAt         time.Time       `json:"updated_at"`
+	ExpiresAt         *time.Time      `json:"expires_at,omitempty"`
+	UniqueKey         string          `json:"unique_key,omitempty"`
+	AttemptLog        []Attempt       `json:"attempt_log,omitempty"`
+}
+
+type Attempt struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Success    bool       `json:"success"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// TaskStates are the states tasks may be exported in.
+var TaskStates = []string{"queued", "running", "succeeded", "failed", "canceled", "expired"}
+
+// ValidateTaskStates checks the states of tasks to export.
+func ValidateTaskStates(states []string) error {
+	for _, state := range states {
+		if !slices.Contains(TaskStates, state) {
+			return fmt.Errorf("unknown task state %q", state)
+		}
+	}
+	return nil
+}
+
+// ExportOptions select what Export writes.
+type ExportOptions struct {
+	// TaskStates exports tasks in these states with their attempts; empty
+	// exports no tasks.
+	TaskStates []string
+	// Document writes a single JSON document instead of NDJSON.
+	Document bool
+}
+
+// Export writes all calendars and schedules, and the tasks opts selects.
+// NDJSON is streamed, reading tasks in batches.
+func Export(ctx context.Context, repo queue.Repository, w io.Writer, opts ExportOptions) error {
+	if err := ValidateTaskStates(opts.TaskStates); err != nil {
+		return err
+	}
+	doc := Document{Kind: Kind, Version: Version, ExportedAt: time.Now().UTC()}
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	emit := func(r Record) error {
+		if opts.Document {
+			switch {
+			case r.Calendar != nil:
+				doc.Calendars = append(doc.Calendars, *r.Calendar)
+			case r.Schedule != nil:
+				doc.Schedules = append(doc.Schedules, *r.Schedule)
+			case r.Task != nil:
+				doc.Tasks = append(doc.Tasks, *r.Task)
+			}
+			return nil
+		}
+		return enc.Encode(r)
+	}
+	if !opts.Document {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+
+	calendars, err := repo.ListCalendars(ctx)
+	if err != nil {
+		return err
+	}
+	for _, c := range calendars {
+		// ListCalendars leaves out the dates.
+		full, err := repo.GetCalendar(ctx, c.Name)
+		if err != nil {
+			return err
+		}
+		if err := emit(Record{Kind: "calendar", Calendar: exportCalendar(full)}); err != nil {
+			return err
+		}
+	}
+
+	schedules, err := repo.ListSchedules(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range schedules {
+		if err := emit(Record{Kind: "schedule", Schedule: exportSchedule(s)}); err != nil {
+			return err
+		}
+	}
+
+	after := ""
+	for len(opts.TaskStates) > 0 {
+		tasks, err := repo.TasksAfter(ctx, opts.TaskStates, after, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			attempts, err := repo.ListTaskAttempts(ctx, t.ID)
+			if err != nil {
+				return err
+			}
+			if err := emit(Record{Kind: "task", Task: exportTask(t, attempts)}); err != nil {
+				return err
+			}
+		}
+		if len(tasks) < batchSize {
+			break
+		}
+		after = tasks[len(tasks)-1].ID
+	}
+
+	if opts.Document {
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Writer writes NDJSON records one at a time after the header, e.g. to
+// archive tasks as they are deleted. The output is a valid export.
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter writes the header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(Document{Kind: Kind, Version: Version, ExportedAt: time.Now().UTC()}); err != nil {
+		return nil, err
+	}
+	return &Writer{enc: enc}, nil
+}
+
+// Task writes a task record.
+func (w *Writer) Task(t domain.Task, attempts []domain.TaskAttempt) error {
+	return w.enc.Encode(Record{Kind: "task", Task: exportTask(t, attempts)})
+}
+
+func exportCalendar(c domain.Calendar) *Calendar {
+	dates := c.Dates
+	if dates == nil {
+		dates = []string{}
+	}
+	return &Calendar{Name: c.Name, Description: c.Description, Dates: dates}
+}
+
+func exportSchedule(s domain.Schedule) *Schedule {
+	return &Schedule{
+		ID:                s.ID,
+		Name:              s.Name,
+		CronExpr:          s.CronExpr,
+		Timezone:          s.Timezone,
+		TaskType:          s.TaskType,
+		Payload:           s.Payload,
+		Priority:          s.Priority,
+		MaxAttempts:       s.MaxAttempts,
+		Enabled:           s.Enabled,
+		LastRun:           s.LastRun,
+		NextRun:           s.NextRun,
+		RunCount:          s.RunCount,
+		MisfirePolicy:     s.MisfirePolicy,
+		MisfireGrace:      s.MisfireGrace,
+		MaxCatchup:        s.MaxCatchup,
+		ConcurrencyPolicy: s.ConcurrencyPolicy,
+		StartAt:           s.StartAt,
+		EndAt:             s.EndAt,
+		ExcludeCalendars:  s.ExcludeCalendars,
+		Jitter:            s.Jitter,
+	}
+}
+
+func exportTask(t domain.Task, attempts []domain.TaskAttempt) *Task {
+	out := &Task{
+		ID:                t.ID,
+		Type:              t.Type,
+		Priority:          t.Priority,
+		Attempts:          t.Attempts,
+		MaxAttempts:       t.MaxAttempts,
+		State:             t.State,
+		NextRunAt:         t.NextRunAt,
+		VisibilityTimeout: t.VisibilityTimeout,
+		IdempotencyKey:    t.IdempotencyKey,
+		Progress:          t.Progress,
+		StatusMessage:     t.StatusMessage,
+		ScheduleID:        t.ScheduleID,
+		CreatedAt:         t.CreatedAt,
+		UpdatedAt:         t.UpdatedAt,
+		ExpiresAt:         t.ExpiresAt,
+		UniqueKey:         t.UniqueKey,
+	}
+	if json.Valid(t.Payload) {
+		out.Payload = t.Payload
+	} else {
+		out.PayloadText = string(t.Payload)
+	}
+	for _, a := range attempts {
+		out.AttemptLog = append(out.AttemptLog, Attempt{StartedAt: a.StartedAt, FinishedAt: a.FinishedAt, Success: a.Success, Error: a.Error})
+	}
+	return out
+}
+
+// Read parses an export in either form into a single document.
+func Read(r io.Reader) (Document, error) {
+	dec := json.NewDecoder(r)
+	var doc Document
+	if err := dec.Decode(&doc); err != nil {
+		return Document{}, fmt.Errorf("header: %w", err)
+	}
+	if doc.Kind != Kind {
+		return Document{}, errors.New("not a localflow export")
+	}
+	if doc.Version < 1 || doc.Version > Version {
+		return Document{}, fmt.Errorf("unsupported export version %d (this server reads up to %d)", doc.Version, Version)
+	}
+	for line := 2; ; line++ {
+		var rec Record
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Document{}, fmt.Errorf("record %d: %w", line, err)
+		}
+		switch {
+		case rec.Kind == "calendar" && rec.Calendar != nil:
+			doc.Calendars = append(doc.Calendars, *rec.Calendar)
+		case rec.Kind == "schedule" && rec.Schedule != nil:
+			doc.Schedules = append(doc.Schedules, *rec.Schedule)
+		case rec.Kind == "task" && rec.Task != nil:
+			doc.Tasks = append(doc.Tasks, *rec.Task)
+		default:
+			return Document{}, fmt.Errorf("record %d: unknown or empty record %q", line, rec.Kind)
+		}
+	}
+	return doc, nil
+}
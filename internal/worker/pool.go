@@ -3,26 +3,66 @@ package worker
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"localflow/internal/domain"
+	"localflow/internal/progress"
 	"localflow/internal/queue"
+	"localflow/internal/tasklog"
 )
 
 type Handler interface {
 	Handle(ctx context.Context, payload json.RawMessage) error
 }
 
+// ErrPermanent marks handler errors that retrying cannot fix. The pool
+// fails such tasks immediately instead of scheduling another attempt.
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent wraps err so that errors.Is(err, ErrPermanent) holds.
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
+
+// HandlerOptions tune how the pool runs one task type. Zero values keep the
+// defaults: the task's visibility timeout, no per-type limit and 1s..60s
+// exponential backoff.
+type HandlerOptions struct {
+	Timeout     time.Duration
+	Concurrency int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
 type Pool struct {
 	repo      queue.Repository
 	handlers  map[string]Handler
+	options   map[string]HandlerOptions
 	sem       chan struct{}
 	stop      chan struct{}
 	pollEvery time.Duration
+
+	mu      sync.Mutex
+	running map[string]int // in-flight tasks per type
 }
 
 func NewPool(repo queue.Repository, handlers map[string]Handler, size int, pollEvery time.Duration) *Pool {
-	return &Pool{repo: repo, handlers: handlers, sem: make(chan struct{}, size), stop: make(chan struct{}), pollEvery: pollEvery}
+	return &Pool{
+		repo: repo, handlers: handlers, options: map[string]HandlerOptions{},
+		sem: make(chan struct{}, size), stop: make(chan struct{}), pollEvery: pollEvery,
+		running: map[string]int{},
+	}
+}
+
+// WithHandlerOptions sets per task type options. It must be called before Run.
+func (p *Pool) WithHandlerOptions(opts map[string]HandlerOptions) *Pool {
+	for typ, o := range opts {
+		p.options[typ] = o
+	}
+	return p
 }
 
 func (p *Pool) Run(ctx context.Context) {
@@ -36,23 +76,44 @@ func (p *Pool) Run(ctx context.Context) {
 			return
 		case now := <-t.C:
 			for {
-				task, lease, err := p.repo.LeaseNext(ctx, now)
+				task, lease, err := p.repo.LeaseNext(ctx, now, p.saturatedTypes()...)
 				if err != nil {
 					break
 				}
 				_ = lease // reserved for future
 				p.sem <- struct{}{}
+				p.track(task.Type, 1)
 				go func(tk domain.Task) {
 					defer func() { <-p.sem }()
+					defer p.track(tk.Type, -1)
 					h, ok := p.handlers[tk.Type]
 					if !ok {
 						_ = p.repo.Fail(ctx, tk.ID, "no handler", 0)
 						return
 					}
-					c, cancel := context.WithTimeout(ctx, time.Duration(tk.VisibilityTimeout)*time.Second)
+					opts := p.options[tk.Type]
+					timeout := time.Duration(tk.VisibilityTimeout) * time.Second
+					if opts.Timeout > 0 {
+						timeout = opts.Timeout
+					}
+					c, cancel := context.WithTimeout(ctx, timeout)
 					defer cancel()
-					if err := h.Handle(c, tk.Payload); err != nil {
-						next := backoffExp(tk.Attempts)
+					go p.watchCancel(c, cancel, tk.ID)
+					logger := tasklog.New(p.repo, tk.ID, tk.Attempts+1)
+					reporter := progress.New(p.repo, tk.ID)
+					hctx := progress.WithReporter(tasklog.WithLogger(c, logger), reporter)
+					err := h.Handle(hctx, tk.Payload)
+					if err != nil {
+						logger.Printf("attempt %d failed: %v", tk.Attempts+1, err)
+					}
+					reporter.Close()
+					logger.Close()
+					if errors.Is(err, ErrPermanent) {
+						_ = p.repo.Fail(ctx, tk.ID, err.Error(), 0)
+						return
+					}
+					if err != nil {
+						next := backoffExp(tk.Attempts, opts.BackoffBase, opts.BackoffMax)
 						_ = p.repo.Retry(ctx, tk.ID, err.Error(), next)
 						return
 					}
@@ -63,13 +124,62 @@ func (p *Pool) Run(ctx context.Context) {
 	}
 }
 
-func backoffExp(attempts int) time.Duration {
+// cancelPoll is how often a running task is checked for cancellation.
+const cancelPoll = 2 * time.Second
+
+// watchCancel stops a running handler once its task is canceled, either
+// through the API or by a schedule replacing an earlier run.
+func (p *Pool) watchCancel(ctx context.Context, cancel context.CancelFunc, id string) {
+	t := time.NewTicker(cancelPoll)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if tk, err := p.repo.Get(ctx, id); err == nil && tk.State == "canceled" {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+func (p *Pool) track(taskType string, delta int) {
+	p.mu.Lock()
+	p.running[taskType] += delta
+	p.mu.Unlock()
+}
+
+// saturatedTypes lists task types that reached their concurrency limit.
+func (p *Pool) saturatedTypes() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var types []string
+	for typ, o := range p.options {
+		if o.Concurrency > 0 && p.running[typ] >= o.Concurrency {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+func backoffExp(attempts int, base, max time.Duration) time.Duration {
+	if base <= 0 {
+		base = time.Second
+	}
+	if max <= 0 {
+		max = 60 * time.Second
+	}
 	if attempts <= 0 {
-		return time.Second
+		return base
+	}
+	d := base
+	for i := 1; i < attempts && d < max; i++ {
+		d *= 2 // base, 2*base, 4*base...
 	}
-	d := 1 << (attempts - 1) // 1,2,4,8...
-	if d > 60 {
-		d = 60
+	if d > max {
+		d = max
 	}
-	return time.Duration(d) * time.Second
+	return d
 }
@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN progress REAL;
+ALTER TABLE tasks ADD COLUMN status_message TEXT;
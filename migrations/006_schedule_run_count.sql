@@ -0,0 +1 @@
+ALTER TABLE schedules ADD COLUMN run_count INTEGER NOT NULL DEFAULT 0;
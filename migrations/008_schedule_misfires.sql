@@ -0,0 +1,13 @@
+ALTER TABLE schedules ADD COLUMN misfire_policy TEXT NOT NULL DEFAULT '';
+ALTER TABLE schedules ADD COLUMN misfire_grace INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE schedules ADD COLUMN max_catchup INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS schedule_misfires (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  schedule_id TEXT NOT NULL,
+  fire_time DATETIME NOT NULL,
+  reason TEXT NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY(schedule_id) REFERENCES schedules(id)
+);
+CREATE INDEX IF NOT EXISTS idx_schedule_misfires_schedule ON schedule_misfires(schedule_id, id);
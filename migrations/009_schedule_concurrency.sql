@@ -0,0 +1,4 @@
+ALTER TABLE tasks ADD COLUMN schedule_id TEXT;
+CREATE INDEX IF NOT EXISTS idx_tasks_schedule ON tasks(schedule_id, state);
+
+ALTER TABLE schedules ADD COLUMN concurrency_policy TEXT NOT NULL DEFAULT '';
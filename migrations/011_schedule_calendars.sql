@@ -0,0 +1,16 @@
+ALTER TABLE schedules ADD COLUMN start_at DATETIME;
+ALTER TABLE schedules ADD COLUMN end_at DATETIME;
+ALTER TABLE schedules ADD COLUMN exclude_calendars TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS calendars (
+  name TEXT PRIMARY KEY,
+  description TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS calendar_dates (
+  calendar TEXT NOT NULL,
+  date TEXT NOT NULL,
+  PRIMARY KEY(calendar, date),
+  FOREIGN KEY(calendar) REFERENCES calendars(name)
+);
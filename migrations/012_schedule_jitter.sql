@@ -0,0 +1 @@
+ALTER TABLE schedules ADD COLUMN jitter INTEGER NOT NULL DEFAULT 0;
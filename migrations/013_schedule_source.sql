@@ -0,0 +1 @@
+ALTER TABLE schedules ADD COLUMN source TEXT NOT NULL DEFAULT '';
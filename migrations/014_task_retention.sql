@@ -0,0 +1,2 @@
+CREATE INDEX idx_tasks_state_updated ON tasks(state, updated_at);
+CREATE INDEX idx_task_attempts_task ON task_attempts(task_id);
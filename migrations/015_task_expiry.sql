@@ -0,0 +1,28 @@
+ALTER TABLE tasks ADD COLUMN expires_at DATETIME;
+CREATE TABLE tasks_new (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  payload BLOB NOT NULL,
+  priority INTEGER NOT NULL DEFAULT 5,
+  state TEXT NOT NULL CHECK(state IN ('queued','running','succeeded','failed','canceled','expired')) DEFAULT 'queued',
+  attempts INTEGER NOT NULL DEFAULT 0,
+  max_attempts INTEGER NOT NULL DEFAULT 5,
+  next_run_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  visibility_timeout INTEGER NOT NULL DEFAULT 60,
+  idempotency_key TEXT,
+  progress REAL,
+  status_message TEXT,
+  schedule_id TEXT,
+  created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME
+);
+INSERT INTO tasks_new (id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,progress,status_message,schedule_id,created_at,updated_at,expires_at)
+SELECT id,type,payload,priority,attempts,max_attempts,state,next_run_at,visibility_timeout,idempotency_key,progress,status_message,schedule_id,created_at,updated_at,expires_at FROM tasks;
+DROP TABLE tasks;
+ALTER TABLE tasks_new RENAME TO tasks;
+CREATE INDEX idx_tasks_next_run ON tasks(state, next_run_at, priority DESC);
+CREATE UNIQUE INDEX idx_tasks_idem ON tasks(idempotency_key) WHERE idempotency_key IS NOT NULL;
+CREATE INDEX idx_tasks_state_updated ON tasks(state, updated_at);
+CREATE INDEX idx_tasks_schedule ON tasks(schedule_id, state);
+CREATE INDEX idx_tasks_expires ON tasks(expires_at) WHERE expires_at IS NOT NULL;
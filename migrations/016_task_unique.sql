@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN unique_key TEXT;
+CREATE INDEX idx_tasks_unique ON tasks(type, unique_key, state) WHERE unique_key IS NOT NULL;